@@ -0,0 +1,239 @@
+package audit
+
+import (
+	"context"
+	"time"
+
+	"github.com/duolacloud/crud-core/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type Operation string
+
+const (
+	OperationCreate Operation = "create"
+	OperationUpdate Operation = "update"
+	OperationDelete Operation = "delete"
+)
+
+type Mode string
+
+const (
+	// ModeSnapshot 保存修改前后的完整文档
+	ModeSnapshot Mode = "snapshot"
+	// ModeDiff 只保存变化的字段
+	ModeDiff Mode = "diff"
+)
+
+// ActorFunc 从 context 中取出操作人
+type ActorFunc func(c context.Context) any
+
+// Record 是一次变更，Tenant 是实体所属的租户，查询历史时按租户过滤
+type Record struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	EntityID  any                `bson:"entity_id"`
+	Tenant    string             `bson:"tenant,omitempty"`
+	Operation Operation          `bson:"operation"`
+	Actor     any                `bson:"actor,omitempty"`
+	Timestamp time.Time          `bson:"timestamp"`
+	Before    bson.M             `bson:"before,omitempty"`
+	After     bson.M             `bson:"after,omitempty"`
+	Changes   []Change           `bson:"changes,omitempty"`
+}
+
+type TrailOptions struct {
+	Actor ActorFunc
+	Mode  Mode
+}
+
+type TrailOption func(*TrailOptions)
+
+func WithActor(actor ActorFunc) TrailOption {
+	return func(o *TrailOptions) {
+		o.Actor = actor
+	}
+}
+
+func WithMode(mode Mode) TrailOption {
+	return func(o *TrailOptions) {
+		o.Mode = mode
+	}
+}
+
+// Trail 把实体的变更历史写入单独的集合
+type Trail struct {
+	collection *mongo.Collection
+	options    *TrailOptions
+}
+
+func NewTrail(collection *mongo.Collection, opts ...TrailOption) *Trail {
+	t := &Trail{
+		collection: collection,
+		options: &TrailOptions{
+			Mode: ModeSnapshot,
+		},
+	}
+	for _, o := range opts {
+		o(t.options)
+	}
+	return t
+}
+
+func (t *Trail) Actor(c context.Context) any {
+	if t.options.Actor == nil {
+		return nil
+	}
+	return t.options.Actor(c)
+}
+
+func (t *Trail) EnsureIndexes(c context.Context) error {
+	_, err := t.collection.Indexes().CreateOne(c, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "entity_id", Value: 1},
+			{Key: "timestamp", Value: 1},
+		},
+	})
+	return err
+}
+
+type RecordOption func(*Record)
+
+// WithRecordTenant 记录实体所属的租户
+func WithRecordTenant(tenant string) RecordOption {
+	return func(r *Record) {
+		r.Tenant = tenant
+	}
+}
+
+// Record 记录一次变更，create 时 before 为 nil，delete 时 after 为 nil
+func (t *Trail) Record(c context.Context, op Operation, entityID any, before bson.M, after bson.M, opts ...RecordOption) error {
+	_, err := t.collection.InsertOne(c, t.newRecord(c, op, entityID, before, after, opts...))
+	return err
+}
+
+func (t *Trail) newRecord(c context.Context, op Operation, entityID any, before bson.M, after bson.M, opts ...RecordOption) *Record {
+	record := &Record{
+		ID:        primitive.NewObjectID(),
+		EntityID:  entityID,
+		Operation: op,
+		Actor:     t.Actor(c),
+		Timestamp: time.Now(),
+		Changes:   Diff(before, after),
+	}
+	for _, o := range opts {
+		o(record)
+	}
+
+	if t.options.Mode == ModeSnapshot {
+		record.Before = before
+		record.After = after
+	}
+	return record
+}
+
+type HistoryOptions struct {
+	Since  *time.Time
+	Until  *time.Time
+	Limit  int64
+	Tenant *string
+}
+
+type HistoryOption func(*HistoryOptions)
+
+func WithSince(t time.Time) HistoryOption {
+	return func(o *HistoryOptions) {
+		o.Since = &t
+	}
+}
+
+func WithUntil(t time.Time) HistoryOption {
+	return func(o *HistoryOptions) {
+		o.Until = &t
+	}
+}
+
+func WithLimit(v int64) HistoryOption {
+	return func(o *HistoryOptions) {
+		o.Limit = v
+	}
+}
+
+// WithTenant 只返回该租户的记录
+func WithTenant(tenant string) HistoryOption {
+	return func(o *HistoryOptions) {
+		o.Tenant = &tenant
+	}
+}
+
+// History 按时间顺序返回实体的变更记录
+func (t *Trail) History(c context.Context, entityID any, opts ...HistoryOption) ([]*Record, error) {
+	var _opts HistoryOptions
+	for _, o := range opts {
+		o(&_opts)
+	}
+
+	filter := bson.M{"entity_id": entityID}
+	if _opts.Tenant != nil {
+		filter["tenant"] = *_opts.Tenant
+	}
+	timestamp := bson.M{}
+	if _opts.Since != nil {
+		timestamp["$gte"] = *_opts.Since
+	}
+	if _opts.Until != nil {
+		timestamp["$lte"] = *_opts.Until
+	}
+	if len(timestamp) > 0 {
+		filter["timestamp"] = timestamp
+	}
+
+	findOpts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: 1}, {Key: "_id", Value: 1}})
+	if _opts.Limit > 0 {
+		findOpts.SetLimit(_opts.Limit)
+	}
+
+	cursor, err := t.collection.Find(c, filter, findOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []*Record
+	err = cursor.All(c, &records)
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// StateAt 还原实体在 at 时刻的状态，实体当时不存在或已删除时返回 types.ErrNotFound
+func (t *Trail) StateAt(c context.Context, entityID any, at time.Time, opts ...HistoryOption) (bson.M, error) {
+	records, err := t.History(c, entityID, append(opts, WithUntil(at))...)
+	if err != nil {
+		return nil, err
+	}
+
+	state := replay(records)
+	if state == nil {
+		return nil, types.ErrNotFound
+	}
+	return state, nil
+}
+
+// replay 按顺序应用变更记录，返回最后的状态，最后一条是删除时返回 nil
+func replay(records []*Record) bson.M {
+	var state bson.M
+	for _, record := range records {
+		switch {
+		case record.Operation == OperationDelete:
+			state = nil
+		case record.After != nil:
+			state = record.After
+		default:
+			state = Apply(state, record.Changes)
+		}
+	}
+	return state
+}
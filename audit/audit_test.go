@@ -0,0 +1,32 @@
+package audit
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestReplay(t *testing.T) {
+	c := context.Background()
+
+	// 修改前后都是数据库中的原始文档，DTO 中没有的租户字段不会被记录为删除
+	created := bson.M{"_id": "1", "tenant": "t1", "name": "a", "age": 1}
+	updated := bson.M{"_id": "1", "tenant": "t1", "name": "b", "age": 1}
+
+	for _, mode := range []Mode{ModeSnapshot, ModeDiff} {
+		trail := NewTrail(nil, WithMode(mode))
+
+		records := []*Record{
+			trail.newRecord(c, OperationCreate, "1", nil, created),
+			trail.newRecord(c, OperationUpdate, "1", created, updated),
+			trail.newRecord(c, OperationDelete, "1", updated, nil),
+		}
+		assert.Equal(t, []Change{{Field: "name", Before: "a", After: "b"}}, records[1].Changes, mode)
+
+		assert.Equal(t, created, replay(records[:1]), mode)
+		assert.Equal(t, updated, replay(records[:2]), mode)
+		assert.Nil(t, replay(records), mode)
+	}
+}
@@ -0,0 +1,112 @@
+package audit
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type Change struct {
+	Field   string `bson:"field"`
+	Before  any    `bson:"before,omitempty"`
+	After   any    `bson:"after,omitempty"`
+	Removed bool   `bson:"removed,omitempty"`
+}
+
+// Diff 比较两个文档，按字段路径(a.b.c)返回变化的字段，数组作为整体比较
+func Diff(before bson.M, after bson.M) []Change {
+	b := map[string]any{}
+	flatten("", before, b)
+	a := map[string]any{}
+	flatten("", after, a)
+
+	var changes []Change
+	for field, av := range a {
+		bv, ok := b[field]
+		if ok && reflect.DeepEqual(bv, av) {
+			continue
+		}
+		changes = append(changes, Change{
+			Field:  field,
+			Before: bv,
+			After:  av,
+		})
+	}
+
+	for field, bv := range b {
+		if _, ok := a[field]; !ok {
+			changes = append(changes, Change{
+				Field:   field,
+				Before:  bv,
+				Removed: true,
+			})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+	return changes
+}
+
+// Apply 在 doc 上按顺序应用变化，返回新的文档
+func Apply(doc bson.M, changes []Change) bson.M {
+	flat := map[string]any{}
+	flatten("", doc, flat)
+
+	for _, change := range changes {
+		// 字段从文档变为标量或反过来时，先清理掉旧路径
+		for field := range flat {
+			if strings.HasPrefix(field, change.Field+".") || strings.HasPrefix(change.Field, field+".") {
+				delete(flat, field)
+			}
+		}
+
+		if change.Removed {
+			delete(flat, change.Field)
+			continue
+		}
+		flat[change.Field] = change.After
+	}
+
+	r := bson.M{}
+	for field, v := range flat {
+		setPath(r, strings.Split(field, "."), v)
+	}
+	return r
+}
+
+func flatten(prefix string, doc bson.M, out map[string]any) {
+	for k, v := range doc {
+		field := prefix + k
+		switch t := v.(type) {
+		case bson.M:
+			if len(t) > 0 {
+				flatten(field+".", t, out)
+				continue
+			}
+		case bson.D:
+			if len(t) > 0 {
+				flatten(field+".", t.Map(), out)
+				continue
+			}
+		}
+		out[field] = v
+	}
+}
+
+func setPath(doc bson.M, path []string, v any) {
+	if len(path) == 1 {
+		doc[path[0]] = v
+		return
+	}
+
+	sub, ok := doc[path[0]].(bson.M)
+	if !ok {
+		sub = bson.M{}
+		doc[path[0]] = sub
+	}
+	setPath(sub, path[1:], v)
+}
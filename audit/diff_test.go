@@ -0,0 +1,39 @@
+package audit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestDiffAndApply(t *testing.T) {
+	before := bson.M{
+		"name": "张三",
+		"age":  18,
+		"address": bson.M{
+			"city":   "beijing",
+			"street": "a",
+		},
+		"tags": bson.A{"a"},
+	}
+	after := bson.M{
+		"name": "李四",
+		"age":  18,
+		"address": bson.M{
+			"city": "shanghai",
+		},
+		"tags": bson.A{"a", "b"},
+	}
+
+	changes := Diff(before, after)
+	assert.Equal(t, []Change{
+		{Field: "address.city", Before: "beijing", After: "shanghai"},
+		{Field: "address.street", Before: "a", Removed: true},
+		{Field: "name", Before: "张三", After: "李四"},
+		{Field: "tags", Before: bson.A{"a"}, After: bson.A{"a", "b"}},
+	}, changes)
+
+	assert.Equal(t, after, Apply(before, changes))
+	assert.Equal(t, after, Apply(nil, Diff(nil, after)))
+}
@@ -0,0 +1,112 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	core_cache "github.com/duolacloud/crud-core/cache"
+	"github.com/duolacloud/crud-core/types"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// LRU 是进程内的 core_cache.Cache 实现，超过容量时淘汰最久没有访问的条目。
+// 值以 bson 编码保存，读取时解码出新的对象，调用方修改返回值不会影响缓存
+type LRU struct {
+	mutex    sync.Mutex
+	capacity int
+	items    map[string]*list.Element
+	order    *list.List
+	now      func() time.Time
+}
+
+type lruItem struct {
+	key       string
+	data      bson.Raw
+	expiresAt time.Time
+}
+
+func NewLRU(capacity int) *LRU {
+	return &LRU{
+		capacity: capacity,
+		items:    map[string]*list.Element{},
+		order:    list.New(),
+		now:      time.Now,
+	}
+}
+
+func (l *LRU) Get(c context.Context, key string, value any, opts ...core_cache.GetOption) error {
+	l.mutex.Lock()
+	e, ok := l.items[key]
+	if !ok {
+		l.mutex.Unlock()
+		return types.ErrNotFound
+	}
+
+	item := e.Value.(*lruItem)
+	if !item.expiresAt.IsZero() && !l.now().Before(item.expiresAt) {
+		l.remove(e)
+		l.mutex.Unlock()
+		return types.ErrNotFound
+	}
+	l.order.MoveToFront(e)
+	data := item.data
+	l.mutex.Unlock()
+
+	return data.Lookup("v").Unmarshal(value)
+}
+
+func (l *LRU) Set(c context.Context, key string, value any, opts ...core_cache.SetOption) error {
+	var _opts core_cache.SetOptions
+	for _, o := range opts {
+		o(&_opts)
+	}
+
+	data, err := bson.Marshal(bson.D{{Key: "v", Value: value}})
+	if err != nil {
+		return err
+	}
+
+	item := &lruItem{key: key, data: data}
+	if _opts.Exipration > 0 {
+		item.expiresAt = l.now().Add(_opts.Exipration)
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if e, ok := l.items[key]; ok {
+		e.Value = item
+		l.order.MoveToFront(e)
+		return nil
+	}
+
+	l.items[key] = l.order.PushFront(item)
+	for l.capacity > 0 && l.order.Len() > l.capacity {
+		l.remove(l.order.Back())
+	}
+	return nil
+}
+
+func (l *LRU) Delete(c context.Context, key string, opts ...core_cache.DeleteOption) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if e, ok := l.items[key]; ok {
+		l.remove(e)
+	}
+	return nil
+}
+
+// Len 返回当前的条目数量，包括已过期但还没有被清理的条目
+func (l *LRU) Len() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.order.Len()
+}
+
+func (l *LRU) remove(e *list.Element) {
+	l.order.Remove(e)
+	delete(l.items, e.Value.(*lruItem).key)
+}
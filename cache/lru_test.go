@@ -0,0 +1,46 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	core_cache "github.com/duolacloud/crud-core/cache"
+	"github.com/duolacloud/crud-core/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLRU(t *testing.T) {
+	c := context.Background()
+	l := NewLRU(2)
+
+	type item struct {
+		Name string `bson:"name"`
+	}
+
+	assert.NoError(t, l.Set(c, "a", &item{Name: "a"}))
+	assert.NoError(t, l.Set(c, "b", &item{Name: "b"}))
+
+	var got item
+	assert.NoError(t, l.Get(c, "a", &got))
+	assert.Equal(t, "a", got.Name)
+
+	// b 最久没有访问，被淘汰
+	assert.NoError(t, l.Set(c, "c", &item{Name: "c"}))
+	assert.ErrorIs(t, l.Get(c, "b", &got), types.ErrNotFound)
+	assert.Equal(t, 2, l.Len())
+
+	assert.NoError(t, l.Delete(c, "a"))
+	assert.ErrorIs(t, l.Get(c, "a", &got), types.ErrNotFound)
+
+	now := time.Now()
+	l.now = func() time.Time { return now }
+	assert.NoError(t, l.Set(c, "d", int64(1), core_cache.WithExpiration(time.Minute)))
+
+	var n int64
+	assert.NoError(t, l.Get(c, "d", &n))
+	assert.Equal(t, int64(1), n)
+
+	now = now.Add(time.Minute)
+	assert.ErrorIs(t, l.Get(c, "d", &n), types.ErrNotFound)
+}
@@ -0,0 +1,356 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/duolacloud/crud-core-mongo/ids"
+	"github.com/duolacloud/crud-core-mongo/repositories"
+	"github.com/duolacloud/crud-core-mongo/utils"
+	core_cache "github.com/duolacloud/crud-core/cache"
+	core_repositories "github.com/duolacloud/crud-core/repositories"
+	"github.com/duolacloud/crud-core/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/sync/singleflight"
+)
+
+// Scope 返回调用方所在的缓存分区，如租户 ID。不同分区看到的数据不同时(租户隔离、行级限制、
+// 字段脱敏、按调用设置的排序规则等)必须返回不同的值，否则会把一个调用方的结果返回给另一个调用方
+type Scope func(c context.Context) (string, error)
+
+// ErrScopeRequired 表示被缓存的仓储的结果取决于调用方，但没有配置 Scope
+var ErrScopeRequired = errors.New("cache scope is required for a repository whose results depend on the caller")
+
+// callerDependent 由结果取决于调用方的仓储实现(租户隔离、行级限制、字段脱敏)，
+// 这时必须配置 Scope，否则 Get/Query 返回 ErrScopeRequired
+type callerDependent interface {
+	CallerDependent() bool
+}
+
+type Options struct {
+	// Prefix 加在所有缓存 key 前面，同一个缓存中存放多个集合时用来区分
+	Prefix     string
+	Expiration time.Duration
+	// QueryExpiration 大于 0 时缓存 Query 的结果
+	QueryExpiration time.Duration
+	Scope           Scope
+	// IDStrategy 用来把外部传入的 ID 和 change stream 中的 _id 转换为相同的 key
+	IDStrategy ids.Strategy
+	// FetchTimeout 是合并后读取数据库的超时时间，读取不受单个调用方取消的影响
+	FetchTimeout time.Duration
+	// Versions 保存版本号，默认和缓存条目使用同一个缓存
+	Versions core_cache.Cache
+}
+
+type Option func(*Options)
+
+func WithPrefix(prefix string) Option {
+	return func(o *Options) {
+		o.Prefix = prefix
+	}
+}
+
+func WithExpiration(d time.Duration) Option {
+	return func(o *Options) {
+		o.Expiration = d
+	}
+}
+
+// WithQueryCache 按规范化后的查询条件缓存 Query 的结果，任何写操作都会让所有查询缓存失效
+func WithQueryCache(expiration time.Duration) Option {
+	return func(o *Options) {
+		o.QueryExpiration = expiration
+	}
+}
+
+func WithScope(scope Scope) Option {
+	return func(o *Options) {
+		o.Scope = scope
+	}
+}
+
+func WithIDStrategy(s ids.Strategy) Option {
+	return func(o *Options) {
+		o.IDStrategy = s
+	}
+}
+
+func WithFetchTimeout(d time.Duration) Option {
+	return func(o *Options) {
+		o.FetchTimeout = d
+	}
+}
+
+// WithVersionCache 把版本号保存在单独的缓存中，不和缓存条目争抢容量
+func WithVersionCache(versions core_cache.Cache) Option {
+	return func(o *Options) {
+		o.Versions = versions
+	}
+}
+
+// Repository 给仓储加上读穿透缓存：Get 按 ID 缓存，可选地按查询条件缓存 Query。
+//
+// 失效通过版本号实现：每个 ID 和所有查询各有一个版本号保存在缓存中，写操作更新版本号，
+// 缓存条目记录读取数据库之前的版本号，不一致时视为未命中。这样不需要枚举各个分区的 key，
+// 使用共享的缓存(如 redis)时其他实例的写入也能生效。
+// 版本号被淘汰后会重新生成为当前时间，不会回到旧值，之前的条目都视为未命中
+type Repository[DTO any, CreateDTO any, UpdateDTO any] struct {
+	core_repositories.CrudRepository[DTO, CreateDTO, UpdateDTO]
+	cache   core_cache.Cache
+	options *Options
+	group   singleflight.Group
+	// err 是配置错误，Get/Query 直接返回它
+	err error
+}
+
+type entry[T any] struct {
+	Version int64 `bson:"version"`
+	Value   T     `bson:"value"`
+}
+
+func NewRepository[DTO any, CreateDTO any, UpdateDTO any](
+	repository core_repositories.CrudRepository[DTO, CreateDTO, UpdateDTO],
+	cache core_cache.Cache,
+	opts ...Option,
+) *Repository[DTO, CreateDTO, UpdateDTO] {
+	r := &Repository[DTO, CreateDTO, UpdateDTO]{
+		CrudRepository: repository,
+		cache:          cache,
+		options: &Options{
+			FetchTimeout: 10 * time.Second,
+		},
+	}
+	for _, o := range opts {
+		o(r.options)
+	}
+	if r.options.Versions == nil {
+		r.options.Versions = cache
+	}
+	if d, ok := repository.(callerDependent); ok && d.CallerDependent() && r.options.Scope == nil {
+		r.err = ErrScopeRequired
+	}
+	return r
+}
+
+func (r *Repository[DTO, CreateDTO, UpdateDTO]) Create(c context.Context, createDTO *CreateDTO, opts ...types.CreateOption) (*DTO, error) {
+	defer r.InvalidateQueries(c)
+	return r.CrudRepository.Create(c, createDTO, opts...)
+}
+
+func (r *Repository[DTO, CreateDTO, UpdateDTO]) CreateMany(c context.Context, items []*CreateDTO, opts ...types.CreateManyOption) ([]*DTO, error) {
+	defer r.InvalidateQueries(c)
+	return r.CrudRepository.CreateMany(c, items, opts...)
+}
+
+// Update 和 Delete 无论成功与否都会让缓存失效，超时等错误时写入可能已经生效
+func (r *Repository[DTO, CreateDTO, UpdateDTO]) Update(c context.Context, id types.ID, updateDTO *UpdateDTO, opts ...types.UpdateOption) (*DTO, error) {
+	defer r.Invalidate(c, id)
+	return r.CrudRepository.Update(c, id, updateDTO, opts...)
+}
+
+func (r *Repository[DTO, CreateDTO, UpdateDTO]) Delete(c context.Context, id types.ID) error {
+	defer r.Invalidate(c, id)
+	return r.CrudRepository.Delete(c, id)
+}
+
+func (r *Repository[DTO, CreateDTO, UpdateDTO]) Get(c context.Context, id types.ID) (*DTO, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	scope, err := r.scope(c)
+	if err != nil {
+		return nil, err
+	}
+
+	key := r.formatID(id)
+	return load(c, r, r.versionKey("id:"+key), scope+"id:"+key, r.options.Expiration, func(c context.Context) (*DTO, error) {
+		return r.CrudRepository.Get(c, id)
+	})
+}
+
+func (r *Repository[DTO, CreateDTO, UpdateDTO]) Query(c context.Context, query *types.PageQuery) ([]*DTO, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.options.QueryExpiration <= 0 {
+		return r.CrudRepository.Query(c, query)
+	}
+
+	hash, err := hashQuery(query)
+	if err != nil {
+		// 无法规范化的查询不缓存
+		return r.CrudRepository.Query(c, query)
+	}
+
+	scope, err := r.scope(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return load(c, r, r.versionKey("query"), scope+"query:"+hash, r.options.QueryExpiration, func(c context.Context) ([]*DTO, error) {
+		return r.CrudRepository.Query(c, query)
+	})
+}
+
+// Invalidate 让 id 对应的缓存和所有查询缓存失效，在其他途径修改了数据后调用
+func (r *Repository[DTO, CreateDTO, UpdateDTO]) Invalidate(c context.Context, id types.ID) error {
+	if err := r.bump(c, r.versionKey("id:"+r.formatID(id))); err != nil {
+		return err
+	}
+	return r.InvalidateQueries(c)
+}
+
+func (r *Repository[DTO, CreateDTO, UpdateDTO]) InvalidateQueries(c context.Context) error {
+	if r.options.QueryExpiration <= 0 {
+		return nil
+	}
+	return r.bump(c, r.versionKey("query"))
+}
+
+// Follow 消费 change stream，根据其他实例或其他程序的修改让缓存失效，直到 stream 结束或 c 被取消
+func (r *Repository[DTO, CreateDTO, UpdateDTO]) Follow(c context.Context, stream *repositories.ChangeStream[DTO]) error {
+	for stream.Next(c) {
+		event := stream.Event()
+		if event.Type == repositories.ChangeEventInsert {
+			if err := r.InvalidateQueries(c); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := r.Invalidate(c, event.ID); err != nil {
+			return err
+		}
+	}
+	return stream.Err()
+}
+
+// load 读取缓存，未命中时调用 fetch 并写入缓存。并发的未命中合并为一次 fetch，
+// fetch 使用保留调用方的值但不会被取消的 context，某个调用方取消只让它自己返回，
+// 除发起者之外的调用方拿到的是解码出的副本
+func load[T any, DTO any, CreateDTO any, UpdateDTO any](
+	c context.Context,
+	r *Repository[DTO, CreateDTO, UpdateDTO],
+	versionKey string,
+	key string,
+	expiration time.Duration,
+	fetch func(c context.Context) (T, error),
+) (T, error) {
+	var zero T
+
+	version, err := r.version(c, versionKey)
+	if err != nil {
+		return zero, err
+	}
+
+	key = r.options.Prefix + key
+	var cached entry[T]
+	err = r.cache.Get(c, key, &cached)
+	if err == nil && cached.Version == version {
+		return cached.Value, nil
+	}
+	if err != nil && !errors.Is(err, types.ErrNotFound) {
+		return zero, err
+	}
+
+	ch := r.group.DoChan(key+"@"+strconv.FormatInt(version, 10), func() (any, error) {
+		c, cancel := context.WithTimeout(utils.WithoutCancel(c), r.options.FetchTimeout)
+		defer cancel()
+
+		value, err := fetch(c)
+		if err != nil {
+			return nil, err
+		}
+
+		var opts []core_cache.SetOption
+		if expiration > 0 {
+			opts = append(opts, core_cache.WithExpiration(expiration))
+		}
+		// 写入失败只影响命中率
+		_ = r.cache.Set(c, key, &entry[T]{Version: version, Value: value}, opts...)
+		return value, nil
+	})
+
+	var res singleflight.Result
+	select {
+	case <-c.Done():
+		return zero, c.Err()
+	case res = <-ch:
+	}
+	if res.Err != nil {
+		return zero, res.Err
+	}
+	v := res.Val
+	if !res.Shared {
+		return v.(T), nil
+	}
+
+	if err := r.cache.Get(c, key, &cached); err == nil && cached.Version == version {
+		return cached.Value, nil
+	}
+	return v.(T), nil
+}
+
+// version 读取版本号，不存在(从未写入或已被淘汰)时生成新的版本号，
+// 新版本号是当前时间，和之前写入的缓存条目都不相同
+func (r *Repository[DTO, CreateDTO, UpdateDTO]) version(c context.Context, key string) (int64, error) {
+	var version int64
+	err := r.options.Versions.Get(c, key, &version)
+	if errors.Is(err, types.ErrNotFound) {
+		version = time.Now().UnixNano()
+		return version, r.options.Versions.Set(c, key, version)
+	}
+	return version, err
+}
+
+// bump 把版本号设置为当前时间，多个实例之间不会冲突
+func (r *Repository[DTO, CreateDTO, UpdateDTO]) bump(c context.Context, key string) error {
+	return r.options.Versions.Set(c, key, time.Now().UnixNano())
+}
+
+// versionKey 不区分分区，一次写入让所有分区的缓存失效
+func (r *Repository[DTO, CreateDTO, UpdateDTO]) versionKey(name string) string {
+	return r.options.Prefix + "version:" + name
+}
+
+func (r *Repository[DTO, CreateDTO, UpdateDTO]) scope(c context.Context) (string, error) {
+	if r.options.Scope == nil {
+		return "", nil
+	}
+	scope, err := r.options.Scope(c)
+	if err != nil {
+		return "", err
+	}
+	return scope + ":", nil
+}
+
+func (r *Repository[DTO, CreateDTO, UpdateDTO]) formatID(id types.ID) string {
+	if r.options.IDStrategy != nil {
+		if parsed, err := r.options.IDStrategy.Parse(id); err == nil {
+			id = parsed
+		}
+	}
+
+	switch t := id.(type) {
+	case primitive.ObjectID:
+		return t.Hex()
+	case primitive.Binary:
+		return hex.EncodeToString(t.Data)
+	}
+	return types.FormatID(id)
+}
+
+// hashQuery 计算查询的摘要，json 编码时 map 的 key 是排序的，相同的查询得到相同的结果
+func hashQuery(query *types.PageQuery) (string, error) {
+	data, err := json.Marshal(query)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
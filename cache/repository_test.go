@@ -0,0 +1,263 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	core_repositories "github.com/duolacloud/crud-core/repositories"
+	"github.com/duolacloud/crud-core/types"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type cachedUser struct {
+	ID   string `bson:"_id"`
+	Name string `bson:"name"`
+}
+
+type fakeRepository struct {
+	core_repositories.CrudRepository[cachedUser, cachedUser, cachedUser]
+	users   map[string]*cachedUser
+	gets    int32
+	queries int32
+	// block 不为 nil 时 Get 等待它被关闭，用来制造并发的未命中
+	block chan struct{}
+}
+
+func (r *fakeRepository) Get(c context.Context, id types.ID) (*cachedUser, error) {
+	atomic.AddInt32(&r.gets, 1)
+	if r.block != nil {
+		<-r.block
+	}
+	u, ok := r.users[types.FormatID(id)]
+	if !ok {
+		return nil, types.ErrNotFound
+	}
+	copied := *u
+	return &copied, nil
+}
+
+func (r *fakeRepository) Update(c context.Context, id types.ID, u *cachedUser, opts ...types.UpdateOption) (*cachedUser, error) {
+	r.users[types.FormatID(id)] = u
+	return u, nil
+}
+
+func (r *fakeRepository) Query(c context.Context, query *types.PageQuery) ([]*cachedUser, error) {
+	atomic.AddInt32(&r.queries, 1)
+	var users []*cachedUser
+	for _, u := range r.users {
+		copied := *u
+		users = append(users, &copied)
+	}
+	return users, nil
+}
+
+func TestRepositoryGet(t *testing.T) {
+	c := context.Background()
+	inner := &fakeRepository{users: map[string]*cachedUser{"1": {ID: "1", Name: "a"}}}
+	r := NewRepository[cachedUser, cachedUser, cachedUser](inner, NewLRU(100))
+
+	u, err := r.Get(c, "1")
+	assert.NoError(t, err)
+	assert.Equal(t, "a", u.Name)
+
+	// 修改返回值不影响缓存
+	u.Name = "changed"
+	u, err = r.Get(c, "1")
+	assert.NoError(t, err)
+	assert.Equal(t, "a", u.Name)
+	assert.Equal(t, int32(1), inner.gets)
+
+	_, err = r.Update(c, "1", &cachedUser{ID: "1", Name: "b"})
+	assert.NoError(t, err)
+
+	u, err = r.Get(c, "1")
+	assert.NoError(t, err)
+	assert.Equal(t, "b", u.Name)
+	assert.Equal(t, int32(2), inner.gets)
+
+	_, err = r.Get(c, "2")
+	assert.ErrorIs(t, err, types.ErrNotFound)
+}
+
+func TestRepositorySingleflight(t *testing.T) {
+	c := context.Background()
+	inner := &fakeRepository{
+		users: map[string]*cachedUser{"1": {ID: "1", Name: "a"}},
+		block: make(chan struct{}),
+	}
+	r := NewRepository[cachedUser, cachedUser, cachedUser](inner, NewLRU(100))
+
+	var wg sync.WaitGroup
+	results := make([]*cachedUser, 10)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = r.Get(c, "1")
+		}(i)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(inner.block)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), inner.gets)
+	for _, u := range results {
+		assert.Equal(t, "a", u.Name)
+	}
+}
+
+func TestRepositoryStaleFill(t *testing.T) {
+	c := context.Background()
+	inner := &fakeRepository{users: map[string]*cachedUser{"1": {ID: "1", Name: "a"}}}
+	lru := NewLRU(100)
+	r := NewRepository[cachedUser, cachedUser, cachedUser](inner, lru)
+
+	// 读取数据库之后、写入缓存之前发生了修改，写入的旧数据不能被命中
+	version, err := r.version(c, r.versionKey("id:1"))
+	assert.NoError(t, err)
+	assert.NoError(t, r.Invalidate(c, "1"))
+	assert.NoError(t, lru.Set(c, "id:1", &entry[*cachedUser]{Version: version, Value: &cachedUser{ID: "1", Name: "stale"}}))
+
+	u, err := r.Get(c, "1")
+	assert.NoError(t, err)
+	assert.Equal(t, "a", u.Name)
+}
+
+type tenantKey struct{}
+
+func TestRepositoryQuery(t *testing.T) {
+	c := context.Background()
+	inner := &fakeRepository{users: map[string]*cachedUser{"1": {ID: "1", Name: "a"}}}
+	r := NewRepository[cachedUser, cachedUser, cachedUser](inner, NewLRU(100),
+		WithQueryCache(time.Minute),
+		WithScope(func(c context.Context) (string, error) {
+			tenant, _ := c.Value(tenantKey{}).(string)
+			return tenant, nil
+		}),
+	)
+
+	q := &types.PageQuery{Filter: map[string]any{"name": map[string]any{"eq": "a"}, "age": map[string]any{"gt": 1}}}
+	_, err := r.Query(c, q)
+	assert.NoError(t, err)
+	_, err = r.Query(c, &types.PageQuery{Filter: map[string]any{"age": map[string]any{"gt": 1}, "name": map[string]any{"eq": "a"}}})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), inner.queries)
+
+	// 其他分区不共享缓存
+	_, err = r.Query(context.WithValue(c, tenantKey{}, "t2"), q)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), inner.queries)
+
+	_, err = r.Update(c, "1", &cachedUser{ID: "1", Name: "b"})
+	assert.NoError(t, err)
+
+	users, err := r.Query(c, q)
+	assert.NoError(t, err)
+	assert.Equal(t, "b", users[0].Name)
+	assert.Equal(t, int32(3), inner.queries)
+}
+
+func TestRepositoryFormatID(t *testing.T) {
+	r := NewRepository[cachedUser, cachedUser, cachedUser](nil, NewLRU(1))
+
+	id := primitive.NewObjectID()
+	assert.Equal(t, id.Hex(), r.formatID(id))
+	assert.Equal(t, "1", r.formatID(1))
+}
+
+func TestRepositoryCanceledCaller(t *testing.T) {
+	inner := &fakeRepository{
+		users: map[string]*cachedUser{"1": {ID: "1", Name: "a"}},
+		block: make(chan struct{}),
+	}
+	r := NewRepository[cachedUser, cachedUser, cachedUser](inner, NewLRU(100))
+
+	// 发起读取的调用方取消后，等待同一个 key 的其他调用方仍然拿到结果
+	canceled, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		_, err := r.Get(canceled, "1")
+		errs <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	results := make(chan *cachedUser, 1)
+	go func() {
+		u, _ := r.Get(context.Background(), "1")
+		results <- u
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	cancel()
+	assert.ErrorIs(t, <-errs, context.Canceled)
+
+	close(inner.block)
+	u := <-results
+	assert.NotNil(t, u)
+	assert.Equal(t, "a", u.Name)
+	assert.Equal(t, int32(1), inner.gets)
+}
+
+type scopedRepository struct {
+	fakeRepository
+}
+
+func (r *scopedRepository) CallerDependent() bool {
+	return true
+}
+
+func TestRepositoryScopeRequired(t *testing.T) {
+	c := context.Background()
+	inner := &scopedRepository{fakeRepository{users: map[string]*cachedUser{"1": {ID: "1", Name: "a"}}}}
+
+	r := NewRepository[cachedUser, cachedUser, cachedUser](inner, NewLRU(100))
+	_, err := r.Get(c, "1")
+	assert.ErrorIs(t, err, ErrScopeRequired)
+	_, err = r.Query(c, &types.PageQuery{})
+	assert.ErrorIs(t, err, ErrScopeRequired)
+
+	r = NewRepository[cachedUser, cachedUser, cachedUser](inner, NewLRU(100), WithScope(func(c context.Context) (string, error) {
+		return "t1", nil
+	}))
+	_, err = r.Get(c, "1")
+	assert.NoError(t, err)
+}
+
+func TestRepositoryEvictedVersion(t *testing.T) {
+	c := context.Background()
+	inner := &fakeRepository{users: map[string]*cachedUser{"1": {ID: "1", Name: "a"}}}
+	lru := NewLRU(100)
+	r := NewRepository[cachedUser, cachedUser, cachedUser](inner, lru)
+
+	_, err := r.Get(c, "1")
+	assert.NoError(t, err)
+
+	// 版本号被淘汰后不会回到旧值，修改前写入的条目不能被命中
+	inner.users["1"] = &cachedUser{ID: "1", Name: "b"}
+	assert.NoError(t, lru.Delete(c, r.versionKey("id:1")))
+
+	u, err := r.Get(c, "1")
+	assert.NoError(t, err)
+	assert.Equal(t, "b", u.Name)
+}
+
+func TestRepositoryVersionCache(t *testing.T) {
+	c := context.Background()
+	inner := &fakeRepository{users: map[string]*cachedUser{"1": {ID: "1", Name: "a"}, "2": {ID: "2", Name: "b"}}}
+	entries, versions := NewLRU(1), NewLRU(100)
+	r := NewRepository[cachedUser, cachedUser, cachedUser](inner, entries, WithVersionCache(versions))
+
+	_, err := r.Get(c, "1")
+	assert.NoError(t, err)
+	_, err = r.Get(c, "2")
+	assert.NoError(t, err)
+
+	// 条目互相淘汰，版本号不受影响
+	assert.Equal(t, 1, entries.Len())
+	assert.Equal(t, 2, versions.Len())
+}
@@ -0,0 +1,494 @@
+// Package conformance 是仓储实现的一致性测试，同一组用例可以在 MongoCrudRepository、
+// MemoryCrudRepository 或其他兼容 MongoDB 的后端上运行，保证它们的行为一致
+package conformance
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	core_repositories "github.com/duolacloud/crud-core/repositories"
+	"github.com/duolacloud/crud-core/types"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type Address struct {
+	City string `json:"city" bson:"city"`
+}
+
+// User 是测试用的实体，BeforeCreate/BeforeUpdate 会写入 Note 用来验证钩子被调用
+type User struct {
+	ID       string    `json:"id" bson:"_id"`
+	Name     string    `json:"name" bson:"name"`
+	Nickname *string   `json:"nickname" bson:"nickname"`
+	Country  string    `json:"country" bson:"country"`
+	Age      int       `json:"age" bson:"age"`
+	Birthday time.Time `json:"birthday" bson:"birthday"`
+	Tags     []string  `json:"tags" bson:"tags"`
+	Address  Address   `json:"address" bson:"address"`
+	Note     string    `json:"note" bson:"note"`
+}
+
+func (u *User) BeforeCreate() {
+	if u.Note == "" {
+		u.Note = "created"
+	}
+}
+
+func (u *User) BeforeUpdate() {
+	u.Note = "updated"
+}
+
+// UserSchema 是 User 对应的集合 schema，Factory 创建仓储时需要使用它
+var UserSchema = bson.M{
+	"$jsonSchema": bson.M{
+		"bsonType": "object",
+		"required": []string{"_id"},
+		"properties": bson.M{
+			"_id":      bson.M{"bsonType": "string"},
+			"name":     bson.M{"bsonType": "string"},
+			"nickname": bson.M{"bsonType": "string"},
+			"country":  bson.M{"bsonType": "string"},
+			"age":      bson.M{"bsonType": "int"},
+			"birthday": bson.M{"bsonType": "date"},
+			"tags": bson.M{
+				"bsonType": "array",
+				"items":    bson.M{"bsonType": "string"},
+			},
+			"address": bson.M{
+				"bsonType": "object",
+				"properties": bson.M{
+					"city": bson.M{"bsonType": "string"},
+				},
+			},
+			"note": bson.M{"bsonType": "string"},
+		},
+	},
+}
+
+// Factory 为每个用例创建一个空的仓储，用例之间不能共享数据
+type Factory func(t *testing.T) core_repositories.CrudRepository[User, User, User]
+
+// Run 运行所有用例
+func Run(t *testing.T, factory Factory) {
+	cases := []struct {
+		name string
+		run  func(t *testing.T, r core_repositories.CrudRepository[User, User, User])
+	}{
+		{"Create", testCreate},
+		{"CreateMany", testCreateMany},
+		{"Update", testUpdate},
+		{"Delete", testDelete},
+		{"Errors", testErrors},
+		{"Filters", testFilters},
+		{"Sorting", testSorting},
+		{"Projection", testProjection},
+		{"Pagination", testPagination},
+		{"CursorPagination", testCursorPagination},
+		{"Aggregate", testAggregate},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			tc.run(t, factory(t))
+		})
+	}
+}
+
+func fixtures() []*User {
+	tommy := "tommy"
+	return []*User{
+		{ID: "1", Name: "tom", Nickname: &tommy, Country: "cn", Age: 18, Birthday: date(2000, 1, 15), Tags: []string{"a", "b"}, Address: Address{City: "beijing"}},
+		{ID: "2", Name: "jerry", Country: "us", Age: 30, Birthday: date(1990, 6, 1), Tags: []string{"b"}, Address: Address{City: "new york"}},
+		{ID: "3", Name: "lily", Country: "cn", Age: 25, Birthday: date(2005, 1, 20), Tags: []string{}, Address: Address{City: "shanghai"}},
+		{ID: "4", Name: "bob", Country: "uk", Age: 30, Birthday: date(2001, 3, 3), Tags: []string{"c"}, Address: Address{City: "london"}},
+		{ID: "5", Name: "anna", Country: "us", Age: 22, Birthday: date(2000, 1, 31), Tags: []string{"a"}, Address: Address{City: "boston"}},
+	}
+}
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 10, 0, 0, 0, time.UTC)
+}
+
+func seed(t *testing.T, r core_repositories.CrudRepository[User, User, User]) []*User {
+	users := fixtures()
+	_, err := r.CreateMany(context.Background(), users)
+	if err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+	return users
+}
+
+// normalize 把时间转换为 UTC，不同后端解码出的时区可能不同
+func normalize(users ...*User) []*User {
+	for _, u := range users {
+		if u != nil {
+			u.Birthday = u.Birthday.UTC()
+		}
+	}
+	return users
+}
+
+func ids(users []*User) []string {
+	r := make([]string, len(users))
+	for i, u := range users {
+		r[i] = u.ID
+	}
+	return r
+}
+
+func names(users []*User) []string {
+	r := make([]string, len(users))
+	for i, u := range users {
+		r[i] = u.Name
+	}
+	return r
+}
+
+func testCreate(t *testing.T, r core_repositories.CrudRepository[User, User, User]) {
+	c := context.Background()
+	user := fixtures()[0]
+
+	created, err := r.Create(c, user)
+	assert.NoError(t, err)
+	assert.Equal(t, "created", user.Note)
+	assert.Equal(t, user, normalize(created)[0])
+
+	got, err := r.Get(c, user.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, user, normalize(got)[0])
+}
+
+func testCreateMany(t *testing.T, r core_repositories.CrudRepository[User, User, User]) {
+	c := context.Background()
+	users := fixtures()
+
+	created, err := r.CreateMany(c, users)
+	assert.NoError(t, err)
+	// 返回结果和传入的顺序一致
+	assert.Equal(t, users, normalize(created...))
+	for _, u := range users {
+		assert.Equal(t, "created", u.Note)
+	}
+
+	count, err := r.Count(c, &types.PageQuery{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(users)), count)
+}
+
+func testUpdate(t *testing.T, r core_repositories.CrudRepository[User, User, User]) {
+	c := context.Background()
+	users := seed(t, r)
+
+	update := *users[0]
+	update.Name = "tomas"
+	update.Age = 19
+	updated, err := r.Update(c, update.ID, &update)
+	assert.NoError(t, err)
+	assert.Equal(t, "updated", update.Note)
+	assert.Equal(t, &update, normalize(updated)[0])
+
+	got, err := r.Get(c, update.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, &update, normalize(got)[0])
+
+	_, err = r.Update(c, "100", &User{Name: "nobody"})
+	assert.ErrorIs(t, err, types.ErrNotFound)
+
+	upserted, err := r.Update(c, "100", &User{Name: "nobody"}, types.WithUpsert(true))
+	assert.NoError(t, err)
+	assert.Equal(t, "100", upserted.ID)
+	assert.Equal(t, "nobody", upserted.Name)
+}
+
+func testDelete(t *testing.T, r core_repositories.CrudRepository[User, User, User]) {
+	c := context.Background()
+	seed(t, r)
+
+	assert.NoError(t, r.Delete(c, "1"))
+	_, err := r.Get(c, "1")
+	assert.ErrorIs(t, err, types.ErrNotFound)
+
+	// 删除不存在的实体不是错误
+	assert.NoError(t, r.Delete(c, "1"))
+
+	count, err := r.Count(c, &types.PageQuery{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), count)
+}
+
+func testErrors(t *testing.T, r core_repositories.CrudRepository[User, User, User]) {
+	c := context.Background()
+	seed(t, r)
+
+	_, err := r.Get(c, "100")
+	assert.ErrorIs(t, err, types.ErrNotFound)
+
+	_, err = r.QueryOne(c, map[string]any{"name": map[string]any{"eq": "nobody"}})
+	assert.ErrorIs(t, err, types.ErrNotFound)
+
+	_, err = r.Create(c, &User{ID: "1"})
+	assert.True(t, mongo.IsDuplicateKeyError(err), "duplicate create: %v", err)
+
+	_, err = r.CreateMany(c, []*User{{ID: "6"}, {ID: "6"}})
+	assert.True(t, mongo.IsDuplicateKeyError(err), "duplicate create many: %v", err)
+
+	_, err = r.Query(c, &types.PageQuery{Filter: map[string]any{"age": map[string]any{"unknown": 1}}})
+	assert.Error(t, err)
+
+	_, err = r.Query(c, &types.PageQuery{Filter: map[string]any{"country": map[string]any{"in": "cn"}}})
+	assert.Error(t, err)
+}
+
+func testFilters(t *testing.T, r core_repositories.CrudRepository[User, User, User]) {
+	c := context.Background()
+	seed(t, r)
+
+	cases := []struct {
+		name   string
+		filter map[string]any
+		ids    []string
+	}{
+		{"eq", map[string]any{"name": map[string]any{"eq": "tom"}}, []string{"1"}},
+		{"neq", map[string]any{"country": map[string]any{"neq": "cn"}}, []string{"2", "4", "5"}},
+		{"gt", map[string]any{"age": map[string]any{"gt": 25}}, []string{"2", "4"}},
+		{"gte", map[string]any{"age": map[string]any{"gte": 25}}, []string{"2", "3", "4"}},
+		{"lt", map[string]any{"age": map[string]any{"lt": 22}}, []string{"1"}},
+		{"lte", map[string]any{"age": map[string]any{"lte": 22}}, []string{"1", "5"}},
+		{"in", map[string]any{"country": map[string]any{"in": []string{"us", "uk"}}}, []string{"2", "4", "5"}},
+		{"notin", map[string]any{"country": map[string]any{"notin": []any{"us", "uk"}}}, []string{"1", "3"}},
+		{"is", map[string]any{"nickname": map[string]any{"is": nil}}, []string{"2", "3", "4", "5"}},
+		{"isnot", map[string]any{"nickname": map[string]any{"isnot": nil}}, []string{"1"}},
+		{"between", map[string]any{"age": map[string]any{"between": map[string]any{"lower": 20, "upper": 25}}}, []string{"3", "5"}},
+		{"notbetween", map[string]any{"age": map[string]any{"notbetween": map[string]any{"lower": 20, "upper": 25}}}, []string{"1", "2", "4"}},
+		{"date", map[string]any{"birthday": map[string]any{"gt": "2000-01-20T00:00:00Z"}}, []string{"3", "4", "5"}},
+		{"onDate", map[string]any{"birthday": map[string]any{"onDate": "2000-01-15"}}, []string{"1"}},
+		{"inMonth", map[string]any{"birthday": map[string]any{"inMonth": "2000-01"}}, []string{"1", "5"}},
+		{"array", map[string]any{"tags": map[string]any{"eq": "a"}}, []string{"1", "5"}},
+		{"nested", map[string]any{"address.city": map[string]any{"eq": "beijing"}}, []string{"1"}},
+		{"id", map[string]any{"id": map[string]any{"in": []string{"1", "3"}}}, []string{"1", "3"}},
+		{"and", map[string]any{"and": []any{
+			map[string]any{"country": map[string]any{"eq": "cn"}},
+			map[string]any{"age": map[string]any{"gt": 20}},
+		}}, []string{"3"}},
+		{"or", map[string]any{"or": []map[string]any{
+			{"name": map[string]any{"eq": "tom"}},
+			{"age": map[string]any{"gte": 30}},
+		}}, []string{"1", "2", "4"}},
+		{"not", map[string]any{"not": map[string]any{"country": map[string]any{"eq": "cn"}}}, []string{"2", "4", "5"}},
+		{"implicit and", map[string]any{
+			"country": map[string]any{"eq": "us"},
+			"age":     map[string]any{"lt": 30},
+		}, []string{"5"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			users, err := r.Query(c, &types.PageQuery{Filter: tc.filter, Sort: []string{"id"}})
+			assert.NoError(t, err)
+			assert.Equal(t, tc.ids, ids(users))
+
+			count, err := r.Count(c, &types.PageQuery{Filter: tc.filter})
+			assert.NoError(t, err)
+			assert.Equal(t, int64(len(tc.ids)), count)
+		})
+	}
+}
+
+func testSorting(t *testing.T, r core_repositories.CrudRepository[User, User, User]) {
+	c := context.Background()
+	seed(t, r)
+
+	users, err := r.Query(c, &types.PageQuery{Sort: []string{"-age", "name"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bob", "jerry", "lily", "anna", "tom"}, names(users))
+
+	users, err = r.Query(c, &types.PageQuery{Sort: []string{"+birthday"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2", "1", "5", "4", "3"}, ids(users))
+
+	users, err = r.Query(c, &types.PageQuery{Sort: []string{"-address.city"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"3", "2", "4", "5", "1"}, ids(users))
+}
+
+func testProjection(t *testing.T, r core_repositories.CrudRepository[User, User, User]) {
+	c := context.Background()
+	seed(t, r)
+
+	users, err := r.Query(c, &types.PageQuery{
+		Filter: map[string]any{"id": map[string]any{"eq": "1"}},
+		Fields: []string{"name", "address.city"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []*User{{ID: "1", Name: "tom", Address: Address{City: "beijing"}}}, users)
+
+	users, err = r.Query(c, &types.PageQuery{
+		Filter: map[string]any{"id": map[string]any{"eq": "1"}},
+		Fields: []string{"-tags", "-address", "-birthday", "-nickname"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []*User{{ID: "1", Name: "tom", Country: "cn", Age: 18, Note: "created"}}, users)
+}
+
+func testPagination(t *testing.T, r core_repositories.CrudRepository[User, User, User]) {
+	c := context.Background()
+	seed(t, r)
+
+	users, err := r.Query(c, &types.PageQuery{Sort: []string{"id"}, Page: map[string]int{"limit": 2, "offset": 1}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2", "3"}, ids(users))
+
+	users, err = r.Query(c, &types.PageQuery{Sort: []string{"id"}, Page: map[string]int{"size": 2, "page": 3}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"5"}, ids(users))
+
+	users, err = r.Query(c, &types.PageQuery{Sort: []string{"id"}, Page: map[string]int{"limit": 2, "offset": 10}})
+	assert.NoError(t, err)
+	assert.Empty(t, users)
+
+	// Count 忽略分页参数
+	count, err := r.Count(c, &types.PageQuery{Page: map[string]int{"limit": 2}})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), count)
+}
+
+func testCursorPagination(t *testing.T, r core_repositories.CrudRepository[User, User, User]) {
+	c := context.Background()
+	seed(t, r)
+
+	cases := []struct {
+		name     string
+		sort     []string
+		forward  [][]string
+		backward [][]string
+	}{
+		{"id", nil, [][]string{{"1", "2"}, {"3", "4"}, {"5"}}, [][]string{{"3", "4"}, {"1", "2"}}},
+		{"desc id", []string{"-id"}, [][]string{{"5", "4"}, {"3", "2"}, {"1"}}, [][]string{{"3", "2"}, {"5", "4"}}},
+		// 多字段排序，age 相同的按 name 排，id 追加在最后
+		{"multi fields", []string{"-age", "name"}, [][]string{{"4", "2"}, {"3", "5"}, {"1"}}, [][]string{{"3", "5"}, {"4", "2"}}},
+		{"duplicate values", []string{"country"}, [][]string{{"1", "3"}, {"4", "2"}, {"5"}}, [][]string{{"4", "2"}, {"1", "3"}}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var pages [][]string
+			var extra *types.CursorExtra
+			cursor := ""
+			for len(pages) <= len(tc.forward) {
+				users, e, err := r.CursorQuery(c, &types.CursorQuery{Limit: 2, Sort: tc.sort, Cursor: cursor, Direction: types.CursorDirectionAfter})
+				if !assert.NoError(t, err) {
+					return
+				}
+				pages = append(pages, ids(users))
+				extra = e
+				if !e.HasNext {
+					break
+				}
+				cursor = e.EndCursor
+			}
+			assert.Equal(t, tc.forward, pages)
+
+			// 从最后一页向前翻，每页是离游标最近的数据，顺序和向后翻页相同
+			pages = nil
+			cursor = extra.StartCursor
+			for len(pages) <= len(tc.backward) {
+				users, e, err := r.CursorQuery(c, &types.CursorQuery{Limit: 2, Sort: tc.sort, Cursor: cursor, Direction: types.CursorDirectionBefore})
+				if !assert.NoError(t, err) {
+					return
+				}
+				if len(users) == 0 {
+					break
+				}
+				pages = append(pages, ids(users))
+				cursor = e.StartCursor
+			}
+			assert.Equal(t, tc.backward, pages)
+		})
+	}
+
+	users, _, err := r.CursorQuery(c, &types.CursorQuery{
+		Limit:  10,
+		Filter: map[string]any{"country": map[string]any{"eq": "us"}},
+		Fields: []string{"name"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []*User{{ID: "2", Name: "jerry"}, {ID: "5", Name: "anna"}}, users)
+}
+
+func testAggregate(t *testing.T, r core_repositories.CrudRepository[User, User, User]) {
+	c := context.Background()
+	seed(t, r)
+
+	aggs, err := r.Aggregate(c, nil, &types.AggregateQuery{
+		GroupBy: []string{"country"},
+		Count:   []string{"age"},
+		Sum:     []string{"age"},
+		Avg:     []string{"age"},
+		Max:     []string{"age"},
+		Min:     []string{"age"},
+	})
+	assert.NoError(t, err)
+
+	type row struct {
+		country              string
+		count, sum, max, min int64
+		avg                  float64
+	}
+	var rows []row
+	for _, agg := range aggs {
+		rows = append(rows, row{
+			country: agg.GroupBy["country"].(string),
+			count:   toInt64(agg.Count["age"]),
+			sum:     toInt64(agg.Sum["age"]),
+			avg:     agg.Avg["age"].(float64),
+			max:     toInt64(agg.Max["age"]),
+			min:     toInt64(agg.Min["age"]),
+		})
+	}
+	assert.Equal(t, []row{
+		{"cn", 2, 43, 25, 18, 21.5},
+		{"uk", 1, 30, 30, 30, 30},
+		{"us", 2, 52, 30, 22, 26},
+	}, rows)
+
+	aggs, err = r.Aggregate(c, map[string]any{"country": map[string]any{"eq": "us"}}, &types.AggregateQuery{
+		Sum: []string{"age"},
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, aggs, 1) {
+		assert.Empty(t, aggs[0].GroupBy)
+		assert.Equal(t, int64(52), toInt64(aggs[0].Sum["age"]))
+	}
+
+	aggs, err = r.Aggregate(c, nil, &types.AggregateQuery{
+		GroupBy: []string{"country", "age"},
+		Count:   []string{"name"},
+	})
+	assert.NoError(t, err)
+	groups := make([]string, len(aggs))
+	for i, agg := range aggs {
+		groups[i] = agg.GroupBy["country"].(string)
+	}
+	assert.True(t, sort.StringsAreSorted(groups), "groups are sorted by country: %v", groups)
+	assert.Len(t, aggs, 5)
+}
+
+// toInt64 不同后端返回的整数类型可能不同(int32/int64)
+func toInt64(v any) int64 {
+	switch t := v.(type) {
+	case int32:
+		return int64(t)
+	case int64:
+		return t
+	case int:
+		return int64(t)
+	case float64:
+		return int64(t)
+	}
+	return -1
+}
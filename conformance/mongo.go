@@ -0,0 +1,51 @@
+package conformance
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// URIEnv 是 MongoDB 连接串的环境变量，未设置时跳过需要服务端的用例
+const URIEnv = "MONGODB_URI"
+
+// MongoDatabase 连接 URIEnv 指定的服务端并返回一个随机命名的数据库，测试结束后删除
+func MongoDatabase(t *testing.T) *mongo.Database {
+	t.Helper()
+
+	uri := os.Getenv(URIEnv)
+	if uri == "" {
+		t.Skipf("%s is not set", URIEnv)
+	}
+
+	c, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(c, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("connect %s: %v", uri, err)
+	}
+	if err := client.Ping(c, nil); err != nil {
+		_ = client.Disconnect(c)
+		t.Fatalf("ping %s: %v", uri, err)
+	}
+
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	db := client.Database("crud_conformance_" + hex.EncodeToString(b))
+
+	t.Cleanup(func() {
+		c := context.Background()
+		_ = db.Drop(c)
+		_ = client.Disconnect(c)
+	})
+	return db
+}
@@ -0,0 +1,474 @@
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Algorithm 是字段的加密方式，数据使用 AES-256-GCM 在客户端加密。
+// 密文格式是本包自定义的，和 MongoDB CSFLE(libmongocrypt)不兼容，
+// 开启了自动加密的驱动不能解密这些字段，反之亦然
+type Algorithm string
+
+const (
+	// Deterministic 相同字段上相同的明文得到相同的密文，可以用 eq/neq/in/notin 查询
+	Deterministic Algorithm = "AES_256_GCM-Deterministic"
+	// Random 每次加密的结果都不同，不能查询
+	Random Algorithm = "AES_256_GCM-Random"
+)
+
+// ParseAlgorithm 解析 schema 中的算法名，兼容 CSFLE 的写法
+// (如 AEAD_AES_256_CBC_HMAC_SHA_512-Deterministic)，但只取确定性/随机两种方式
+func ParseAlgorithm(name string) Algorithm {
+	if strings.HasSuffix(name, "-Deterministic") {
+		return Deterministic
+	}
+	return Random
+}
+
+// DefaultKeyID 是字段没有指定 keyId 时使用的密钥
+const DefaultKeyID = "default"
+
+// BinarySubtype 是加密后的值的 binData 子类型，和 CSFLE 使用的子类型相同，但内容格式不同
+const BinarySubtype byte = 0x06
+
+const version byte = 1
+
+var ErrDecrypt = errors.New("failed to decrypt field")
+
+type Field struct {
+	// Path 是 bson 字段路径，用 . 分隔，经过数组时作用在每个元素上
+	Path      string
+	Algorithm Algorithm
+	KeyID     string
+}
+
+type Encryptor struct {
+	keys   KeyProvider
+	fields map[string]Field
+
+	mu      sync.Mutex
+	ciphers map[string]*fieldCipher
+}
+
+type fieldCipher struct {
+	aead   cipher.AEAD
+	macKey []byte
+}
+
+func New(keys KeyProvider, fields ...Field) *Encryptor {
+	e := &Encryptor{
+		keys:    keys,
+		fields:  map[string]Field{},
+		ciphers: map[string]*fieldCipher{},
+	}
+	for _, f := range fields {
+		if f.KeyID == "" {
+			f.KeyID = DefaultKeyID
+		}
+		if f.Algorithm == "" {
+			f.Algorithm = Random
+		}
+		e.fields[f.Path] = f
+	}
+	return e
+}
+
+// Field 返回字段的加密设置
+func (e *Encryptor) Field(path string) (Field, bool) {
+	f, ok := e.fields[path]
+	return f, ok
+}
+
+func (e *Encryptor) Fields() []Field {
+	fields := make([]Field, 0, len(e.fields))
+	for _, f := range e.fields {
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// EncryptDocument 原地加密文档中配置的字段，nil 值不加密
+func (e *Encryptor) EncryptDocument(c context.Context, doc bson.M) error {
+	for _, f := range e.fields {
+		f := f
+		err := walk(doc, strings.Split(f.Path, "."), func(v any) (any, error) {
+			return e.Encrypt(c, f, v)
+		})
+		if err != nil {
+			return fmt.Errorf("encrypt %s: %w", f.Path, err)
+		}
+	}
+	return nil
+}
+
+// RemoveFields 删除文档中加密的字段，用于不应该保存明文的地方(如审计记录)
+func (e *Encryptor) RemoveFields(doc bson.M) {
+	for path := range e.fields {
+		segments := strings.Split(path, ".")
+		remove(doc, segments)
+	}
+}
+
+// Encrypt 加密单个值
+func (e *Encryptor) Encrypt(c context.Context, f Field, v any) (any, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if b, ok := v.(primitive.Binary); ok && b.Subtype == BinarySubtype {
+		// 已经加密过
+		return v, nil
+	}
+
+	if f.KeyID == "" {
+		f.KeyID = DefaultKeyID
+	}
+	fc, err := e.cipher(c, f.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := bson.Marshal(bson.D{{Key: "v", Value: normalize(v)}})
+	if err != nil {
+		return nil, err
+	}
+
+	mode := byte(2)
+	nonce := make([]byte, fc.aead.NonceSize())
+	if f.Algorithm == Deterministic {
+		mode = 1
+		// 确定性加密的 nonce 由字段路径和明文的 HMAC 生成，不同字段上相同的值得到不同的密文
+		mac := hmac.New(sha256.New, fc.macKey)
+		mac.Write([]byte(f.Path))
+		mac.Write([]byte{0})
+		mac.Write(plaintext)
+		copy(nonce, mac.Sum(nil))
+	} else if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	if len(f.KeyID) > 255 {
+		return nil, fmt.Errorf("key id %q is too long", f.KeyID)
+	}
+	header := append([]byte{version, mode, byte(len(f.KeyID))}, f.KeyID...)
+
+	data := append(header, nonce...)
+	data = fc.aead.Seal(data, nonce, plaintext, additionalData(header, f.Path))
+	return primitive.Binary{Subtype: BinarySubtype, Data: data}, nil
+}
+
+// additionalData 把字段路径和头部一起认证，密文被复制到其他字段后无法解密
+func additionalData(header []byte, path string) []byte {
+	ad := make([]byte, 0, len(header)+len(path))
+	ad = append(ad, header...)
+	return append(ad, path...)
+}
+
+// normalize 把整数统一为 int64、float32 统一为 float64，
+// 同一个值不论来自 DTO 的哪种数值类型还是查询时的类型转换，确定性加密的结果都相同
+func normalize(v any) any {
+	switch t := v.(type) {
+	case int:
+		return int64(t)
+	case int8:
+		return int64(t)
+	case int16:
+		return int64(t)
+	case int32:
+		return int64(t)
+	case uint8:
+		return int64(t)
+	case uint16:
+		return int64(t)
+	case uint32:
+		return int64(t)
+	case uint:
+		if uint64(t) <= math.MaxInt64 {
+			return int64(t)
+		}
+	case uint64:
+		if t <= math.MaxInt64 {
+			return int64(t)
+		}
+	case float32:
+		return float64(t)
+	}
+	return v
+}
+
+// Decrypt 解密 Encrypt 生成的值，path 是值所在的字段路径(不含数组下标)，其他值原样返回
+func (e *Encryptor) Decrypt(c context.Context, path string, v any) (any, error) {
+	b, ok := v.(primitive.Binary)
+	if !ok || b.Subtype != BinarySubtype {
+		return v, nil
+	}
+
+	data := b.Data
+	if len(data) < 3 || data[0] != version || len(data) < 3+int(data[2]) {
+		return nil, ErrDecrypt
+	}
+	headerLen := 3 + int(data[2])
+	header, keyID := data[:headerLen], string(data[3:headerLen])
+
+	fc, err := e.cipher(c, keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	nonceSize := fc.aead.NonceSize()
+	if len(data) < headerLen+nonceSize {
+		return nil, ErrDecrypt
+	}
+	nonce := data[headerLen : headerLen+nonceSize]
+	plaintext, err := fc.aead.Open(nil, nonce, data[headerLen+nonceSize:], additionalData(header, path))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+
+	var doc bson.D
+	if err := bson.Unmarshal(plaintext, &doc); err != nil || len(doc) != 1 {
+		return nil, ErrDecrypt
+	}
+	return doc[0].Value, nil
+}
+
+// DecryptAll 解密 v 中所有加密的值，v 可以是文档、数组或者单个值，
+// 加密字段需要在原来的路径上才能解密，pipeline 中被重命名的加密字段会解密失败
+func (e *Encryptor) DecryptAll(c context.Context, v any) (any, error) {
+	return e.decryptAll(c, "", v)
+}
+
+func (e *Encryptor) decryptAll(c context.Context, path string, v any) (any, error) {
+	switch t := v.(type) {
+	case bson.M:
+		for k, e2 := range t {
+			d, err := e.decryptAll(c, childPath(path, k), e2)
+			if err != nil {
+				return nil, err
+			}
+			t[k] = d
+		}
+		return t, nil
+	case bson.D:
+		for i := range t {
+			d, err := e.decryptAll(c, childPath(path, t[i].Key), t[i].Value)
+			if err != nil {
+				return nil, err
+			}
+			t[i].Value = d
+		}
+		return t, nil
+	case bson.A:
+		for i := range t {
+			d, err := e.decryptAll(c, path, t[i])
+			if err != nil {
+				return nil, err
+			}
+			t[i] = d
+		}
+		return t, nil
+	case []any:
+		for i := range t {
+			d, err := e.decryptAll(c, path, t[i])
+			if err != nil {
+				return nil, err
+			}
+			t[i] = d
+		}
+		return t, nil
+	}
+	return e.Decrypt(c, path, v)
+}
+
+// childPath 拼接字段路径，key 可以是 change stream 中 a.0.b 形式的路径，数组下标会被去掉
+func childPath(parent string, key string) string {
+	segments := strings.Split(key, ".")
+	if parent != "" {
+		segments = append([]string{parent}, segments...)
+	}
+
+	path := segments[:0]
+	for _, seg := range segments {
+		if isIndex(seg) {
+			continue
+		}
+		path = append(path, seg)
+	}
+	return strings.Join(path, ".")
+}
+
+func isIndex(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// DecryptRaw 解密原始 bson 文档，没有加密的值时直接返回原文档
+func (e *Encryptor) DecryptRaw(c context.Context, raw bson.Raw) (bson.Raw, error) {
+	if !containsEncrypted(raw) {
+		return raw, nil
+	}
+
+	var doc bson.M
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	if _, err := e.DecryptAll(c, doc); err != nil {
+		return nil, err
+	}
+	return bson.Marshal(doc)
+}
+
+func (e *Encryptor) cipher(c context.Context, keyID string) (*fieldCipher, error) {
+	e.mu.Lock()
+	fc, ok := e.ciphers[keyID]
+	e.mu.Unlock()
+	if ok {
+		return fc, nil
+	}
+
+	key, err := e.keys.DataKey(c, keyID)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) < 32 {
+		return nil, fmt.Errorf("data key %s must be at least 32 bytes", keyID)
+	}
+
+	block, err := aes.NewCipher(derive(key, "encryption"))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	fc = &fieldCipher{aead: aead, macKey: derive(key, "nonce")}
+
+	e.mu.Lock()
+	e.ciphers[keyID] = fc
+	e.mu.Unlock()
+	return fc, nil
+}
+
+func containsEncrypted(raw bson.Raw) bool {
+	elems, err := raw.Elements()
+	if err != nil {
+		return true
+	}
+	for _, elem := range elems {
+		if rawValueEncrypted(elem.Value()) {
+			return true
+		}
+	}
+	return false
+}
+
+func rawValueEncrypted(v bson.RawValue) bool {
+	switch v.Type {
+	case bsontype.Binary:
+		subtype, _ := v.Binary()
+		return subtype == BinarySubtype
+	case bsontype.EmbeddedDocument:
+		return containsEncrypted(v.Document())
+	case bsontype.Array:
+		return containsEncrypted(bson.Raw(v.Array()))
+	}
+	return false
+}
+
+// walk 对路径上的值调用 fn，经过数组时作用在每个元素上
+func walk(v any, path []string, fn func(v any) (any, error)) error {
+	switch t := v.(type) {
+	case bson.M:
+		child, ok := t[path[0]]
+		if !ok {
+			return nil
+		}
+		if len(path) == 1 {
+			r, err := fn(child)
+			if err != nil {
+				return err
+			}
+			t[path[0]] = r
+			return nil
+		}
+		return walk(child, path[1:], fn)
+	case bson.D:
+		for i := range t {
+			if t[i].Key != path[0] {
+				continue
+			}
+			if len(path) == 1 {
+				r, err := fn(t[i].Value)
+				if err != nil {
+					return err
+				}
+				t[i].Value = r
+				return nil
+			}
+			return walk(t[i].Value, path[1:], fn)
+		}
+	case bson.A:
+		for _, e := range t {
+			if err := walk(e, path, fn); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, e := range t {
+			if err := walk(e, path, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func remove(v any, path []string) {
+	switch t := v.(type) {
+	case bson.M:
+		if len(path) == 1 {
+			delete(t, path[0])
+			return
+		}
+		remove(t[path[0]], path[1:])
+	case bson.D:
+		for i := range t {
+			if t[i].Key == path[0] {
+				if len(path) == 1 {
+					t[i].Value = nil
+					return
+				}
+				remove(t[i].Value, path[1:])
+			}
+		}
+	case bson.A:
+		for _, e := range t {
+			remove(e, path)
+		}
+	case []any:
+		for _, e := range t {
+			remove(e, path)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package encryption
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func newTestEncryptor(t *testing.T, master string) *Encryptor {
+	keys, err := LocalKeyProvider([]byte(master))
+	assert.NoError(t, err)
+
+	return New(keys,
+		Field{Path: "id_number", Algorithm: Deterministic},
+		Field{Path: "contacts.phone", Algorithm: Random, KeyID: "pii"},
+	)
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	c := context.Background()
+	e := newTestEncryptor(t, "0123456789abcdef0123456789abcdef")
+
+	f, _ := e.Field("id_number")
+	a, err := e.Encrypt(c, f, "110101199001011234")
+	assert.NoError(t, err)
+	b, err := e.Encrypt(c, f, "110101199001011234")
+	assert.NoError(t, err)
+	assert.Equal(t, a, b)
+	assert.Equal(t, BinarySubtype, a.(primitive.Binary).Subtype)
+
+	f, _ = e.Field("contacts.phone")
+	x, err := e.Encrypt(c, f, "13800000000")
+	assert.NoError(t, err)
+	y, err := e.Encrypt(c, f, "13800000000")
+	assert.NoError(t, err)
+	assert.NotEqual(t, x, y)
+
+	v, err := e.Decrypt(c, "contacts.phone", x)
+	assert.NoError(t, err)
+	assert.Equal(t, "13800000000", v)
+
+	_, err = newTestEncryptor(t, "fedcba9876543210fedcba9876543210").Decrypt(c, "contacts.phone", x)
+	assert.ErrorIs(t, err, ErrDecrypt)
+
+	// 密文被复制到其他字段后不能解密
+	_, err = e.Decrypt(c, "id_number", x)
+	assert.ErrorIs(t, err, ErrDecrypt)
+	_, err = e.DecryptAll(c, bson.M{"name": x})
+	assert.ErrorIs(t, err, ErrDecrypt)
+}
+
+func TestEncryptDocument(t *testing.T) {
+	c := context.Background()
+	e := newTestEncryptor(t, "0123456789abcdef0123456789abcdef")
+
+	doc := bson.M{
+		"name":      "a",
+		"id_number": "110101199001011234",
+		"contacts": bson.A{
+			bson.M{"phone": "13800000000"},
+			bson.D{{Key: "phone", Value: "13900000000"}},
+		},
+	}
+	assert.NoError(t, e.EncryptDocument(c, doc))
+	assert.IsType(t, primitive.Binary{}, doc["id_number"])
+	assert.Equal(t, "a", doc["name"])
+
+	raw, err := bson.Marshal(doc)
+	assert.NoError(t, err)
+	raw, err = e.DecryptRaw(c, raw)
+	assert.NoError(t, err)
+
+	var decrypted struct {
+		IDNumber string `bson:"id_number"`
+		Contacts []struct {
+			Phone string `bson:"phone"`
+		} `bson:"contacts"`
+	}
+	assert.NoError(t, bson.Unmarshal(raw, &decrypted))
+	assert.Equal(t, "110101199001011234", decrypted.IDNumber)
+	assert.Equal(t, "13800000000", decrypted.Contacts[0].Phone)
+	assert.Equal(t, "13900000000", decrypted.Contacts[1].Phone)
+
+	e.RemoveFields(doc)
+	assert.NotContains(t, doc, "id_number")
+}
+
+func TestLocalKeyProvider(t *testing.T) {
+	_, err := LocalKeyProvider([]byte("short"))
+	assert.Error(t, err)
+}
+
+func TestDeterministicPerField(t *testing.T) {
+	c := context.Background()
+	keys, err := LocalKeyProvider([]byte("0123456789abcdef0123456789abcdef"))
+	assert.NoError(t, err)
+	e := New(keys,
+		Field{Path: "id_number", Algorithm: Deterministic},
+		Field{Path: "passport", Algorithm: Deterministic},
+	)
+
+	// 相同的值在不同字段上的密文不同，不能跨字段比较
+	f, _ := e.Field("id_number")
+	a, err := e.Encrypt(c, f, "E12345678")
+	assert.NoError(t, err)
+	f, _ = e.Field("passport")
+	b, err := e.Encrypt(c, f, "E12345678")
+	assert.NoError(t, err)
+	assert.NotEqual(t, a, b)
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	assert.Equal(t, Deterministic, ParseAlgorithm("AEAD_AES_256_CBC_HMAC_SHA_512-Deterministic"))
+	assert.Equal(t, Deterministic, ParseAlgorithm(string(Deterministic)))
+	assert.Equal(t, Random, ParseAlgorithm("AEAD_AES_256_CBC_HMAC_SHA_512-Random"))
+	assert.Equal(t, Random, ParseAlgorithm(""))
+}
+
+func TestDeterministicNumbers(t *testing.T) {
+	c := context.Background()
+	keys, err := LocalKeyProvider([]byte("0123456789abcdef0123456789abcdef"))
+	assert.NoError(t, err)
+	e := New(keys, Field{Path: "level", Algorithm: Deterministic})
+	f, _ := e.Field("level")
+
+	// DTO 中的 int 和查询时按 schema 转换的 int32 得到相同的密文
+	a, err := e.Encrypt(c, f, 3)
+	assert.NoError(t, err)
+	b, err := e.Encrypt(c, f, int32(3))
+	assert.NoError(t, err)
+	assert.Equal(t, a, b)
+
+	v, err := e.Decrypt(c, "level", a)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), v)
+}
+
+func TestChildPath(t *testing.T) {
+	assert.Equal(t, "name", childPath("", "name"))
+	assert.Equal(t, "contacts.phone", childPath("contacts", "phone"))
+	assert.Equal(t, "contacts.phone", childPath("", "contacts.0.phone"))
+}
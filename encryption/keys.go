@@ -0,0 +1,44 @@
+package encryption
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+)
+
+// KeyProvider 提供数据密钥，可以对接 KMS，返回的密钥至少 32 字节
+type KeyProvider interface {
+	DataKey(c context.Context, keyID string) ([]byte, error)
+}
+
+type KeyProviderFunc func(c context.Context, keyID string) ([]byte, error)
+
+func (f KeyProviderFunc) DataKey(c context.Context, keyID string) ([]byte, error) {
+	return f(c, keyID)
+}
+
+type localKeyProvider struct {
+	masterKey []byte
+}
+
+// LocalKeyProvider 用本地主密钥为每个 keyID 派生数据密钥，不依赖任何外部服务，
+// 主密钥丢失后数据无法解密，需要妥善保存
+func LocalKeyProvider(masterKey []byte) (KeyProvider, error) {
+	if len(masterKey) < 32 {
+		return nil, errors.New("master key must be at least 32 bytes")
+	}
+	key := make([]byte, len(masterKey))
+	copy(key, masterKey)
+	return &localKeyProvider{masterKey: key}, nil
+}
+
+func (p *localKeyProvider) DataKey(c context.Context, keyID string) ([]byte, error) {
+	return derive(p.masterKey, "data key:"+keyID), nil
+}
+
+func derive(key []byte, label string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(label))
+	return mac.Sum(nil)
+}
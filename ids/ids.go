@@ -0,0 +1,109 @@
+package ids
+
+import (
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Strategy 决定仓储如何生成新实体的 _id，以及如何把外部传入的 ID 转换为存储的类型
+type Strategy interface {
+	// Generate 生成新的 ID，返回 nil 表示交给驱动生成 ObjectID
+	Generate() (any, error)
+	// Parse 把外部传入的 ID(通常是字符串) 转换为存储的类型
+	Parse(v any) (any, error)
+}
+
+type Format int
+
+const (
+	// FormatBinary 以 BSON binary subtype 4 存储
+	FormatBinary Format = iota
+	// FormatString 以标准的字符串形式存储
+	FormatString
+)
+
+type autoStrategy struct{}
+
+// Auto 保持旧的行为：不主动生成 ID，看起来像 ObjectID 的字符串会被转换为 ObjectID
+func Auto() Strategy {
+	return autoStrategy{}
+}
+
+func (autoStrategy) Generate() (any, error) {
+	return nil, nil
+}
+
+func (autoStrategy) Parse(v any) (any, error) {
+	if s, ok := v.(string); ok && primitive.IsValidObjectID(s) {
+		return primitive.ObjectIDFromHex(s)
+	}
+	return v, nil
+}
+
+type objectIDStrategy struct{}
+
+func ObjectID() Strategy {
+	return objectIDStrategy{}
+}
+
+func (objectIDStrategy) Generate() (any, error) {
+	return primitive.NewObjectID(), nil
+}
+
+func (objectIDStrategy) Parse(v any) (any, error) {
+	switch t := v.(type) {
+	case primitive.ObjectID:
+		return t, nil
+	case string:
+		id, err := primitive.ObjectIDFromHex(t)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ObjectID %q: %w", t, err)
+		}
+		return id, nil
+	}
+	return nil, fmt.Errorf("invalid ObjectID %v", v)
+}
+
+type funcStrategy struct {
+	generate func() (any, error)
+	parse    func(v any) (any, error)
+}
+
+// Func 使用自定义的生成和转换函数，parse 为 nil 时原样返回
+func Func(generate func() (any, error), parse func(v any) (any, error)) Strategy {
+	return &funcStrategy{
+		generate: generate,
+		parse:    parse,
+	}
+}
+
+func (s *funcStrategy) Generate() (any, error) {
+	return s.generate()
+}
+
+func (s *funcStrategy) Parse(v any) (any, error) {
+	if s.parse == nil {
+		return v, nil
+	}
+	return s.parse(v)
+}
+
+// IsZero 判断文档中的 _id 等自动生成的字段是否需要生成
+func IsZero(v any) bool {
+	switch t := v.(type) {
+	case nil:
+		return true
+	case string:
+		return t == ""
+	case int32:
+		return t == 0
+	case int64:
+		return t == 0
+	case primitive.ObjectID:
+		return t.IsZero()
+	case primitive.Binary:
+		return len(t.Data) == 0
+	}
+	return false
+}
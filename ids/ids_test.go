@@ -0,0 +1,65 @@
+package ids
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestStrategies(t *testing.T) {
+	strategies := map[string]Strategy{
+		"objectid": ObjectID(),
+		"uuidv4":   UUIDv4(FormatBinary),
+		"uuidv7":   UUIDv7(FormatString),
+		"ulid":     ULID(),
+		"ksuid":    KSUID(),
+	}
+
+	for name, s := range strategies {
+		id, err := s.Generate()
+		assert.NoError(t, err, name)
+
+		parsed, err := s.Parse(id)
+		assert.NoError(t, err, name)
+		assert.Equal(t, id, parsed, name)
+	}
+}
+
+func TestUUIDParse(t *testing.T) {
+	u, err := NewUUIDv7()
+	assert.NoError(t, err)
+	assert.Equal(t, byte(0x70), u[6]&0xf0)
+
+	id, err := UUIDv4(FormatBinary).Parse(u.String())
+	assert.NoError(t, err)
+	assert.Equal(t, primitive.Binary{Subtype: 0x04, Data: u[:]}, id)
+
+	_, err = UUIDv4(FormatString).Parse("not-a-uuid")
+	assert.Error(t, err)
+}
+
+func TestHexStringIsNotObjectID(t *testing.T) {
+	hex := "0123456789abcdef01234567"
+
+	id, err := Func(func() (any, error) { return hex, nil }, nil).Parse(hex)
+	assert.NoError(t, err)
+	assert.Equal(t, hex, id)
+
+	id, err = Auto().Parse(hex)
+	assert.NoError(t, err)
+	assert.IsType(t, primitive.ObjectID{}, id)
+}
+
+func TestULIDAndKSUIDFormat(t *testing.T) {
+	ulid, err := NewULID()
+	assert.NoError(t, err)
+	assert.Len(t, ulid, 26)
+
+	ksuid, err := NewKSUID()
+	assert.NoError(t, err)
+	assert.Len(t, ksuid, 27)
+
+	_, err = ParseKSUID("!" + ksuid[1:])
+	assert.Error(t, err)
+}
@@ -0,0 +1,73 @@
+package ids
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	base62 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	// KSUID 的时间戳从 2014-05-13 16:53:20 UTC 开始计算
+	ksuidEpoch = 1400000000
+)
+
+// NewKSUID 生成 27 位 base62 编码的 KSUID，由 4 字节秒级时间戳和 16 字节随机数组成
+func NewKSUID() (string, error) {
+	var b [20]byte
+	if _, err := rand.Read(b[4:]); err != nil {
+		return "", err
+	}
+	binary.BigEndian.PutUint32(b[0:4], uint32(time.Now().Unix()-ksuidEpoch))
+
+	n := new(big.Int).SetBytes(b[:])
+	out := make([]byte, 27)
+	mod := new(big.Int)
+	radix := big.NewInt(62)
+	for i := len(out) - 1; i >= 0; i-- {
+		n.DivMod(n, radix, mod)
+		out[i] = base62[mod.Int64()]
+	}
+	return string(out), nil
+}
+
+func ParseKSUID(s string) (string, error) {
+	if len(s) != 27 {
+		return "", fmt.Errorf("invalid KSUID %q", s)
+	}
+
+	n := new(big.Int)
+	radix := big.NewInt(62)
+	for i := 0; i < len(s); i++ {
+		d := strings.IndexByte(base62, s[i])
+		if d < 0 {
+			return "", fmt.Errorf("invalid KSUID %q", s)
+		}
+		n.Mul(n, radix).Add(n, big.NewInt(int64(d)))
+	}
+	if n.BitLen() > 160 {
+		return "", fmt.Errorf("invalid KSUID %q", s)
+	}
+	return s, nil
+}
+
+type ksuidStrategy struct{}
+
+func KSUID() Strategy {
+	return ksuidStrategy{}
+}
+
+func (ksuidStrategy) Generate() (any, error) {
+	return NewKSUID()
+}
+
+func (ksuidStrategy) Parse(v any) (any, error) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("invalid KSUID %v", v)
+	}
+	return ParseKSUID(s)
+}
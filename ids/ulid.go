@@ -0,0 +1,71 @@
+package ids
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// NewULID 生成 26 位 Crockford base32 编码的 ULID，前 48 位是毫秒时间戳
+func NewULID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[6:]); err != nil {
+		return "", err
+	}
+
+	ms := uint64(time.Now().UnixMilli())
+	for i := 5; i >= 0; i-- {
+		b[i] = byte(ms)
+		ms >>= 8
+	}
+
+	// 128 位按 5 位一组编码，最高位补 2 个 0 凑成 130 位
+	out := make([]byte, 26)
+	var acc uint64
+	bits := 2
+	j := 0
+	for _, c := range b {
+		acc = acc<<8 | uint64(c)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			out[j] = crockford[(acc>>uint(bits))&0x1f]
+			j++
+		}
+	}
+	return string(out), nil
+}
+
+func ParseULID(s string) (string, error) {
+	s = strings.ToUpper(s)
+	if len(s) != 26 || s[0] > '7' {
+		return "", fmt.Errorf("invalid ULID %q", s)
+	}
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(crockford, s[i]) < 0 {
+			return "", fmt.Errorf("invalid ULID %q", s)
+		}
+	}
+	return s, nil
+}
+
+type ulidStrategy struct{}
+
+func ULID() Strategy {
+	return ulidStrategy{}
+}
+
+func (ulidStrategy) Generate() (any, error) {
+	return NewULID()
+}
+
+func (ulidStrategy) Parse(v any) (any, error) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("invalid ULID %v", v)
+	}
+	return ParseULID(s)
+}
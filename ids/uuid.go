@@ -0,0 +1,127 @@
+package ids
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type UUID [16]byte
+
+func (u UUID) String() string {
+	buf := make([]byte, 36)
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], u[10:])
+	return string(buf)
+}
+
+func (u UUID) Binary() primitive.Binary {
+	return primitive.Binary{Subtype: 0x04, Data: u[:]}
+}
+
+func NewUUIDv4() (UUID, error) {
+	var u UUID
+	if _, err := rand.Read(u[:]); err != nil {
+		return u, err
+	}
+	u[6] = (u[6] & 0x0f) | 0x40
+	u[8] = (u[8] & 0x3f) | 0x80
+	return u, nil
+}
+
+// NewUUIDv7 生成按时间有序的 UUID，前 48 位是毫秒时间戳
+func NewUUIDv7() (UUID, error) {
+	var u UUID
+	if _, err := rand.Read(u[6:]); err != nil {
+		return u, err
+	}
+
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(time.Now().UnixMilli()))
+	copy(u[0:6], ts[2:])
+
+	u[6] = (u[6] & 0x0f) | 0x70
+	u[8] = (u[8] & 0x3f) | 0x80
+	return u, nil
+}
+
+func ParseUUID(s string) (UUID, error) {
+	var u UUID
+	if len(s) == 32 {
+		_, err := hex.Decode(u[:], []byte(s))
+		return u, err
+	}
+
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return u, fmt.Errorf("invalid UUID %q", s)
+	}
+
+	b := []byte(s[0:8] + s[9:13] + s[14:18] + s[19:23] + s[24:])
+	if _, err := hex.Decode(u[:], b); err != nil {
+		return u, fmt.Errorf("invalid UUID %q: %w", s, err)
+	}
+	return u, nil
+}
+
+type uuidStrategy struct {
+	generate func() (UUID, error)
+	format   Format
+}
+
+func UUIDv4(format Format) Strategy {
+	return &uuidStrategy{generate: NewUUIDv4, format: format}
+}
+
+func UUIDv7(format Format) Strategy {
+	return &uuidStrategy{generate: NewUUIDv7, format: format}
+}
+
+func (s *uuidStrategy) Generate() (any, error) {
+	u, err := s.generate()
+	if err != nil {
+		return nil, err
+	}
+	return s.encode(u), nil
+}
+
+func (s *uuidStrategy) Parse(v any) (any, error) {
+	var u UUID
+	switch t := v.(type) {
+	case UUID:
+		u = t
+	case [16]byte:
+		u = t
+	case primitive.Binary:
+		if len(t.Data) != 16 {
+			return nil, fmt.Errorf("invalid UUID binary length %d", len(t.Data))
+		}
+		copy(u[:], t.Data)
+	case string:
+		var err error
+		u, err = ParseUUID(t)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("invalid UUID %v", v)
+	}
+	return s.encode(u), nil
+}
+
+func (s *uuidStrategy) encode(u UUID) any {
+	if s.format == FormatString {
+		return u.String()
+	}
+	return u.Binary()
+}
@@ -0,0 +1,102 @@
+package logging
+
+import (
+	"context"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Logger 与 *slog.Logger 的方法签名一致，可以直接传入 slog.Default()
+type Logger interface {
+	DebugContext(ctx context.Context, msg string, args ...any)
+	InfoContext(ctx context.Context, msg string, args ...any)
+	WarnContext(ctx context.Context, msg string, args ...any)
+	ErrorContext(ctx context.Context, msg string, args ...any)
+}
+
+type nopLogger struct{}
+
+func Nop() Logger {
+	return nopLogger{}
+}
+
+func (nopLogger) DebugContext(ctx context.Context, msg string, args ...any) {}
+func (nopLogger) InfoContext(ctx context.Context, msg string, args ...any)  {}
+func (nopLogger) WarnContext(ctx context.Context, msg string, args ...any)  {}
+func (nopLogger) ErrorContext(ctx context.Context, msg string, args ...any) {}
+
+const Redacted = "[REDACTED]"
+
+// Redact 返回 v 的副本，其中字段名(或点分路径的最后一段)在 fields 中的值被替换为 Redacted
+func Redact(v any, fields []string) any {
+	if len(fields) == 0 {
+		return v
+	}
+
+	set := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		set[field] = true
+	}
+	return redact(v, set)
+}
+
+func redact(v any, fields map[string]bool) any {
+	switch t := v.(type) {
+	case bson.M:
+		return redactMap(t, fields)
+	case map[string]any:
+		return redactMap(t, fields)
+	case bson.D:
+		r := make(bson.D, len(t))
+		for i, e := range t {
+			if isRedacted(e.Key, fields) {
+				r[i] = bson.E{Key: e.Key, Value: Redacted}
+				continue
+			}
+			r[i] = bson.E{Key: e.Key, Value: redact(e.Value, fields)}
+		}
+		return r
+	case []bson.M:
+		r := make([]bson.M, len(t))
+		for i, m := range t {
+			r[i] = redactMap(m, fields)
+		}
+		return r
+	case bson.A:
+		r := make(bson.A, len(t))
+		for i, e := range t {
+			r[i] = redact(e, fields)
+		}
+		return r
+	case []any:
+		r := make([]any, len(t))
+		for i, e := range t {
+			r[i] = redact(e, fields)
+		}
+		return r
+	}
+	return v
+}
+
+func redactMap(m map[string]any, fields map[string]bool) bson.M {
+	r := make(bson.M, len(m))
+	for k, v := range m {
+		if isRedacted(k, fields) {
+			r[k] = Redacted
+			continue
+		}
+		r[k] = redact(v, fields)
+	}
+	return r
+}
+
+func isRedacted(key string, fields map[string]bool) bool {
+	if fields[key] {
+		return true
+	}
+	if i := strings.LastIndexByte(key, '.'); i >= 0 {
+		return fields[key[i+1:]]
+	}
+	return false
+}
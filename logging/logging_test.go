@@ -0,0 +1,29 @@
+package logging
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestRedact(t *testing.T) {
+	filter := bson.M{
+		"$and": []bson.M{
+			{"password": bson.M{"$eq": "secret"}},
+			{"profile.phone": bson.M{"$in": bson.A{"123"}}},
+			{"name": bson.M{"$eq": "张三"}},
+		},
+	}
+
+	assert.Equal(t, bson.M{
+		"$and": []bson.M{
+			{"password": Redacted},
+			{"profile.phone": Redacted},
+			{"name": bson.M{"$eq": "张三"}},
+		},
+	}, Redact(filter, []string{"password", "phone"}))
+
+	// 原始条件不能被修改
+	assert.Equal(t, bson.M{"$eq": "secret"}, filter["$and"].([]bson.M)[0]["password"])
+}
@@ -0,0 +1,351 @@
+package memory
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// group 执行 AggregateBuilder 生成的 $group，支持 $sum/$avg/$max/$min 和 count 用到的表达式
+func group(docs []bson.M, spec bson.M) ([]bson.M, error) {
+	type bucket struct {
+		id     any
+		values map[string][]any
+	}
+
+	var buckets []*bucket
+	index := map[string]*bucket{}
+
+	for _, doc := range docs {
+		id, _, err := eval(doc, spec["_id"])
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := bson.Marshal(bson.D{{Key: "v", Value: canonical(id)}})
+		if err != nil {
+			return nil, err
+		}
+
+		b, ok := index[string(key)]
+		if !ok {
+			b = &bucket{id: id, values: map[string][]any{}}
+			index[string(key)] = b
+			buckets = append(buckets, b)
+		}
+
+		for field, acc := range spec {
+			if field == "_id" {
+				continue
+			}
+			_, expr, err := accumulator(acc)
+			if err != nil {
+				return nil, err
+			}
+			v, missing, err := eval(doc, expr)
+			if err != nil {
+				return nil, err
+			}
+			if !missing {
+				b.values[field] = append(b.values[field], v)
+			}
+		}
+	}
+
+	result := make([]bson.M, len(buckets))
+	for i, b := range buckets {
+		row := bson.M{"_id": b.id}
+		for field, acc := range spec {
+			if field == "_id" {
+				continue
+			}
+			op, _, _ := accumulator(acc)
+			row[field] = accumulate(op, b.values[field])
+		}
+		result[i] = row
+	}
+	return result, nil
+}
+
+func accumulator(acc any) (string, any, error) {
+	m, ok := acc.(bson.M)
+	if !ok || len(m) != 1 {
+		return "", nil, fmt.Errorf("invalid accumulator %v", acc)
+	}
+	for op, expr := range m {
+		switch op {
+		case "$sum", "$avg", "$max", "$min":
+			return op, expr, nil
+		}
+		return "", nil, fmt.Errorf("unsupported accumulator %s", op)
+	}
+	return "", nil, nil
+}
+
+func accumulate(op string, values []any) any {
+	switch op {
+	case "$sum":
+		return sum(values)
+	case "$avg":
+		var total float64
+		n := 0
+		for _, v := range values {
+			if isNumber(v) {
+				total += toFloat64(v)
+				n++
+			}
+		}
+		if n == 0 {
+			return nil
+		}
+		return total / float64(n)
+	case "$max", "$min":
+		var r any
+		for _, v := range values {
+			if typeOrder(v) == 1 {
+				continue
+			}
+			if r == nil || (op == "$max" && compare(v, r) > 0) || (op == "$min" && compare(v, r) < 0) {
+				r = v
+			}
+		}
+		return r
+	}
+	return nil
+}
+
+// sum 和 MongoDB 一样保持最窄的数字类型：都是 int32 时返回 int32，溢出或有 int64 时返回 int64，有小数时返回 float64
+func sum(values []any) any {
+	var ints int64
+	var floats float64
+	width := 32
+	for _, v := range values {
+		switch t := v.(type) {
+		case int32:
+			ints += int64(t)
+		case int64:
+			ints += t
+			if width < 64 {
+				width = 64
+			}
+		case float64, primitive.Decimal128:
+			floats += toFloat64(t)
+			width = 128
+		}
+	}
+
+	switch {
+	case width == 128:
+		return floats + float64(ints)
+	case width == 32 && ints >= math.MinInt32 && ints <= math.MaxInt32:
+		return int32(ints)
+	}
+	return ints
+}
+
+// eval 计算聚合表达式：$字段路径、字面量、表达式对象以及 $cond/$in/$type
+func eval(doc bson.M, expr any) (v any, missing bool, err error) {
+	switch t := expr.(type) {
+	case string:
+		if strings.HasPrefix(t, "$") {
+			return path(doc, t[1:])
+		}
+		return t, false, nil
+	case bson.M:
+		if isOperators(t) {
+			return evalOperator(doc, t)
+		}
+		// 表达式对象中不存在的字段会被忽略
+		r := bson.M{}
+		for k, e := range t {
+			v, missing, err := eval(doc, e)
+			if err != nil {
+				return nil, false, err
+			}
+			if !missing {
+				r[k] = v
+			}
+		}
+		return r, false, nil
+	case primitive.A:
+		r := make(primitive.A, len(t))
+		for i, e := range t {
+			v, _, err := eval(doc, e)
+			if err != nil {
+				return nil, false, err
+			}
+			r[i] = v
+		}
+		return r, false, nil
+	}
+	return expr, false, nil
+}
+
+func evalOperator(doc bson.M, expr bson.M) (any, bool, error) {
+	if len(expr) != 1 {
+		return nil, false, fmt.Errorf("invalid expression %v", expr)
+	}
+
+	for op, arg := range expr {
+		switch op {
+		case "$cond":
+			var cond, then, els any
+			switch t := arg.(type) {
+			case bson.M:
+				cond, then, els = t["if"], t["then"], t["else"]
+			case primitive.A:
+				if len(t) != 3 {
+					return nil, false, fmt.Errorf("$cond expects 3 arguments, got %d", len(t))
+				}
+				cond, then, els = t[0], t[1], t[2]
+			default:
+				return nil, false, fmt.Errorf("invalid $cond %v", arg)
+			}
+
+			v, missing, err := eval(doc, cond)
+			if err != nil {
+				return nil, false, err
+			}
+			if !missing && truthy(v) {
+				return eval(doc, then)
+			}
+			return eval(doc, els)
+		case "$in":
+			args, ok := arg.(primitive.A)
+			if !ok || len(args) != 2 {
+				return nil, false, fmt.Errorf("$in expects 2 arguments, got %v", arg)
+			}
+			v, _, err := eval(doc, args[0])
+			if err != nil {
+				return nil, false, err
+			}
+			arr, _, err := eval(doc, args[1])
+			if err != nil {
+				return nil, false, err
+			}
+			list, ok := arr.(primitive.A)
+			if !ok {
+				return nil, false, fmt.Errorf("$in requires an array as a second argument, got %v", arr)
+			}
+			for _, e := range list {
+				if typeOrder(e) == typeOrder(v) && compare(e, v) == 0 {
+					return true, false, nil
+				}
+			}
+			return false, false, nil
+		case "$type":
+			v, missing, err := eval(doc, arg)
+			if err != nil {
+				return nil, false, err
+			}
+			if missing {
+				return "missing", false, nil
+			}
+			return typeName(v), false, nil
+		}
+		return nil, false, fmt.Errorf("unsupported expression operator %s", op)
+	}
+	return nil, false, nil
+}
+
+// path 按字段路径取值，数组中的文档会取出每个文档的字段组成数组
+func path(doc bson.M, p string) (any, bool, error) {
+	var v any = doc
+	for _, key := range strings.Split(p, ".") {
+		switch t := v.(type) {
+		case bson.M:
+			next, ok := t[key]
+			if !ok {
+				return nil, true, nil
+			}
+			v = next
+		case primitive.A:
+			r := primitive.A{}
+			for _, e := range t {
+				if m, ok := e.(bson.M); ok {
+					if next, ok := m[key]; ok {
+						r = append(r, next)
+					}
+				}
+			}
+			v = r
+		default:
+			return nil, true, nil
+		}
+	}
+	return v, false, nil
+}
+
+func truthy(v any) bool {
+	switch t := v.(type) {
+	case nil, primitive.Null, primitive.Undefined:
+		return false
+	case bool:
+		return t
+	}
+	if isNumber(v) {
+		return toFloat64(v) != 0
+	}
+	return true
+}
+
+func typeName(v any) string {
+	switch v.(type) {
+	case nil, primitive.Null:
+		return "null"
+	case primitive.Undefined:
+		return "undefined"
+	case int32:
+		return "int"
+	case int64:
+		return "long"
+	case float64:
+		return "double"
+	case primitive.Decimal128:
+		return "decimal"
+	case string:
+		return "string"
+	case primitive.Symbol:
+		return "symbol"
+	case bson.M:
+		return "object"
+	case primitive.A:
+		return "array"
+	case primitive.Binary:
+		return "binData"
+	case primitive.ObjectID:
+		return "objectId"
+	case bool:
+		return "bool"
+	case primitive.DateTime:
+		return "date"
+	case primitive.Timestamp:
+		return "timestamp"
+	case primitive.Regex:
+		return "regex"
+	}
+	return "unknown"
+}
+
+// canonical 把 bson.M 转换为按字段名排序的 bson.D，用作分组的 key
+func canonical(v any) any {
+	m, ok := v.(bson.M)
+	if !ok {
+		return v
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	d := make(bson.D, len(keys))
+	for i, k := range keys {
+		d[i] = bson.E{Key: k, Value: canonical(m[k])}
+	}
+	return d
+}
@@ -0,0 +1,213 @@
+package memory
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// typeOrder 是 MongoDB 比较不同类型的值时使用的顺序
+func typeOrder(v any) int {
+	switch v.(type) {
+	case nil, primitive.Null, primitive.Undefined:
+		return 1
+	case int32, int64, float64, primitive.Decimal128:
+		return 2
+	case string, primitive.Symbol:
+		return 3
+	case bson.M:
+		return 4
+	case primitive.A:
+		return 5
+	case primitive.Binary:
+		return 6
+	case primitive.ObjectID:
+		return 7
+	case bool:
+		return 8
+	case primitive.DateTime:
+		return 9
+	case primitive.Timestamp:
+		return 10
+	case primitive.Regex:
+		return 11
+	case primitive.MaxKey:
+		return 12
+	}
+	return 0
+}
+
+// compare 按 MongoDB 的规则比较两个值，字符串按二进制比较，不考虑排序规则
+func compare(a, b any) int {
+	oa, ob := typeOrder(a), typeOrder(b)
+	if oa != ob {
+		return compareInt(int64(oa), int64(ob))
+	}
+
+	switch x := a.(type) {
+	case int32, int64, float64, primitive.Decimal128:
+		return compareNumber(x, b)
+	case string:
+		return compareString(x, toString(b))
+	case primitive.Symbol:
+		return compareString(string(x), toString(b))
+	case bson.M:
+		return compareDocument(x, b.(bson.M))
+	case primitive.A:
+		return compareArray(x, b.(primitive.A))
+	case primitive.Binary:
+		y := b.(primitive.Binary)
+		if len(x.Data) != len(y.Data) {
+			return compareInt(int64(len(x.Data)), int64(len(y.Data)))
+		}
+		if x.Subtype != y.Subtype {
+			return compareInt(int64(x.Subtype), int64(y.Subtype))
+		}
+		return bytes.Compare(x.Data, y.Data)
+	case primitive.ObjectID:
+		y := b.(primitive.ObjectID)
+		return bytes.Compare(x[:], y[:])
+	case bool:
+		y := b.(bool)
+		if x == y {
+			return 0
+		}
+		if !x {
+			return -1
+		}
+		return 1
+	case primitive.DateTime:
+		return compareInt(int64(x), int64(b.(primitive.DateTime)))
+	case primitive.Timestamp:
+		return primitive.CompareTimestamp(x, b.(primitive.Timestamp))
+	case primitive.Regex:
+		y := b.(primitive.Regex)
+		if c := compareString(x.Pattern, y.Pattern); c != 0 {
+			return c
+		}
+		return compareString(x.Options, y.Options)
+	}
+	return 0
+}
+
+func compareInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareString(a, b string) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func toString(v any) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case primitive.Symbol:
+		return string(t)
+	}
+	return ""
+}
+
+// compareNumber 比较不同类型的数字，都是整数时精确比较
+func compareNumber(a, b any) int {
+	ia, aInt := toInt64(a)
+	ib, bInt := toInt64(b)
+	if aInt && bInt {
+		return compareInt(ia, ib)
+	}
+
+	fa, fb := toFloat64(a), toFloat64(b)
+	switch {
+	case math.IsNaN(fa) && math.IsNaN(fb):
+		return 0
+	case math.IsNaN(fa):
+		return -1
+	case math.IsNaN(fb):
+		return 1
+	case fa < fb:
+		return -1
+	case fa > fb:
+		return 1
+	}
+	return 0
+}
+
+func toInt64(v any) (int64, bool) {
+	switch t := v.(type) {
+	case int32:
+		return int64(t), true
+	case int64:
+		return t, true
+	}
+	return 0, false
+}
+
+func toFloat64(v any) float64 {
+	switch t := v.(type) {
+	case int32:
+		return float64(t)
+	case int64:
+		return float64(t)
+	case float64:
+		return t
+	case primitive.Decimal128:
+		f, err := strconv.ParseFloat(t.String(), 64)
+		if err != nil {
+			return math.NaN()
+		}
+		return f
+	}
+	return math.NaN()
+}
+
+func isNumber(v any) bool {
+	return typeOrder(v) == 2
+}
+
+// compareDocument 按排序后的字段名逐个比较，bson.M 不保留字段顺序
+func compareDocument(a, b bson.M) int {
+	ka, kb := sortedKeys(a), sortedKeys(b)
+	for i := 0; i < len(ka) && i < len(kb); i++ {
+		if c := compareString(ka[i], kb[i]); c != 0 {
+			return c
+		}
+		if c := compare(a[ka[i]], b[kb[i]]); c != 0 {
+			return c
+		}
+	}
+	return compareInt(int64(len(ka)), int64(len(kb)))
+}
+
+func compareArray(a, b primitive.A) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := compare(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return compareInt(int64(len(a)), int64(len(b)))
+}
+
+func sortedKeys(m bson.M) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
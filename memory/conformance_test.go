@@ -0,0 +1,14 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/duolacloud/crud-core-mongo/conformance"
+	core_repositories "github.com/duolacloud/crud-core/repositories"
+)
+
+func TestMemoryConformance(t *testing.T) {
+	conformance.Run(t, func(t *testing.T) core_repositories.CrudRepository[conformance.User, conformance.User, conformance.User] {
+		return NewMemoryCrudRepository[conformance.User, conformance.User, conformance.User](conformance.UserSchema)
+	})
+}
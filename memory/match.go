@@ -0,0 +1,268 @@
+package memory
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// normalize 把值转换为从 bson 解码出的形式(bson.M、primitive.A、primitive.DateTime、int32 ...)，
+// 编译好的筛选条件和保存的文档使用同样的类型才能比较
+func normalize(v any) (any, error) {
+	data, err := bson.Marshal(bson.D{{Key: "v", Value: v}})
+	if err != nil {
+		return nil, err
+	}
+	var m bson.M
+	if err := bson.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return m["v"], nil
+}
+
+// match 判断文档是否满足 query 包编译出的 MongoDB 筛选条件，filter 需要先经过 normalize
+func match(doc bson.M, filter bson.M) (bool, error) {
+	for key, value := range filter {
+		ok, err := matchKey(doc, key, value)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchKey(doc bson.M, key string, value any) (bool, error) {
+	switch key {
+	case "$and", "$or", "$nor":
+		filters, ok := value.(primitive.A)
+		if !ok {
+			return false, fmt.Errorf("%s expects an array, got %v", key, value)
+		}
+		for _, f := range filters {
+			sub, ok := f.(bson.M)
+			if !ok {
+				return false, fmt.Errorf("%s expects an array of filters, got %v", key, f)
+			}
+			ok, err := match(doc, sub)
+			if err != nil {
+				return false, err
+			}
+			switch {
+			case key == "$and" && !ok:
+				return false, nil
+			case key == "$or" && ok:
+				return true, nil
+			case key == "$nor" && ok:
+				return false, nil
+			}
+		}
+		return key != "$or" || len(filters) == 0, nil
+	}
+
+	if strings.HasPrefix(key, "$") {
+		return false, fmt.Errorf("unsupported operator %s", key)
+	}
+
+	candidates, missing := lookup(doc, strings.Split(key, "."))
+	if ops, ok := value.(bson.M); ok && isOperators(ops) {
+		return matchOperators(candidates, missing, ops)
+	}
+	return matchEq(candidates, missing, value), nil
+}
+
+func isOperators(m bson.M) bool {
+	if len(m) == 0 {
+		return false
+	}
+	for k := range m {
+		if !strings.HasPrefix(k, "$") {
+			return false
+		}
+	}
+	return true
+}
+
+// lookup 返回路径上所有可能的值：字段是数组时包括数组本身和其中的元素，
+// 数组中的文档会继续按剩下的路径查找。missing 表示某个分支上字段不存在
+func lookup(v any, path []string) (candidates []any, missing bool) {
+	if len(path) == 0 {
+		candidates = append(candidates, v)
+		if arr, ok := v.(primitive.A); ok {
+			candidates = append(candidates, arr...)
+		}
+		return candidates, false
+	}
+
+	switch t := v.(type) {
+	case bson.M:
+		next, ok := t[path[0]]
+		if !ok {
+			return nil, true
+		}
+		return lookup(next, path[1:])
+	case primitive.A:
+		if i, err := strconv.Atoi(path[0]); err == nil {
+			if i < 0 || i >= len(t) {
+				return nil, true
+			}
+			return lookup(t[i], path[1:])
+		}
+
+		found := false
+		for _, e := range t {
+			if _, ok := e.(bson.M); !ok {
+				continue
+			}
+			c, m := lookup(e, path)
+			candidates = append(candidates, c...)
+			missing = missing || m
+			found = true
+		}
+		return candidates, missing || !found
+	}
+	return nil, true
+}
+
+func matchOperators(candidates []any, missing bool, ops bson.M) (bool, error) {
+	for op, operand := range ops {
+		var ok bool
+		var err error
+
+		switch op {
+		case "$eq":
+			ok = matchEq(candidates, missing, operand)
+		case "$ne":
+			ok = !matchEq(candidates, missing, operand)
+		case "$gt", "$gte", "$lt", "$lte":
+			ok = matchRange(candidates, missing, op, operand)
+		case "$in", "$nin":
+			ok, err = matchIn(candidates, missing, operand)
+			if op == "$nin" {
+				ok = !ok
+			}
+		case "$regex":
+			var re *regexp.Regexp
+			re, err = compileRegex(operand, ops["$options"])
+			ok = err == nil && matchRegex(candidates, re)
+		case "$options":
+			continue
+		case "$exists":
+			exists, _ := operand.(bool)
+			ok = exists == (len(candidates) > 0)
+		case "$not":
+			switch t := operand.(type) {
+			case bson.M:
+				ok, err = matchOperators(candidates, missing, t)
+			case primitive.Regex:
+				var re *regexp.Regexp
+				re, err = compileRegex(t, nil)
+				ok = err == nil && matchRegex(candidates, re)
+			default:
+				err = fmt.Errorf("$not expects an object or a regex, got %v", operand)
+			}
+			ok = !ok
+		default:
+			err = fmt.Errorf("unsupported operator %s", op)
+		}
+
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// matchEq 和 MongoDB 一样，null 同时匹配 null 和不存在的字段
+func matchEq(candidates []any, missing bool, operand any) bool {
+	if typeOrder(operand) == 1 && missing {
+		return true
+	}
+	for _, v := range candidates {
+		if typeOrder(v) == typeOrder(operand) && compare(v, operand) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// matchRange 只比较同一类型的值，null 只在 $gte/$lte 时匹配 null 和不存在的字段
+func matchRange(candidates []any, missing bool, op string, operand any) bool {
+	if typeOrder(operand) == 1 {
+		return (op == "$gte" || op == "$lte") && matchEq(candidates, missing, operand)
+	}
+
+	for _, v := range candidates {
+		if typeOrder(v) != typeOrder(operand) {
+			continue
+		}
+		c := compare(v, operand)
+		if (op == "$gt" && c > 0) || (op == "$gte" && c >= 0) || (op == "$lt" && c < 0) || (op == "$lte" && c <= 0) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchIn(candidates []any, missing bool, operand any) (bool, error) {
+	arr, ok := operand.(primitive.A)
+	if !ok {
+		return false, fmt.Errorf("$in expects an array, got %v", operand)
+	}
+
+	for _, e := range arr {
+		if re, ok := e.(primitive.Regex); ok {
+			compiled, err := compileRegex(re, nil)
+			if err != nil {
+				return false, err
+			}
+			if matchRegex(candidates, compiled) {
+				return true, nil
+			}
+			continue
+		}
+		if matchEq(candidates, missing, e) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func matchRegex(candidates []any, re *regexp.Regexp) bool {
+	for _, v := range candidates {
+		if s, ok := v.(string); ok && re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// compileRegex 支持 i/m/s 选项，其他选项被忽略
+func compileRegex(pattern any, options any) (*regexp.Regexp, error) {
+	var expr, opts string
+	switch t := pattern.(type) {
+	case primitive.Regex:
+		expr, opts = t.Pattern, t.Options
+	case string:
+		expr = t
+	default:
+		return nil, fmt.Errorf("$regex expects a string or a regex, got %v", pattern)
+	}
+	if s, ok := options.(string); ok {
+		opts = s
+	}
+
+	var flags string
+	for _, o := range opts {
+		if strings.ContainsRune("ims", o) {
+			flags += string(o)
+		}
+	}
+	if flags != "" {
+		expr = "(?" + flags + ")" + expr
+	}
+	return regexp.Compile(expr)
+}
@@ -0,0 +1,107 @@
+package memory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMatch(t *testing.T) {
+	doc, err := normalize(bson.M{
+		"name":     "tom",
+		"age":      20,
+		"score":    9.5,
+		"tags":     []string{"a", "b"},
+		"birthday": time.Date(2000, 1, 2, 0, 0, 0, 0, time.UTC),
+		"address":  bson.M{"city": "beijing"},
+		"contacts": []bson.M{{"type": "phone"}, {"type": "email"}},
+		"deleted":  nil,
+	})
+	assert.NoError(t, err)
+
+	cases := []struct {
+		filter bson.M
+		match  bool
+	}{
+		{bson.M{"name": "tom"}, true},
+		{bson.M{"name": bson.M{"$eq": "jerry"}}, false},
+		{bson.M{"age": bson.M{"$gt": int64(19), "$lte": 20.0}}, true},
+		{bson.M{"age": bson.M{"$gt": "19"}}, false},
+		{bson.M{"score": bson.M{"$lt": 10}}, true},
+		{bson.M{"tags": "a"}, true},
+		{bson.M{"tags": []string{"a", "b"}}, true},
+		{bson.M{"tags": bson.M{"$in": []string{"c", "b"}}}, true},
+		{bson.M{"tags": bson.M{"$nin": []string{"a"}}}, false},
+		{bson.M{"tags.1": "b"}, true},
+		{bson.M{"birthday": bson.M{"$gte": time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)}}, true},
+		{bson.M{"address.city": bson.M{"$ne": "shanghai"}}, true},
+		{bson.M{"contacts.type": "email"}, true},
+		{bson.M{"missing": nil}, true},
+		{bson.M{"deleted": bson.M{"$eq": nil}}, true},
+		{bson.M{"missing": bson.M{"$ne": nil}}, false},
+		{bson.M{"missing": bson.M{"$ne": "x"}}, true},
+		{bson.M{"missing": bson.M{"$gt": nil}}, false},
+		{bson.M{"name": bson.M{"$regex": "^T", "$options": "i"}}, true},
+		{bson.M{"name": bson.M{"$not": bson.M{"$regex": primitive.Regex{Pattern: "^t"}}}}, false},
+		{bson.M{"$or": []bson.M{{"name": "jerry"}, {"age": 20}}}, true},
+		{bson.M{"$and": []bson.M{{"name": "tom"}, {"age": 21}}}, false},
+		{bson.M{"$nor": []bson.M{{"name": "jerry"}}}, true},
+	}
+
+	for _, c := range cases {
+		f, err := normalize(c.filter)
+		assert.NoError(t, err)
+
+		ok, err := match(doc.(bson.M), f.(bson.M))
+		assert.NoError(t, err)
+		assert.Equal(t, c.match, ok, "%v", c.filter)
+	}
+
+	_, err = match(doc.(bson.M), bson.M{"$where": "true"})
+	assert.Error(t, err)
+}
+
+func TestCompare(t *testing.T) {
+	assert.Equal(t, 0, compare(int32(1), 1.0))
+	assert.Equal(t, -1, compare(int64(1), 1.5))
+	assert.Equal(t, -1, compare(nil, int32(0)))
+	assert.Equal(t, -1, compare(int32(100), "1"))
+	assert.Equal(t, 1, compare(primitive.A{int32(1), int32(2)}, primitive.A{int32(1)}))
+}
+
+func TestGroup(t *testing.T) {
+	var docs []bson.M
+	for _, d := range []bson.M{
+		{"country": "cn", "age": 10},
+		{"country": "cn", "age": 20},
+		{"country": "us", "age": 30.5},
+		{"country": "us"},
+	} {
+		n, err := normalize(d)
+		assert.NoError(t, err)
+		docs = append(docs, n.(bson.M))
+	}
+
+	spec, err := normalize(bson.M{
+		"_id":     bson.M{"group_by_country": "$country"},
+		"sum_age": bson.M{"$sum": "$age"},
+		"avg_age": bson.M{"$avg": "$age"},
+		"max_age": bson.M{"$max": "$age"},
+		"count_age": bson.M{"$sum": bson.M{"$cond": bson.M{
+			"if":   bson.M{"$in": bson.A{bson.M{"$type": "$age"}, bson.A{"missing", "null"}}},
+			"then": 0,
+			"else": 1,
+		}}},
+	})
+	assert.NoError(t, err)
+
+	result, err := group(docs, spec.(bson.M))
+	assert.NoError(t, err)
+	assert.Equal(t, []bson.M{
+		{"_id": bson.M{"group_by_country": "cn"}, "sum_age": int32(30), "avg_age": 15.0, "max_age": int32(20), "count_age": int32(2)},
+		{"_id": bson.M{"group_by_country": "us"}, "sum_age": 30.5, "avg_age": 30.5, "max_age": 30.5, "count_age": int32(1)},
+	}, result)
+}
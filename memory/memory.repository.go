@@ -0,0 +1,609 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/duolacloud/crud-core-mongo/ids"
+	"github.com/duolacloud/crud-core-mongo/query"
+	"github.com/duolacloud/crud-core-mongo/repositories"
+	mongo_schema "github.com/duolacloud/crud-core-mongo/schema"
+	"github.com/duolacloud/crud-core/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type MemoryCrudRepositoryOptions struct {
+	IDStrategy       ids.Strategy
+	StrictValidation bool
+}
+
+type MemoryCrudRepositoryOption func(*MemoryCrudRepositoryOptions)
+
+func WithIDStrategy(s ids.Strategy) MemoryCrudRepositoryOption {
+	return func(o *MemoryCrudRepositoryOptions) {
+		o.IDStrategy = s
+	}
+}
+
+func WithStrictValidation(v bool) MemoryCrudRepositoryOption {
+	return func(o *MemoryCrudRepositoryOptions) {
+		o.StrictValidation = v
+	}
+}
+
+// MemoryCrudRepository 是把数据保存在内存中的仓储，用于没有 MongoDB 的单元测试。
+// 筛选、排序、投影、分页和聚合使用和 MongoCrudRepository 相同的 query 包编译，
+// 再按 MongoDB 的规则在内存中执行。租户、加密、审计等 MongoCrudRepository 的扩展功能不支持，
+// 字符串按二进制比较，不支持排序规则(collation)
+type MemoryCrudRepository[DTO any, CreateDTO any, UpdateDTO any] struct {
+	Schema  *mongo_schema.Schema
+	Options *MemoryCrudRepositoryOptions
+	mutex   sync.RWMutex
+	// docs 按插入顺序保存，没有排序时按这个顺序返回
+	docs []bson.M
+}
+
+func NewMemoryCrudRepository[DTO any, CreateDTO any, UpdateDTO any](
+	schema bson.M,
+	opts ...MemoryCrudRepositoryOption,
+) *MemoryCrudRepository[DTO, CreateDTO, UpdateDTO] {
+	r := &MemoryCrudRepository[DTO, CreateDTO, UpdateDTO]{
+		Schema:  mongo_schema.NewSchema(schema),
+		Options: &MemoryCrudRepositoryOptions{},
+	}
+	for _, o := range opts {
+		o(r.Options)
+	}
+	return r
+}
+
+func (r *MemoryCrudRepository[DTO, CreateDTO, UpdateDTO]) Create(c context.Context, createDTO *CreateDTO, opts ...types.CreateOption) (*DTO, error) {
+	if hook, ok := any(createDTO).(repositories.BeforeCreateHook); ok {
+		hook.BeforeCreate()
+	}
+
+	doc, err := r.createDocument(createDTO)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.indexOf(doc["_id"]) >= 0 {
+		return nil, duplicateKeyError(doc["_id"])
+	}
+	r.docs = append(r.docs, doc)
+
+	return decode[DTO](doc)
+}
+
+// CreateMany 和有序的 InsertMany 一样，遇到重复的 _id 时停止，之前的文档已经写入
+func (r *MemoryCrudRepository[DTO, CreateDTO, UpdateDTO]) CreateMany(c context.Context, items []*CreateDTO, opts ...types.CreateManyOption) ([]*DTO, error) {
+	docs := make([]bson.M, len(items))
+	for i, item := range items {
+		if hook, ok := any(item).(repositories.BeforeCreateHook); ok {
+			hook.BeforeCreate()
+		}
+
+		doc, err := r.createDocument(item)
+		if err != nil {
+			return nil, err
+		}
+		docs[i] = doc
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	dtos := make([]*DTO, 0, len(docs))
+	for i, doc := range docs {
+		if r.indexOf(doc["_id"]) >= 0 {
+			return nil, mongo.BulkWriteException{
+				WriteErrors: []mongo.BulkWriteError{{WriteError: mongo.WriteError{
+					Index:   i,
+					Code:    11000,
+					Message: fmt.Sprintf("E11000 duplicate key error dup key: { _id: %v }", doc["_id"]),
+				}}},
+			}
+		}
+		r.docs = append(r.docs, doc)
+
+		dto, err := decode[DTO](doc)
+		if err != nil {
+			return nil, err
+		}
+		dtos = append(dtos, dto)
+	}
+	return dtos, nil
+}
+
+func (r *MemoryCrudRepository[DTO, CreateDTO, UpdateDTO]) Delete(c context.Context, id types.ID) error {
+	id, err := r.parseID(id)
+	if err != nil {
+		return err
+	}
+
+	key, err := normalize(id)
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if i := r.indexOf(key); i >= 0 {
+		r.docs = append(r.docs[:i], r.docs[i+1:]...)
+	}
+	return nil
+}
+
+func (r *MemoryCrudRepository[DTO, CreateDTO, UpdateDTO]) Update(c context.Context, id types.ID, updateDTO *UpdateDTO, opts ...types.UpdateOption) (*DTO, error) {
+	if hook, ok := any(updateDTO).(repositories.BeforeUpdateHook); ok {
+		hook.BeforeUpdate()
+	}
+
+	id, err := r.parseID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var _opts types.UpdateOptions
+	for _, o := range opts {
+		o(&_opts)
+	}
+
+	set, err := marshalDocument(updateDTO)
+	if err != nil {
+		return nil, err
+	}
+	delete(set, "_id")
+
+	key, err := normalize(id)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	i := r.indexOf(key)
+	if i < 0 {
+		if !_opts.Upsert {
+			return nil, types.ErrNotFound
+		}
+		r.docs = append(r.docs, bson.M{"_id": key})
+		i = len(r.docs) - 1
+	}
+
+	// 和 $set 一样只替换给出的字段，复制一份避免影响已经返回的结果
+	doc := bson.M{}
+	for k, v := range r.docs[i] {
+		doc[k] = v
+	}
+	for k, v := range set {
+		doc[k] = v
+	}
+	r.docs[i] = doc
+
+	return decode[DTO](doc)
+}
+
+func (r *MemoryCrudRepository[DTO, CreateDTO, UpdateDTO]) Get(c context.Context, id types.ID) (*DTO, error) {
+	id, err := r.parseID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := normalize(id)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	i := r.indexOf(key)
+	if i < 0 {
+		return nil, types.ErrNotFound
+	}
+	return decode[DTO](r.docs[i])
+}
+
+func (r *MemoryCrudRepository[DTO, CreateDTO, UpdateDTO]) Query(c context.Context, q *types.PageQuery) ([]*DTO, error) {
+	mq, err := r.newFilterQueryBuilder().BuildQuery(q)
+	if err != nil {
+		return nil, err
+	}
+
+	docs, err := r.find(mq.FilterQuery, mq.Options.Sort)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeAll[DTO](paginate(docs, mq.Options.Skip, mq.Options.Limit), mq.Options.Projection)
+}
+
+func (r *MemoryCrudRepository[DTO, CreateDTO, UpdateDTO]) QueryOne(c context.Context, filter map[string]any) (*DTO, error) {
+	mq, err := r.newFilterQueryBuilder().BuildQuery(&types.PageQuery{Filter: filter})
+	if err != nil {
+		return nil, err
+	}
+
+	docs, err := r.find(mq.FilterQuery, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, types.ErrNotFound
+	}
+	return decode[DTO](docs[0])
+}
+
+// Count 和 CountDocuments 一样忽略分页参数
+func (r *MemoryCrudRepository[DTO, CreateDTO, UpdateDTO]) Count(c context.Context, q *types.PageQuery) (int64, error) {
+	mq, err := r.newFilterQueryBuilder().BuildQuery(q)
+	if err != nil {
+		return 0, err
+	}
+
+	docs, err := r.find(mq.FilterQuery, nil)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(docs)), nil
+}
+
+func (r *MemoryCrudRepository[DTO, CreateDTO, UpdateDTO]) Aggregate(
+	c context.Context,
+	filter map[string]any,
+	aggregateQuery *types.AggregateQuery,
+) ([]*types.AggregateResponse, error) {
+	mq, err := r.newFilterQueryBuilder().BuildAggregateQuery(aggregateQuery, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	docs, err := r.find(mq.FilterQuery, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	spec, err := normalize(mq.Aggregate)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := group(docs, spec.(bson.M))
+	if err != nil {
+		return nil, err
+	}
+	sortDocuments(result, mq.Options.Sort)
+
+	return query.ConvertToAggregateResponse(result)
+}
+
+func (r *MemoryCrudRepository[DTO, CreateDTO, UpdateDTO]) CursorQuery(c context.Context, q *types.CursorQuery) ([]*DTO, *types.CursorExtra, error) {
+	mq, err := r.newFilterQueryBuilder().BuildCursorQuery(q)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	docs, err := r.find(mq.FilterQuery, mq.Options.Sort)
+	if err != nil {
+		return nil, nil, err
+	}
+	docs = paginate(docs, nil, mq.Options.Limit)
+
+	extra := &types.CursorExtra{}
+	if len(docs) == 0 {
+		return nil, extra, nil
+	}
+
+	if len(docs) == int(*mq.Options.Limit) {
+		extra.HasNext = true
+		extra.HasPrevious = true
+
+		docs = docs[0 : len(docs)-1]
+	}
+	if len(docs) == 0 {
+		return nil, extra, nil
+	}
+
+	if mq.Reverse {
+		for i, j := 0, len(docs)-1; i < j; i, j = i+1, j-1 {
+			docs[i], docs[j] = docs[j], docs[i]
+		}
+	}
+
+	mapper := query.NewFieldMapper[DTO]()
+	extra.StartCursor, err = query.EncodeCursor(docs[0], q.Sort, mapper)
+	if err != nil {
+		return nil, nil, err
+	}
+	extra.EndCursor, err = query.EncodeCursor(docs[len(docs)-1], q.Sort, mapper)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	result, err := decodeAll[DTO](docs, mq.Options.Projection)
+	if err != nil {
+		return nil, nil, err
+	}
+	return result, extra, nil
+}
+
+func (r *MemoryCrudRepository[DTO, CreateDTO, UpdateDTO]) newFilterQueryBuilder() *query.FilterQueryBuilder[DTO] {
+	var opts []query.FilterQueryBuilderOption
+	if r.Options.IDStrategy != nil {
+		opts = append(opts, query.WithIDStrategy(r.Options.IDStrategy))
+	}
+	return query.NewFilterQueryBuilder[DTO](r.Schema, r.Options.StrictValidation, opts...)
+}
+
+// find 返回满足条件的文档，sort 是编译好的排序
+func (r *MemoryCrudRepository[DTO, CreateDTO, UpdateDTO]) find(filter bson.M, sort any) ([]bson.M, error) {
+	normalized, err := normalize(filter)
+	if err != nil {
+		return nil, err
+	}
+	f, _ := normalized.(bson.M)
+
+	r.mutex.RLock()
+	var docs []bson.M
+	for _, doc := range r.docs {
+		ok, err := match(doc, f)
+		if err != nil {
+			r.mutex.RUnlock()
+			return nil, err
+		}
+		if ok {
+			docs = append(docs, doc)
+		}
+	}
+	r.mutex.RUnlock()
+
+	sortDocuments(docs, sort)
+	return docs, nil
+}
+
+func (r *MemoryCrudRepository[DTO, CreateDTO, UpdateDTO]) createDocument(createDTO *CreateDTO) (bson.M, error) {
+	doc, err := marshalDocument(createDTO)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.Options.IDStrategy != nil {
+		if ids.IsZero(doc["_id"]) {
+			id, err := r.Options.IDStrategy.Generate()
+			if err != nil {
+				return nil, err
+			}
+			if id != nil {
+				doc["_id"] = id
+			}
+		} else {
+			// 和 mongo 仓储一样，调用方指定的 _id 在插入之前按 IDStrategy 转换
+			id, err := r.Options.IDStrategy.Parse(doc["_id"])
+			if err != nil {
+				return nil, err
+			}
+			doc["_id"] = id
+		}
+	}
+
+	// 和驱动一样，没有 _id 时生成 ObjectID
+	if _, ok := doc["_id"]; !ok {
+		doc["_id"] = primitive.NewObjectID()
+	}
+
+	normalized, err := normalize(doc)
+	if err != nil {
+		return nil, err
+	}
+	return normalized.(bson.M), nil
+}
+
+func (r *MemoryCrudRepository[DTO, CreateDTO, UpdateDTO]) parseID(id types.ID) (types.ID, error) {
+	if r.Options.IDStrategy == nil {
+		return id, nil
+	}
+	return r.Options.IDStrategy.Parse(id)
+}
+
+// indexOf 调用方需要持有锁，id 需要先经过 normalize
+func (r *MemoryCrudRepository[DTO, CreateDTO, UpdateDTO]) indexOf(id any) int {
+	for i, doc := range r.docs {
+		if typeOrder(doc["_id"]) == typeOrder(id) && compare(doc["_id"], id) == 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// sortDocuments 按 buildSorting 生成的 bson.D 稳定排序，值为 -1 表示降序
+func sortDocuments(docs []bson.M, spec any) {
+	fields, _ := spec.(bson.D)
+	if len(fields) == 0 {
+		return
+	}
+
+	sort.SliceStable(docs, func(i, j int) bool {
+		for _, field := range fields {
+			desc := field.Value == -1
+
+			c := compare(sortValue(docs[i], field.Key, desc), sortValue(docs[j], field.Key, desc))
+			if c == 0 {
+				continue
+			}
+			if desc {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+}
+
+// sortValue 和 MongoDB 一样，数组字段升序时按最小的元素、降序时按最大的元素排序
+func sortValue(doc bson.M, field string, desc bool) any {
+	v, _, _ := path(doc, field)
+	arr, ok := v.(primitive.A)
+	if !ok || len(arr) == 0 {
+		return v
+	}
+
+	r := arr[0]
+	for _, e := range arr[1:] {
+		c := compare(e, r)
+		if (desc && c > 0) || (!desc && c < 0) {
+			r = e
+		}
+	}
+	return r
+}
+
+func paginate(docs []bson.M, skip *int64, limit *int64) []bson.M {
+	if skip != nil && *skip > 0 {
+		if *skip >= int64(len(docs)) {
+			return nil
+		}
+		docs = docs[*skip:]
+	}
+	if limit != nil && *limit > 0 && *limit < int64(len(docs)) {
+		docs = docs[:*limit]
+	}
+	return docs
+}
+
+// project 执行 buildProjections 生成的投影，值为 1 时只保留列出的字段，为 0 时去掉列出的字段，_id 默认保留
+func project(doc bson.M, projection any) bson.M {
+	prj, ok := projection.(map[string]int)
+	if !ok || len(prj) == 0 {
+		return doc
+	}
+
+	include := false
+	for field, v := range prj {
+		if v == 1 && field != "_id" {
+			include = true
+		}
+	}
+
+	if !include {
+		r := copyDocument(doc)
+		for field, v := range prj {
+			if v == 0 {
+				removePath(r, strings.Split(field, "."))
+			}
+		}
+		return r
+	}
+
+	r := bson.M{}
+	if v, ok := prj["_id"]; !ok || v == 1 {
+		if id, ok := doc["_id"]; ok {
+			r["_id"] = id
+		}
+	}
+	for field, v := range prj {
+		if v == 1 {
+			copyPath(doc, r, strings.Split(field, "."))
+		}
+	}
+	return r
+}
+
+func copyPath(src bson.M, dst bson.M, path []string) {
+	v, ok := src[path[0]]
+	if !ok {
+		return
+	}
+	if len(path) == 1 {
+		dst[path[0]] = v
+		return
+	}
+
+	sub, ok := v.(bson.M)
+	if !ok {
+		return
+	}
+	next, ok := dst[path[0]].(bson.M)
+	if !ok {
+		next = bson.M{}
+		dst[path[0]] = next
+	}
+	copyPath(sub, next, path[1:])
+}
+
+func removePath(doc bson.M, path []string) {
+	if len(path) == 1 {
+		delete(doc, path[0])
+		return
+	}
+	if sub, ok := doc[path[0]].(bson.M); ok {
+		removePath(sub, path[1:])
+	}
+}
+
+func copyDocument(doc bson.M) bson.M {
+	r := bson.M{}
+	for k, v := range doc {
+		if sub, ok := v.(bson.M); ok {
+			v = copyDocument(sub)
+		}
+		r[k] = v
+	}
+	return r
+}
+
+func duplicateKeyError(id any) error {
+	return mongo.WriteException{
+		WriteErrors: []mongo.WriteError{{
+			Code:    11000,
+			Message: fmt.Sprintf("E11000 duplicate key error dup key: { _id: %v }", id),
+		}},
+	}
+}
+
+func marshalDocument(v any) (bson.M, error) {
+	data, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	m := bson.M{}
+	if err := bson.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// decode 每次都解码出新的对象，调用方修改返回值不会影响保存的文档
+func decode[DTO any](doc bson.M) (*DTO, error) {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	dto := new(DTO)
+	if err := bson.Unmarshal(data, dto); err != nil {
+		return nil, err
+	}
+	return dto, nil
+}
+
+func decodeAll[DTO any](docs []bson.M, projection any) ([]*DTO, error) {
+	dtos := make([]*DTO, len(docs))
+	for i, doc := range docs {
+		dto, err := decode[DTO](project(doc, projection))
+		if err != nil {
+			return nil, err
+		}
+		dtos[i] = dto
+	}
+	return dtos, nil
+}
@@ -0,0 +1,193 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	core_repositories "github.com/duolacloud/crud-core/repositories"
+	"github.com/duolacloud/crud-core/types"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type memoryUser struct {
+	ID       string    `json:"id" bson:"_id"`
+	Name     string    `json:"name" bson:"name"`
+	Country  string    `json:"country" bson:"country"`
+	Age      int       `json:"age" bson:"age"`
+	Birthday time.Time `json:"birthday" bson:"birthday"`
+	created  bool
+}
+
+func (u *memoryUser) BeforeCreate() {
+	u.created = true
+}
+
+var memoryUserSchema = bson.M{
+	"$jsonSchema": bson.M{
+		"bsonType": "object",
+		"properties": bson.M{
+			"_id":      bson.M{"bsonType": "string"},
+			"name":     bson.M{"bsonType": "string"},
+			"country":  bson.M{"bsonType": "string"},
+			"age":      bson.M{"bsonType": "int"},
+			"birthday": bson.M{"bsonType": "date"},
+		},
+	},
+}
+
+func newMemoryUsers(t *testing.T) *MemoryCrudRepository[memoryUser, memoryUser, memoryUser] {
+	r := NewMemoryCrudRepository[memoryUser, memoryUser, memoryUser](memoryUserSchema)
+
+	birthday := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	users := []*memoryUser{
+		{ID: "1", Name: "tom", Country: "cn", Age: 18, Birthday: birthday},
+		{ID: "2", Name: "jerry", Country: "us", Age: 30, Birthday: birthday.AddDate(-10, 0, 0)},
+		{ID: "3", Name: "lily", Country: "cn", Age: 25, Birthday: birthday.AddDate(5, 0, 0)},
+		{ID: "4", Name: "bob", Country: "uk", Age: 30, Birthday: birthday.AddDate(1, 0, 0)},
+	}
+	_, err := r.CreateMany(context.Background(), users)
+	assert.NoError(t, err)
+	for _, u := range users {
+		assert.True(t, u.created)
+	}
+	return r
+}
+
+func names(users []*memoryUser) []string {
+	r := make([]string, len(users))
+	for i, u := range users {
+		r[i] = u.Name
+	}
+	return r
+}
+
+func TestMemoryCrud(t *testing.T) {
+	c := context.Background()
+	r := newMemoryUsers(t)
+	var _ core_repositories.CrudRepository[memoryUser, memoryUser, memoryUser] = r
+
+	u, err := r.Get(c, "1")
+	assert.NoError(t, err)
+	assert.Equal(t, "tom", u.Name)
+
+	_, err = r.Create(c, &memoryUser{ID: "1"})
+	assert.True(t, mongo.IsDuplicateKeyError(err))
+
+	u, err = r.Update(c, "1", &memoryUser{Name: "tommy", Country: "cn", Age: 19})
+	assert.NoError(t, err)
+	assert.Equal(t, "tommy", u.Name)
+	assert.Equal(t, "1", u.ID)
+
+	_, err = r.Update(c, "9", &memoryUser{Name: "x"})
+	assert.ErrorIs(t, err, types.ErrNotFound)
+
+	u, err = r.Update(c, "9", &memoryUser{Name: "x"}, types.WithUpsert(true))
+	assert.NoError(t, err)
+	assert.Equal(t, "9", u.ID)
+
+	assert.NoError(t, r.Delete(c, "9"))
+	_, err = r.Get(c, "9")
+	assert.ErrorIs(t, err, types.ErrNotFound)
+
+	u, err = r.QueryOne(c, map[string]any{"country": map[string]any{"eq": "uk"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "bob", u.Name)
+
+	_, err = r.QueryOne(c, map[string]any{"country": map[string]any{"eq": "fr"}})
+	assert.ErrorIs(t, err, types.ErrNotFound)
+}
+
+func TestMemoryQuery(t *testing.T) {
+	c := context.Background()
+	r := newMemoryUsers(t)
+
+	users, err := r.Query(c, &types.PageQuery{
+		Filter: map[string]any{
+			"or": []any{
+				map[string]any{"country": map[string]any{"in": []string{"us", "uk"}}},
+				map[string]any{"age": map[string]any{"between": map[string]any{"lower": 20, "upper": 25}}},
+			},
+		},
+		Sort: []string{"-age", "name"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bob", "jerry", "lily"}, names(users))
+
+	users, err = r.Query(c, &types.PageQuery{
+		Filter: map[string]any{"birthday": map[string]any{"gte": "2000-01-01T00:00:00Z"}},
+		Sort:   []string{"birthday"},
+		Page:   map[string]int{"limit": 2, "offset": 1},
+		Fields: []string{"name"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []*memoryUser{{ID: "4", Name: "bob"}, {ID: "3", Name: "lily"}}, users)
+
+	count, err := r.Count(c, &types.PageQuery{
+		Filter: map[string]any{"age": map[string]any{"gte": 25}},
+		Page:   map[string]int{"limit": 1},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+}
+
+func TestMemoryCursorQuery(t *testing.T) {
+	c := context.Background()
+	r := newMemoryUsers(t)
+
+	q := &types.CursorQuery{Limit: 3}
+	users, extra, err := r.CursorQuery(c, q)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"tom", "jerry", "lily"}, names(users))
+	assert.True(t, extra.HasNext)
+
+	users, extra, err = r.CursorQuery(c, &types.CursorQuery{Limit: 3, Cursor: extra.EndCursor, Direction: types.CursorDirectionAfter})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bob"}, names(users))
+	assert.False(t, extra.HasNext)
+
+	users, _, err = r.CursorQuery(c, &types.CursorQuery{Limit: 3, Cursor: extra.StartCursor, Direction: types.CursorDirectionBefore})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"tom", "jerry", "lily"}, names(users))
+
+	// 多字段排序，id 作为最后的排序字段
+	users, extra, err = r.CursorQuery(c, &types.CursorQuery{Limit: 2, Sort: []string{"-age", "name"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bob", "jerry"}, names(users))
+
+	users, extra, err = r.CursorQuery(c, &types.CursorQuery{Limit: 2, Sort: []string{"-age", "name"}, Cursor: extra.EndCursor})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"lily", "tom"}, names(users))
+
+	users, _, err = r.CursorQuery(c, &types.CursorQuery{Limit: 2, Sort: []string{"-age", "name"}, Cursor: extra.StartCursor, Direction: types.CursorDirectionBefore})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bob", "jerry"}, names(users))
+}
+
+func TestMemoryAggregate(t *testing.T) {
+	c := context.Background()
+	r := newMemoryUsers(t)
+
+	aggs, err := r.Aggregate(c, nil, &types.AggregateQuery{
+		GroupBy: []string{"country"},
+		Sum:     []string{"age"},
+		Max:     []string{"age"},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, aggs, 3)
+	assert.Equal(t, "cn", aggs[0].GroupBy["country"])
+	assert.Equal(t, int32(43), aggs[0].Sum["age"])
+	assert.Equal(t, int32(25), aggs[0].Max["age"])
+	assert.Equal(t, "us", aggs[2].GroupBy["country"])
+
+	aggs, err = r.Aggregate(c, map[string]any{"country": map[string]any{"eq": "cn"}}, &types.AggregateQuery{
+		Count: []string{"age"},
+		Avg:   []string{"age"},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, aggs, 1)
+	assert.Equal(t, int32(2), aggs[0].Count["age"])
+	assert.Equal(t, 21.5, aggs[0].Avg["age"])
+}
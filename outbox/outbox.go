@@ -0,0 +1,309 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type Operation string
+
+const (
+	OperationCreate Operation = "create"
+	OperationUpdate Operation = "update"
+	OperationDelete Operation = "delete"
+)
+
+// EventFactory 根据仓储的写操作生成需要写入 outbox 的事件，entity 是写入后的 *DTO，delete 时为 nil
+type EventFactory func(c context.Context, op Operation, id any, entity any) ([]*Event, error)
+
+type Event struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty"`
+	AggregateType string             `bson:"aggregate_type"`
+	AggregateID   string             `bson:"aggregate_id"`
+	Type          string             `bson:"type"`
+	Payload       bson.Raw           `bson:"payload"`
+	Headers       map[string]string  `bson:"headers,omitempty"`
+	// CreatedAt 由数据库生成，同一个聚合内单调递增，不受各应用服务器时钟的影响
+	CreatedAt time.Time `bson:"created_at"`
+	// Sequence 是事件在聚合内的序号，CreatedAt 相同时按它排序
+	Sequence    int64      `bson:"sequence"`
+	PublishedAt *time.Time `bson:"published_at,omitempty"`
+	Attempts    int        `bson:"attempts"`
+	LastError   string     `bson:"last_error,omitempty"`
+	// NextAttemptAt 发送失败后下一次重试的时间，之前不会再被 Pending 返回
+	NextAttemptAt *time.Time `bson:"next_attempt_at,omitempty"`
+	// DeadAt 重试次数用完后被搁置的时间，搁置的事件不再发送，可以通过 Retry 重新发送
+	DeadAt *time.Time `bson:"dead_at,omitempty"`
+}
+
+// NewEvent 创建事件，payload 必须能被编码为 bson 文档
+func NewEvent(aggregateType string, aggregateID string, eventType string, payload any) (*Event, error) {
+	data, err := bson.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Event{
+		AggregateType: aggregateType,
+		AggregateID:   aggregateID,
+		Type:          eventType,
+		Payload:       data,
+	}, nil
+}
+
+// Aggregate 标识事件所属的聚合
+type Aggregate struct {
+	Type string
+	ID   string
+}
+
+func (e *Event) Aggregate() Aggregate {
+	return Aggregate{Type: e.AggregateType, ID: e.AggregateID}
+}
+
+type Outbox struct {
+	collection *mongo.Collection
+	// sequences 保存每个聚合最后一个事件的序号和时间，文档为 {_id: aggregate_type/aggregate_id, value, at}
+	sequences *mongo.Collection
+}
+
+// NewOutbox 创建 outbox，计数器保存在同一个数据库的 <collection>_sequences 集合中，
+// 需要 MongoDB 4.2 以上；在事务中写入且 MongoDB 低于 4.4 时需要预先创建这个集合
+func NewOutbox(collection *mongo.Collection) *Outbox {
+	return &Outbox{
+		collection: collection,
+		sequences:  collection.Database().Collection(collection.Name() + "_sequences"),
+	}
+}
+
+func (o *Outbox) Collection() *mongo.Collection {
+	return o.collection
+}
+
+// EnsureIndexes 创建 relay 查询待发送事件需要的索引
+func (o *Outbox) EnsureIndexes(c context.Context) error {
+	_, err := o.collection.Indexes().CreateMany(c, []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "published_at", Value: 1},
+				{Key: "dead_at", Value: 1},
+				{Key: "created_at", Value: 1},
+				{Key: "sequence", Value: 1},
+				{Key: "_id", Value: 1},
+			},
+		},
+		{
+			Keys: bson.D{
+				{Key: "published_at", Value: 1},
+				{Key: "dead_at", Value: 1},
+				{Key: "next_attempt_at", Value: 1},
+			},
+		},
+	})
+	return err
+}
+
+// Append 写入事件，c 是事务的 mongo.SessionContext 时和业务数据在同一个事务内提交。
+// 事件的时间和序号由聚合的计数器文档在数据库中生成，同一个聚合并发写入时会产生写冲突，
+// 事务重试后得到更大的序号，所以同一个聚合的事件按提交顺序排列
+func (o *Outbox) Append(c context.Context, events ...*Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	docs := make([]any, len(events))
+	for i, event := range events {
+		if event.ID.IsZero() {
+			event.ID = primitive.NewObjectID()
+		}
+		if err := o.stamp(c, event); err != nil {
+			return err
+		}
+		docs[i] = event
+	}
+
+	_, err := o.collection.InsertMany(c, docs)
+	return err
+}
+
+// stamp 递增聚合的计数器，取计数器的值作为序号，取数据库时间和上一个事件时间中较大的作为 CreatedAt
+func (o *Outbox) stamp(c context.Context, event *Event) error {
+	opts := options.FindOneAndUpdate().
+		SetUpsert(true).
+		SetReturnDocument(options.After)
+
+	var counter struct {
+		Value int64     `bson:"value"`
+		At    time.Time `bson:"at"`
+	}
+	err := o.sequences.FindOneAndUpdate(
+		c,
+		bson.M{"_id": event.AggregateType + "/" + event.AggregateID},
+		mongo.Pipeline{
+			{{Key: "$set", Value: bson.M{
+				"value": bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$value", 0}}, 1}},
+				"at":    bson.M{"$max": bson.A{"$at", "$$NOW"}},
+			}}},
+		},
+		opts,
+	).Decode(&counter)
+	if err != nil {
+		return err
+	}
+
+	event.Sequence = counter.Value
+	event.CreatedAt = counter.At
+	return nil
+}
+
+// Pending 按写入顺序返回到了发送时间的事件，不包括已搁置的事件和等待重试的聚合中的事件，
+// 一个聚合在退避时不会占满批次挡住其他聚合
+func (o *Outbox) Pending(c context.Context, limit int64) ([]*Event, error) {
+	now := time.Now()
+	waiting, err := o.Waiting(c)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "sequence", Value: 1}, {Key: "_id", Value: 1}}).
+		SetLimit(limit)
+
+	cursor, err := o.collection.Find(c, pendingFilter(now, waiting), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []*Event
+	err = cursor.All(c, &events)
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func pendingFilter(now time.Time, waiting map[Aggregate]bool) bson.M {
+	filter := bson.M{
+		"published_at": nil,
+		"dead_at":      nil,
+		"$or": []bson.M{
+			{"next_attempt_at": nil},
+			{"next_attempt_at": bson.M{"$lte": now}},
+		},
+	}
+	if len(waiting) > 0 {
+		nor := make([]bson.M, 0, len(waiting))
+		for a := range waiting {
+			nor = append(nor, bson.M{"aggregate_type": a.Type, "aggregate_id": a.ID})
+		}
+		filter["$nor"] = nor
+	}
+	return filter
+}
+
+// Waiting 返回有事件在等待重试的聚合，
+// 这些聚合后面的事件要等前面的事件发送成功或被搁置后才能发送
+func (o *Outbox) Waiting(c context.Context) (map[Aggregate]bool, error) {
+	cursor, err := o.collection.Aggregate(c, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"published_at":    nil,
+			"dead_at":         nil,
+			"next_attempt_at": bson.M{"$gt": time.Now()},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{"type": "$aggregate_type", "id": "$aggregate_id"},
+		}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []struct {
+		ID struct {
+			Type string `bson:"type"`
+			ID   string `bson:"id"`
+		} `bson:"_id"`
+	}
+	if err := cursor.All(c, &rows); err != nil {
+		return nil, err
+	}
+
+	waiting := make(map[Aggregate]bool, len(rows))
+	for _, row := range rows {
+		waiting[Aggregate{Type: row.ID.Type, ID: row.ID.ID}] = true
+	}
+	return waiting, nil
+}
+
+// Dead 按写入顺序返回已搁置的事件
+func (o *Outbox) Dead(c context.Context, limit int64) ([]*Event, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "sequence", Value: 1}, {Key: "_id", Value: 1}}).
+		SetLimit(limit)
+
+	cursor, err := o.collection.Find(c, bson.M{"published_at": nil, "dead_at": bson.M{"$ne": nil}}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []*Event
+	err = cursor.All(c, &events)
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func (o *Outbox) MarkPublished(c context.Context, id primitive.ObjectID) error {
+	_, err := o.collection.UpdateByID(c, id, bson.M{
+		"$set":   bson.M{"published_at": time.Now()},
+		"$inc":   bson.M{"attempts": 1},
+		"$unset": bson.M{"last_error": "", "next_attempt_at": ""},
+	})
+	return err
+}
+
+// MarkFailed 记录发送失败，retryAt 之前不再发送
+func (o *Outbox) MarkFailed(c context.Context, id primitive.ObjectID, cause error, retryAt time.Time) error {
+	_, err := o.collection.UpdateByID(c, id, bson.M{
+		"$set": bson.M{"last_error": cause.Error(), "next_attempt_at": retryAt},
+		"$inc": bson.M{"attempts": 1},
+	})
+	return err
+}
+
+// MarkDead 记录发送失败并搁置事件，同一个聚合后面的事件可以继续发送
+func (o *Outbox) MarkDead(c context.Context, id primitive.ObjectID, cause error) error {
+	_, err := o.collection.UpdateByID(c, id, bson.M{
+		"$set":   bson.M{"last_error": cause.Error(), "dead_at": time.Now()},
+		"$inc":   bson.M{"attempts": 1},
+		"$unset": bson.M{"next_attempt_at": ""},
+	})
+	return err
+}
+
+// Retry 把搁置的事件重新放回待发送队列，重试次数清零，
+// 同一个聚合后面的事件可能已经发送过了
+func (o *Outbox) Retry(c context.Context, id primitive.ObjectID) error {
+	_, err := o.collection.UpdateOne(c, bson.M{"_id": id, "published_at": nil}, bson.M{
+		"$set":   bson.M{"attempts": 0},
+		"$unset": bson.M{"dead_at": "", "next_attempt_at": ""},
+	})
+	return err
+}
+
+// Cleanup 删除发送时间早于 retention 的事件
+func (o *Outbox) Cleanup(c context.Context, retention time.Duration) (int64, error) {
+	res, err := o.collection.DeleteMany(c, bson.M{
+		"published_at": bson.M{"$lt": time.Now().Add(-retention)},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return res.DeletedCount, nil
+}
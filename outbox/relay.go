@@ -0,0 +1,244 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Publisher 把事件投递到消息中间件，返回 nil 才认为发送成功
+type Publisher interface {
+	Publish(c context.Context, event *Event) error
+}
+
+type PublisherFunc func(c context.Context, event *Event) error
+
+func (f PublisherFunc) Publish(c context.Context, event *Event) error {
+	return f(c, event)
+}
+
+type RelayOptions struct {
+	PollInterval time.Duration
+	BatchSize    int64
+	// Retention 大于 0 时定期删除已发送超过该时长的事件
+	Retention       time.Duration
+	CleanupInterval time.Duration
+	// Watch 为 true 时监听 outbox 集合的插入，有新事件立即发送，轮询作为兜底
+	Watch bool
+	// 发送失败后按指数退避重试，第 n 次失败后等待 min(MinBackoff*2^(n-1), MaxBackoff)
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// MaxAttempts 大于 0 时，失败次数达到 MaxAttempts 的事件被搁置，不再阻塞同一个聚合后面的事件
+	MaxAttempts int
+}
+
+type RelayOption func(*RelayOptions)
+
+func WithPollInterval(d time.Duration) RelayOption {
+	return func(o *RelayOptions) {
+		o.PollInterval = d
+	}
+}
+
+func WithBatchSize(v int64) RelayOption {
+	return func(o *RelayOptions) {
+		o.BatchSize = v
+	}
+}
+
+func WithRetention(retention time.Duration, interval time.Duration) RelayOption {
+	return func(o *RelayOptions) {
+		o.Retention = retention
+		o.CleanupInterval = interval
+	}
+}
+
+func WithWatch(v bool) RelayOption {
+	return func(o *RelayOptions) {
+		o.Watch = v
+	}
+}
+
+func WithBackoff(min time.Duration, max time.Duration) RelayOption {
+	return func(o *RelayOptions) {
+		o.MinBackoff = min
+		o.MaxBackoff = max
+	}
+}
+
+func WithMaxAttempts(v int) RelayOption {
+	return func(o *RelayOptions) {
+		o.MaxAttempts = v
+	}
+}
+
+// Relay 把 outbox 中的事件至少投递一次，同一个聚合的事件按写入顺序投递，
+// 前一个事件发送失败时，后面的事件会等它重试成功或被搁置后再发送。
+// 同一个 outbox 同时只应运行一个 Relay，否则无法保证顺序。
+type Relay struct {
+	outbox    *Outbox
+	store     store
+	publisher Publisher
+	options   *RelayOptions
+}
+
+// store 是 Relay 用到的 Outbox 操作
+type store interface {
+	Pending(c context.Context, limit int64) ([]*Event, error)
+	MarkPublished(c context.Context, id primitive.ObjectID) error
+	MarkFailed(c context.Context, id primitive.ObjectID, cause error, retryAt time.Time) error
+	MarkDead(c context.Context, id primitive.ObjectID, cause error) error
+	Cleanup(c context.Context, retention time.Duration) (int64, error)
+}
+
+func NewRelay(outbox *Outbox, publisher Publisher, opts ...RelayOption) *Relay {
+	r := &Relay{
+		outbox:    outbox,
+		store:     outbox,
+		publisher: publisher,
+		options: &RelayOptions{
+			PollInterval:    time.Second,
+			BatchSize:       100,
+			CleanupInterval: time.Hour,
+			MinBackoff:      time.Second,
+			MaxBackoff:      10 * time.Minute,
+			MaxAttempts:     20,
+		},
+	}
+	for _, o := range opts {
+		o(r.options)
+	}
+	return r
+}
+
+// Run 持续发送事件，直到 c 被取消
+func (r *Relay) Run(c context.Context) error {
+	notify := make(chan struct{}, 1)
+	if r.options.Watch {
+		go r.watch(c, notify)
+	}
+
+	ticker := time.NewTicker(r.options.PollInterval)
+	defer ticker.Stop()
+
+	var lastCleanup time.Time
+
+	for {
+		for {
+			n, handled, err := r.poll(c)
+			if err != nil && c.Err() != nil {
+				return c.Err()
+			}
+			// 一批满了说明可能还有，继续发送；Pending 已经排除了等待重试的聚合，
+			// 每批至少能处理第一个事件，handled == 0 只是兜底
+			if err != nil || int64(n) < r.options.BatchSize || handled == 0 {
+				break
+			}
+		}
+
+		if r.options.Retention > 0 && time.Since(lastCleanup) >= r.options.CleanupInterval {
+			if _, err := r.store.Cleanup(c, r.options.Retention); err == nil {
+				lastCleanup = time.Now()
+			}
+		}
+
+		select {
+		case <-c.Done():
+			return c.Err()
+		case <-ticker.C:
+		case <-notify:
+		}
+	}
+}
+
+// Poll 发送一批待发送的事件，返回本批读取的事件数量
+func (r *Relay) Poll(c context.Context) (int, error) {
+	n, _, err := r.poll(c)
+	return n, err
+}
+
+// poll 返回本批读取的事件数量和发送成功或标记失败的数量
+func (r *Relay) poll(c context.Context) (int, int, error) {
+	events, err := r.store.Pending(c, r.options.BatchSize)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// 本批中发送失败的聚合，后面的事件不能先发送
+	blocked := map[Aggregate]bool{}
+
+	handled := 0
+	for _, event := range events {
+		key := event.Aggregate()
+		if blocked[key] {
+			continue
+		}
+
+		if err := r.publisher.Publish(c, event); err != nil {
+			attempts := event.Attempts + 1
+			if r.options.MaxAttempts > 0 && attempts >= r.options.MaxAttempts {
+				err = r.store.MarkDead(c, event.ID, err)
+			} else {
+				blocked[key] = true
+				err = r.store.MarkFailed(c, event.ID, err, time.Now().Add(r.backoff(attempts)))
+			}
+			if err != nil {
+				return len(events), handled, err
+			}
+			handled++
+			continue
+		}
+
+		if err := r.store.MarkPublished(c, event.ID); err != nil {
+			return len(events), handled, err
+		}
+		handled++
+	}
+
+	return len(events), handled, nil
+}
+
+// backoff 返回第 attempts 次失败后的等待时间，MaxBackoff 为 0 时固定等待 MinBackoff
+func (r *Relay) backoff(attempts int) time.Duration {
+	d, max := r.options.MinBackoff, r.options.MaxBackoff
+	if max <= 0 {
+		max = d
+	}
+	for i := 1; i < attempts && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		return max
+	}
+	return d
+}
+
+func (r *Relay) watch(c context.Context, notify chan<- struct{}) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"operationType": "insert"}}},
+	}
+
+	for c.Err() == nil {
+		stream, err := r.outbox.collection.Watch(c, pipeline)
+		if err != nil {
+			// 不支持 change stream 时退化为轮询
+			return
+		}
+
+		for stream.Next(c) {
+			select {
+			case notify <- struct{}{}:
+			default:
+			}
+		}
+		_ = stream.Close(c)
+
+		select {
+		case <-c.Done():
+		case <-time.After(r.options.PollInterval):
+		}
+	}
+}
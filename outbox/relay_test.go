@@ -0,0 +1,214 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestRelayBackoff(t *testing.T) {
+	r := NewRelay(nil, nil, WithBackoff(time.Second, 5*time.Second))
+
+	assert.Equal(t, time.Second, r.backoff(1))
+	assert.Equal(t, 2*time.Second, r.backoff(2))
+	assert.Equal(t, 4*time.Second, r.backoff(3))
+	assert.Equal(t, 5*time.Second, r.backoff(4))
+	assert.Equal(t, 5*time.Second, r.backoff(100))
+
+	r = NewRelay(nil, nil, WithBackoff(time.Second, 0))
+	assert.Equal(t, time.Second, r.backoff(10))
+}
+
+func TestPendingFilter(t *testing.T) {
+	now := time.Now()
+	filter := pendingFilter(now, nil)
+	assert.NotContains(t, filter, "$nor")
+
+	filter = pendingFilter(now, map[Aggregate]bool{{Type: "order", ID: "1"}: true})
+	assert.Equal(t, []bson.M{{"aggregate_type": "order", "aggregate_id": "1"}}, filter["$nor"])
+}
+
+// memoryStore 按 Outbox 的语义在内存中保存事件
+type memoryStore struct {
+	mu     sync.Mutex
+	events []*Event
+}
+
+func (s *memoryStore) append(aggregateID string, eventType string) *Event {
+	event := &Event{
+		ID:            primitive.NewObjectID(),
+		AggregateType: "order",
+		AggregateID:   aggregateID,
+		Type:          eventType,
+		CreatedAt:     time.Now(),
+		Sequence:      int64(len(s.events) + 1),
+	}
+	s.events = append(s.events, event)
+	return event
+}
+
+func (s *memoryStore) Pending(c context.Context, limit int64) ([]*Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	waiting := map[Aggregate]bool{}
+	for _, e := range s.events {
+		if e.PublishedAt == nil && e.DeadAt == nil && e.NextAttemptAt != nil && e.NextAttemptAt.After(now) {
+			waiting[e.Aggregate()] = true
+		}
+	}
+
+	var pending []*Event
+	for _, e := range s.events {
+		if e.PublishedAt != nil || e.DeadAt != nil || waiting[e.Aggregate()] {
+			continue
+		}
+		copied := *e
+		pending = append(pending, &copied)
+	}
+	sort.SliceStable(pending, func(i, j int) bool {
+		return pending[i].CreatedAt.Before(pending[j].CreatedAt)
+	})
+	if int64(len(pending)) > limit {
+		pending = pending[:limit]
+	}
+	return pending, nil
+}
+
+func (s *memoryStore) update(id primitive.ObjectID, fn func(e *Event)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range s.events {
+		if e.ID == id {
+			fn(e)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) MarkPublished(c context.Context, id primitive.ObjectID) error {
+	return s.update(id, func(e *Event) {
+		now := time.Now()
+		e.PublishedAt = &now
+		e.Attempts++
+		e.NextAttemptAt = nil
+	})
+}
+
+func (s *memoryStore) MarkFailed(c context.Context, id primitive.ObjectID, cause error, retryAt time.Time) error {
+	return s.update(id, func(e *Event) {
+		e.LastError = cause.Error()
+		e.NextAttemptAt = &retryAt
+		e.Attempts++
+	})
+}
+
+func (s *memoryStore) MarkDead(c context.Context, id primitive.ObjectID, cause error) error {
+	return s.update(id, func(e *Event) {
+		now := time.Now()
+		e.LastError = cause.Error()
+		e.DeadAt = &now
+		e.NextAttemptAt = nil
+		e.Attempts++
+	})
+}
+
+func (s *memoryStore) Cleanup(c context.Context, retention time.Duration) (int64, error) {
+	return 0, nil
+}
+
+// recorder 记录发送的事件，failing 中的事件类型总是发送失败
+type recorder struct {
+	failing   map[string]int
+	published []string
+}
+
+func (p *recorder) Publish(c context.Context, event *Event) error {
+	if p.failing[event.Type] > 0 {
+		p.failing[event.Type]--
+		return errors.New("broker unavailable")
+	}
+	p.published = append(p.published, event.Type)
+	return nil
+}
+
+func newTestRelay(s *memoryStore, p Publisher, opts ...RelayOption) *Relay {
+	r := NewRelay(nil, p, opts...)
+	r.store = s
+	return r
+}
+
+func TestRelayAggregateOrder(t *testing.T) {
+	c := context.Background()
+	s := &memoryStore{}
+	s.append("1", "a1")
+	s.append("1", "a2")
+	s.append("2", "b1")
+
+	p := &recorder{failing: map[string]int{"a1": 1}}
+	r := newTestRelay(s, p, WithBackoff(time.Millisecond, time.Millisecond))
+
+	// a1 失败后 a2 不能先发送，其他聚合不受影响
+	_, err := r.Poll(c)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b1"}, p.published)
+
+	time.Sleep(5 * time.Millisecond)
+	_, err = r.Poll(c)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b1", "a1", "a2"}, p.published)
+}
+
+func TestRelayWaitingAggregateDoesNotBlockOthers(t *testing.T) {
+	c := context.Background()
+	s := &memoryStore{}
+	for _, eventType := range []string{"a1", "a2", "a3", "a4"} {
+		s.append("1", eventType)
+	}
+	s.append("2", "b1")
+
+	p := &recorder{failing: map[string]int{"a1": 100}}
+	r := newTestRelay(s, p, WithBatchSize(2), WithBackoff(time.Hour, time.Hour))
+
+	_, err := r.Poll(c)
+	assert.NoError(t, err)
+	assert.Empty(t, p.published)
+
+	// 聚合 1 在退避中，它排在前面的事件不能占满批次
+	n, err := r.Poll(c)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []string{"b1"}, p.published)
+}
+
+func TestRelayDeadLetter(t *testing.T) {
+	c := context.Background()
+	s := &memoryStore{}
+	dead := s.append("1", "a1")
+	s.append("1", "a2")
+
+	p := &recorder{failing: map[string]int{"a1": 100}}
+	r := newTestRelay(s, p, WithBackoff(time.Millisecond, time.Millisecond), WithMaxAttempts(2))
+
+	_, err := r.Poll(c)
+	assert.NoError(t, err)
+	assert.Empty(t, p.published)
+
+	// 重试次数用完后搁置，同一个聚合后面的事件继续发送
+	time.Sleep(5 * time.Millisecond)
+	_, err = r.Poll(c)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a2"}, p.published)
+	assert.NotNil(t, dead.DeadAt)
+	assert.Equal(t, 2, dead.Attempts)
+	assert.Equal(t, "broker unavailable", dead.LastError)
+}
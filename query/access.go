@@ -0,0 +1,128 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+var ErrFieldAccess = errors.New("field access denied")
+
+type FieldAccessError struct {
+	Field string
+	// Usage 是 filter、sort 或 projection
+	Usage string
+}
+
+func (e *FieldAccessError) Error() string {
+	return fmt.Sprintf("%s: field %s cannot be used in %s", ErrFieldAccess, e.Field, e.Usage)
+}
+
+func (e *FieldAccessError) Is(target error) bool {
+	return target == ErrFieldAccess
+}
+
+// Mask 替换返回结果中字段的值，返回值的类型要和字段的类型兼容
+type Mask func(v any) any
+
+// FieldAccess 是调用方可以使用的字段，字段名可以是 API 名或 bson 名，
+// 列出父字段时包括它的所有子字段
+type FieldAccess struct {
+	// Filterable/Sortable/Projectable 为 nil 时不限制
+	Filterable  []string
+	Sortable    []string
+	Projectable []string
+	// Hidden 的字段不能用于筛选、排序和投影，并且从返回结果中删除
+	Hidden []string
+	// Masks 的字段在返回结果中被替换
+	Masks map[string]Mask
+}
+
+// Redacts 判断是否需要处理返回结果
+func (a *FieldAccess) Redacts() bool {
+	return a != nil && (len(a.Hidden) > 0 || len(a.Masks) > 0)
+}
+
+func (a *FieldAccess) check(mapper *FieldMapper, field string, usage string, allowed []string) error {
+	path := mapper.BsonName(field)
+	if matchesField(mapper, a.Hidden, path) {
+		return &FieldAccessError{Field: field, Usage: usage}
+	}
+	if allowed != nil && !matchesField(mapper, allowed, path) {
+		return &FieldAccessError{Field: field, Usage: usage}
+	}
+	return nil
+}
+
+// matchesField 判断 path 是不是 fields 中的字段或者其子字段
+func matchesField(mapper *FieldMapper, fields []string, path string) bool {
+	for _, f := range fields {
+		f = mapper.BsonName(f)
+		if path == f || strings.HasPrefix(path, f+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// collectFilterFields 返回筛选条件中用到的字段
+func collectFilterFields(filter map[string]any) ([]string, error) {
+	var fields []string
+	for field, value := range filter {
+		if !isLogicalKey(field) {
+			fields = append(fields, field)
+			continue
+		}
+
+		var subFilters []map[string]any
+		var err error
+		if field == "not" {
+			var sub map[string]any
+			sub, err = toFilterMap(value)
+			subFilters = []map[string]any{sub}
+		} else {
+			subFilters, err = toFilterList(value)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, sub := range subFilters {
+			subFields, err := collectFilterFields(sub)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, subFields...)
+		}
+	}
+	return fields, nil
+}
+
+// MaskAll 把整个值替换为 replacement
+func MaskAll(replacement any) Mask {
+	return func(v any) any {
+		if v == nil {
+			return nil
+		}
+		return replacement
+	}
+}
+
+// MaskString 保留字符串前 keepPrefix 个和后 keepSuffix 个字符，其余替换为 *，如手机号 138****0000
+func MaskString(keepPrefix int, keepSuffix int) Mask {
+	return func(v any) any {
+		s, ok := v.(string)
+		if !ok {
+			return v
+		}
+
+		n := utf8.RuneCountInString(s)
+		if keepPrefix+keepSuffix >= n {
+			return strings.Repeat("*", n)
+		}
+
+		runes := []rune(s)
+		return string(runes[:keepPrefix]) + strings.Repeat("*", n-keepPrefix-keepSuffix) + string(runes[n-keepSuffix:])
+	}
+}
@@ -0,0 +1,49 @@
+package query
+
+import (
+	"testing"
+
+	mongo_schema "github.com/duolacloud/crud-core-mongo/schema"
+	"github.com/duolacloud/crud-core/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFieldAccess(t *testing.T) {
+	access := &FieldAccess{
+		Filterable:  []string{"name", "address"},
+		Sortable:    []string{"createdAt"},
+		Projectable: []string{"name", "createdAt"},
+		Hidden:      []string{"address.zipCode"},
+	}
+	b := NewFilterQueryBuilder[mapperUser](mongo_schema.NewSchema(nil), false, WithFieldAccess(access))
+
+	_, err := b.BuildQuery(&types.PageQuery{
+		Filter: map[string]any{"or": []any{map[string]any{"name": map[string]any{"eq": "a"}}}},
+		Sort:   []string{"-created_at"},
+		Fields: []string{"name", "-Plain"},
+	})
+	assert.NoError(t, err)
+
+	_, err = b.BuildQuery(&types.PageQuery{
+		Filter: map[string]any{"not": map[string]any{"Plain": map[string]any{"eq": "a"}}},
+	})
+	assert.ErrorIs(t, err, ErrFieldAccess)
+
+	_, err = b.BuildQuery(&types.PageQuery{
+		Filter: map[string]any{"address.zipCode": map[string]any{"eq": "a"}},
+	})
+	assert.ErrorIs(t, err, ErrFieldAccess)
+
+	_, err = b.BuildQuery(&types.PageQuery{Sort: []string{"name"}})
+	assert.ErrorIs(t, err, ErrFieldAccess)
+
+	_, err = b.BuildAggregateQuery(&types.AggregateQuery{Sum: []string{"Plain"}}, nil)
+	assert.ErrorIs(t, err, ErrFieldAccess)
+}
+
+func TestMasks(t *testing.T) {
+	assert.Equal(t, "138****0000", MaskString(3, 4)("13800000000"))
+	assert.Equal(t, "**", MaskString(3, 4)("张三"))
+	assert.Equal(t, "***", MaskAll("***")("secret"))
+	assert.Nil(t, MaskAll("***")(nil))
+}
@@ -22,10 +22,13 @@ const (
 var AGG_REGEXP = regexp.MustCompile("(avg|sum|count|max|min|group_by)_(.*)")
 
 type AggregateBuilder struct {
+	options *FilterQueryBuilderOptions
 }
 
-func NewAggregateBuilder() *AggregateBuilder {
-	return &AggregateBuilder{}
+func NewAggregateBuilder(opts ...FilterQueryBuilderOption) *AggregateBuilder {
+	return &AggregateBuilder{
+		options: newFilterQueryBuilderOptions(opts),
+	}
 }
 
 func (b *AggregateBuilder) build(aggregate *types.AggregateQuery) (bson.M, error) {
@@ -74,7 +77,7 @@ func (b *AggregateBuilder) createAggSelect(fn AggregateFunc, fields []string) bs
 
 	for _, field := range fields {
 		aggAlias := fmt.Sprintf("%s_%s", fn, field)
-		fieldAlias := fmt.Sprintf("$%s", getSchemaKey(field))
+		fieldAlias := fmt.Sprintf("$%s", b.options.FieldMapper.BsonName(field))
 		if fn == "count" {
 			agg[aggAlias] = bson.M{
 				"$sum": bson.M{
@@ -92,8 +95,7 @@ func (b *AggregateBuilder) createAggSelect(fn AggregateFunc, fields []string) bs
 					},
 				},
 			}
-
-			return agg
+			continue
 		}
 
 		agg[aggAlias] = bson.M{fmt.Sprintf("$%s", fn): fieldAlias}
@@ -111,7 +113,7 @@ func (b *AggregateBuilder) createGroupBySelect(fields []string) bson.M {
 
 	for _, field := range fields {
 		aggAlias := b.getGroupByAlias(field)
-		fieldAlias := fmt.Sprintf("$%s", getSchemaKey(field))
+		fieldAlias := fmt.Sprintf("$%s", b.options.FieldMapper.BsonName(field))
 		m[aggAlias] = fieldAlias
 	}
 
@@ -140,14 +142,19 @@ func ConvertToAggregateResponse(aggregates []bson.M) ([]*types.AggregateResponse
 	for i, aggregate := range aggregates {
 		ar := &types.AggregateResponse{}
 
-		agg, err := extractResponse(aggregate["_id"].(bson.M))
+		// 没有 group by 时 _id 为 null
+		groupBy, _ := aggregate["_id"].(bson.M)
+		agg, err := extractResponse(groupBy)
+		if err != nil {
+			return nil, err
+		}
 		ar.Merge(agg)
 
 		agg, err = extractResponse(aggregate)
-		ar.Merge(agg)
 		if err != nil {
 			return nil, err
 		}
+		ar.Merge(agg)
 
 		r[i] = ar
 	}
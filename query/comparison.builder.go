@@ -1,11 +1,10 @@
 package query
 
 import(
-	"time"
+	"reflect"
 	"strings"
 	"errors"
 	"fmt"
-	"strconv"
 	"github.com/duolacloud/crud-core/types"
 	mongo_schema "github.com/duolacloud/crud-core-mongo/schema"
 	"github.com/duolacloud/crud-core-mongo/utils"
@@ -30,11 +29,13 @@ var DEFAULT_COMPARISON_MAP = map[string]string{
 type ComparisonBuilder [Entity any] struct {
 	comparisonMap map[string]string
 	schema *mongo_schema.Schema
+	options *FilterQueryBuilderOptions
 }
 
 func NewComparisonBuilder[Entity any](
 	comparisonMap map[string]string,
 	schema *mongo_schema.Schema,
+	opts ...FilterQueryBuilderOption,
 ) *ComparisonBuilder[Entity] {
 	var _comparisonMap map[string]string
 	if comparisonMap != nil {
@@ -43,9 +44,15 @@ func NewComparisonBuilder[Entity any](
 		_comparisonMap = DEFAULT_COMPARISON_MAP
 	}
 
+	options := newFilterQueryBuilderOptions(opts)
+	if options.FieldMapper == nil {
+		options.FieldMapper = NewFieldMapper[Entity]()
+	}
+
 	return &ComparisonBuilder[Entity]{
 		comparisonMap: _comparisonMap,
 		schema: schema,
+		options: options,
 	}
 }
 
@@ -55,22 +62,34 @@ func (b *ComparisonBuilder[Entity]) build(
 	cmp types.FilterComparisonOperators,
 	val any,
 ) (bson.M, error) {
-	schemaKey := field // TODO 根据字段名 获取 数据库的字段名
+	schemaKey := b.options.FieldMapper.BsonName(field)
 
 	normalizedCmp := strings.ToLower(string(cmp));
 	var querySelector bson.M
 
+	if b.options.StrictValidation && !isIDField(schemaKey) {
+		if _, ok := b.schema.FieldTypes[schemaKey]; !ok {
+			return nil, &ValidationError{Field: field, Operator: normalizedCmp, Value: val, Reason: "field does not exist in collection"}
+		}
+	}
+
+	if b.options.Encrypter != nil {
+		if encrypted, deterministic := b.options.Encrypter.Encrypted(schemaKey); encrypted {
+			return b.encryptedComparison(field, schemaKey, normalizedCmp, val, deterministic)
+		}
+	}
+
 	// TODO 根据 cmp 判断 val 类型
 	switch normalizedCmp {
-	case "in":
+	case "in", "notin":
 		if !utils.IsArray(val) {
-			return nil, errors.New("Invalid value, value for in must be array")
+			return nil, fmt.Errorf("invalid value for %s.%s, expected an array, got %v", field, normalizedCmp, val)
 		}
 	}
 
 	if cmp, ok := b.comparisonMap[normalizedCmp]; ok {
 		// comparison operator (e.b. =, !=, >, <)
-		_cmp, err := b.convertQueryValue(field, val)
+		_cmp, err := b.convertQueryValue(schemaKey, normalizedCmp, val)
 		if err != nil {
 			return nil, err
 		}
@@ -80,6 +99,14 @@ func (b *ComparisonBuilder[Entity]) build(
 		}
 	}
 
+	if isDateOperator(normalizedCmp) {
+		var err error
+		querySelector, err = b.dateComparison(normalizedCmp, field, val)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	/* TODO
 	if (strings.Contains(normalizedCmp, "like")) {
 		querySelector = b.likeComparison(normalizedCmp, val);
@@ -87,7 +114,7 @@ func (b *ComparisonBuilder[Entity]) build(
 
 	if (strings.Contains(normalizedCmp, "between")) {
 		var err error
-		querySelector, err = b.betweenComparison(normalizedCmp, field, val)
+		querySelector, err = b.betweenComparison(normalizedCmp, schemaKey, val)
 		if err != nil {
 			return nil, err
 		}
@@ -109,35 +136,38 @@ func (b *ComparisonBuilder[Entity]) betweenComparison(
 		return nil, errors.New(fmt.Sprintf("Invalid value for %v expected {lower: val, upper: val} got %v", field, val))
 	}
 
-	_val, ok := val.(map[string]any)
-	if !ok {
-		return nil, errors.New("Invalid value, value must be a map");	
+	_val, err := toFilterMap(val)
+	if err != nil {
+		return nil, err
 	}
 
 	if cmp == "notbetween" {
-		lt, err := b.convertQueryValue(field, _val["lower"])
+		lower, err := b.convertQueryValue(field, cmp, _val["lower"])
 		if err != nil {
 			return nil, err
 		}
 
-		gt, err := b.convertQueryValue(field, _val["upper"])
+		upper, err := b.convertQueryValue(field, cmp, _val["upper"])
 		if err != nil {
 			return nil, err
 		}
 
-		return bson.M{ 
-			"$lt": lt, 
-			"$gt": gt,
+		// 小于下限或大于上限，同一个字段上的 $lt 和 $gt 不能同时满足，用 $not 取反
+		return bson.M{
+			"$not": bson.M{
+				"$gte": lower,
+				"$lte": upper,
+			},
 		}, nil
 	}
 	
 
-	gte, err := b.convertQueryValue(field, _val["lower"])
+	gte, err := b.convertQueryValue(field, cmp, _val["lower"])
 	if err != nil {
 		return nil, err
 	}
 
-	lte, err := b.convertQueryValue(field, _val["upper"])
+	lte, err := b.convertQueryValue(field, cmp, _val["upper"])
 	if err != nil {
 		return nil, err
 	}
@@ -155,8 +185,8 @@ func (b *ComparisonBuilder[Entity]) isBetweenVal(
 		return false
 	}
 
-	m, ok := val.(map[string]any)
-	if !ok {
+	m, err := toFilterMap(val)
+	if err != nil {
 		return false
 	}
 
@@ -186,83 +216,65 @@ func (b *ComparisonBuilder) likeComparison(
 }
 */
 
-func (b *ComparisonBuilder[Entity]) convertQueryValue(field string, val any) (any, error) {
-	if field == "_id" || field == "id"  {
+func (b *ComparisonBuilder[Entity]) convertQueryValue(field string, cmp string, val any) (any, error) {
+	if isIDField(field) {
 		return b.convertToObjectId(val)
 	}
 
 	bsonType, ok := b.schema.FieldTypes[field]
-	if ok {
-		switch bsonType {
-		case "string":
-			return val, nil
-		case "bool":
-			// 先看是不是默认就是 bool
-			bv, ok := val.(bool)
-			if !ok {
-				bv, _ = strconv.ParseBool(val.(string))
-			}
-			return bv, nil
-		case "date", "timestamp":
-			// 已经是 time类型，直接返回
-			dv, ok := val.(time.Time)
-			if ok {
-				return dv, nil
-			}
+	if !ok {
+		return val, nil
+	}
 
-			dv, _ = time.Parse(time.RFC3339, val.(string))
-			return dv, nil
-		case "decimal", "double", "int", "long":
-			// 如果是字符串，就需要做一下转换
-			if sv, ok := val.(string); ok {
-				var bitSize int
-				switch bsonType {
-				case "decimal":
-					bitSize = 32
-				case "double":
-					bitSize = 64
-				case "int":
-					bitSize = 32
-				case "long":
-					bitSize = 64
-				}
-
-				var pv any
-				if bsonType == "decimal" || bsonType == "double" {
-					v, _ := strconv.ParseFloat(sv, bitSize)
-					pv = v
-					// retype 32 bit
-					if bitSize == 32 {
-						pv = float32(v)
-					}
-				} else {
-					v, _ := strconv.ParseInt(sv, 0, bitSize)
-					pv = v
-					// retype 32 bit
-					if bitSize == 32 {
-						pv = int32(v)
-					}
-				}
-				return pv, nil
+	// in/notin 的值是数组，逐个转换
+	rv := reflect.ValueOf(val)
+	if (cmp == "in" || cmp == "notin") && rv.Kind() == reflect.Slice {
+		r := make([]any, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			v, err := b.convertValue(field, cmp, bsonType, rv.Index(i).Interface())
+			if err != nil {
+				return nil, err
 			}
-			return val, nil
-		case "object":
-			return val, nil
+			r[i] = v
 		}
+		return r, nil
 	}
 
-	return val, nil
+	return b.convertValue(field, cmp, bsonType, val)
+}
+
+// convertValue 把值转换为字段的 bsonType，非严格模式下无法转换的值原样使用
+func (b *ComparisonBuilder[Entity]) convertValue(field string, cmp string, bsonType string, val any) (any, error) {
+	if val == nil {
+		return nil, nil
+	}
+
+	v, err := convertBsonValue(bsonType, val, b.options.Location)
+	if err != nil && b.options.StrictValidation {
+		return nil, &ValidationError{Field: field, Operator: cmp, Expected: bsonType, Value: val, Reason: err.Error()}
+	}
+	return v, nil
+}
+
+func isIDField(field string) bool {
+	return field == "_id" || field == "id"
 }
 
 func (b *ComparisonBuilder[Entity]) convertToObjectId(val any) (any, error) {
-	if objIDs, ok := val.([]string); ok {
-		var r []any
-		for _, v := range objIDs {
-			id, err := b.convertToObjectId(v)
+	if b.options.IDStrategy != nil {
+		return b.convertID(val)
+	}
+
+	// in/notin 的值可能是 []string、[]any、bson.A，逐个转换
+	rv := reflect.ValueOf(val)
+	if rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() != reflect.Uint8 {
+		r := make([]any, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			id, err := b.convertToObjectId(rv.Index(i).Interface())
 			if err != nil {
 				return nil, err
 			}
-			r = append(r, id)
+			r[i] = id
 		}
 		return r, nil
 	}
@@ -275,4 +287,23 @@ func (b *ComparisonBuilder[Entity]) convertToObjectId(val any) (any, error) {
 	}
 
 	return val, nil
-}
\ No newline at end of file
+}
+
+// convertID 使用配置的 ID 策略转换 id，数组(in/notin)逐个转换
+func (b *ComparisonBuilder[Entity]) convertID(val any) (any, error) {
+	// UUID 等 ID 本身可能是 [16]byte，只把非字节切片当作多个 id
+	rv := reflect.ValueOf(val)
+	if rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() != reflect.Uint8 {
+		r := make([]any, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			id, err := b.options.IDStrategy.Parse(rv.Index(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+			r[i] = id
+		}
+		return r, nil
+	}
+
+	return b.options.IDStrategy.Parse(val)
+}
@@ -0,0 +1,41 @@
+package query
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/duolacloud/crud-core/types"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// EncodeCursor 取出文档中排序字段的值生成游标，sort 是 BuildCursorQuery 补充过 id 的排序字段
+func EncodeCursor(doc bson.M, sort []string, mapper *FieldMapper) (string, error) {
+	values := make([]any, len(sort))
+	for i, field := range sort {
+		field = strings.TrimLeft(field, "+-")
+		values[i] = NormalizeCursorValue(lookupPath(doc, mapper.BsonName(field)))
+	}
+
+	cursor := &types.Cursor{
+		Value: values,
+	}
+
+	w := new(bytes.Buffer)
+	if err := cursor.Marshal(w); err != nil {
+		return "", err
+	}
+	return w.String(), nil
+}
+
+// lookupPath 按 . 分隔的路径取出文档中嵌套字段的值
+func lookupPath(doc bson.M, path string) any {
+	var v any = doc
+	for _, key := range strings.Split(path, ".") {
+		m, ok := v.(bson.M)
+		if !ok {
+			return nil
+		}
+		v = m[key]
+	}
+	return v
+}
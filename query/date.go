@@ -0,0 +1,80 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// now 便于测试替换
+var now = time.Now
+
+// isDateOperator 判断是不是展开为日期范围的操作符
+func isDateOperator(cmp string) bool {
+	switch cmp {
+	case "ondate", "beforedays", "inmonth":
+		return true
+	}
+	return false
+}
+
+// dateComparison 把日期操作符展开为 [$gte, $lt) 范围，日期边界按配置的时区计算:
+//
+//	onDate: "2026-10-16"   当天
+//	beforeDays: 7          从 7 天前的 0 点到今天结束
+//	inMonth: "2026-10"     当月，也可以是该月中的任意日期
+func (b *ComparisonBuilder[Entity]) dateComparison(cmp string, field string, val any) (bson.M, error) {
+	loc := b.options.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	var start, end time.Time
+	switch cmp {
+	case "ondate":
+		d, err := parseDate(val, loc)
+		if err != nil {
+			return nil, b.dateError(field, cmp, val, err)
+		}
+		start = startOfDay(d.In(loc))
+		end = start.AddDate(0, 0, 1)
+	case "beforedays":
+		days, err := strconv.Atoi(fmt.Sprint(val))
+		if err != nil || days < 0 {
+			return nil, b.dateError(field, cmp, val, fmt.Errorf("value %v is not a number of days", val))
+		}
+		today := startOfDay(now().In(loc))
+		start = today.AddDate(0, 0, -days)
+		end = today.AddDate(0, 0, 1)
+	case "inmonth":
+		var d time.Time
+		var err error
+		if s, ok := val.(string); ok && len(s) == len("2006-01") {
+			d, err = time.ParseInLocation("2006-01", s, loc)
+		} else {
+			d, err = parseDate(val, loc)
+		}
+		if err != nil {
+			return nil, b.dateError(field, cmp, val, err)
+		}
+		d = d.In(loc)
+		start = time.Date(d.Year(), d.Month(), 1, 0, 0, 0, 0, loc)
+		end = start.AddDate(0, 1, 0)
+	}
+
+	return bson.M{
+		"$gte": start,
+		"$lt":  end,
+	}, nil
+}
+
+func (b *ComparisonBuilder[Entity]) dateError(field string, cmp string, val any, err error) error {
+	return &ValidationError{Field: field, Operator: cmp, Expected: "date", Value: val, Reason: err.Error()}
+}
+
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,66 @@
+package query
+
+import (
+	"testing"
+	"time"
+
+	mongo_schema "github.com/duolacloud/crud-core-mongo/schema"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestParseDate(t *testing.T) {
+	shanghai := time.FixedZone("CST", 8*3600)
+
+	d, err := parseDate("2026-10-16", shanghai)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 10, 16, 0, 0, 0, 0, shanghai), d)
+
+	d, err = parseDate("2026-10-16T08:30:00.123456Z", shanghai)
+	assert.NoError(t, err)
+	assert.True(t, d.Equal(time.Date(2026, 10, 16, 8, 30, 0, 123456000, time.UTC)))
+
+	d, err = parseDate(int64(1760000000), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Unix(1760000000, 0).UTC(), d)
+
+	d, err = parseDate(float64(1760000000123), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, time.UnixMilli(1760000000123).UTC(), d)
+
+	_, err = parseDate("yesterday", nil)
+	assert.Error(t, err)
+}
+
+func TestDateOperators(t *testing.T) {
+	shanghai := time.FixedZone("CST", 8*3600)
+	b := NewComparisonBuilder[validationUser](nil, mongo_schema.NewSchema(nil), WithLocation(shanghai))
+
+	m, err := b.build("created_at", "onDate", "2026-10-16")
+	assert.NoError(t, err)
+	assert.Equal(t, bson.M{"created_at": bson.M{
+		"$gte": time.Date(2026, 10, 16, 0, 0, 0, 0, shanghai),
+		"$lt":  time.Date(2026, 10, 17, 0, 0, 0, 0, shanghai),
+	}}, m)
+
+	m, err = b.build("created_at", "inMonth", "2026-12")
+	assert.NoError(t, err)
+	assert.Equal(t, bson.M{"created_at": bson.M{
+		"$gte": time.Date(2026, 12, 1, 0, 0, 0, 0, shanghai),
+		"$lt":  time.Date(2027, 1, 1, 0, 0, 0, 0, shanghai),
+	}}, m)
+
+	now = func() time.Time { return time.Date(2026, 10, 16, 20, 0, 0, 0, time.UTC) }
+	defer func() { now = time.Now }()
+
+	// UTC 20 点在上海已经是 17 号
+	m, err = b.build("created_at", "beforeDays", 7)
+	assert.NoError(t, err)
+	assert.Equal(t, bson.M{"created_at": bson.M{
+		"$gte": time.Date(2026, 10, 10, 0, 0, 0, 0, shanghai),
+		"$lt":  time.Date(2026, 10, 18, 0, 0, 0, 0, shanghai),
+	}}, m)
+
+	_, err = b.build("created_at", "onDate", "not a date")
+	assert.ErrorIs(t, err, ErrValidation)
+}
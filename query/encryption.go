@@ -0,0 +1,67 @@
+package query
+
+import (
+	"fmt"
+	"reflect"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// FieldEncrypter 用于查询客户端加密的字段，只有确定性加密的字段可以用 eq/neq/in/notin 查询
+type FieldEncrypter interface {
+	// Encrypted 返回 bson 字段是否加密，以及是否为确定性加密
+	Encrypted(field string) (encrypted bool, deterministic bool)
+	EncryptValue(field string, val any) (any, error)
+}
+
+// encryptedComparison 先按 schema 转换值的类型，再加密，保证和写入时的密文一致
+func (b *ComparisonBuilder[Entity]) encryptedComparison(
+	field string,
+	schemaKey string,
+	cmp string,
+	val any,
+	deterministic bool,
+) (bson.M, error) {
+	if !deterministic {
+		return nil, &ValidationError{Field: field, Operator: cmp, Value: val, Reason: "field is encrypted with the random algorithm and cannot be queried"}
+	}
+
+	op, ok := b.comparisonMap[cmp]
+	if !ok || (op != "$eq" && op != "$ne" && op != "$in" && op != "$nin") {
+		return nil, &ValidationError{Field: field, Operator: cmp, Value: val, Reason: "encrypted fields only support eq, neq, in and notin"}
+	}
+
+	encrypt := func(v any) (any, error) {
+		// is null / isnot null 不需要加密
+		if v == nil {
+			return nil, nil
+		}
+		v, err := b.convertQueryValue(schemaKey, "eq", v)
+		if err != nil {
+			return nil, err
+		}
+		return b.options.Encrypter.EncryptValue(schemaKey, v)
+	}
+
+	if op == "$in" || op == "$nin" {
+		rv := reflect.ValueOf(val)
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			return nil, fmt.Errorf("invalid value for %s.%s, expected an array, got %v", field, cmp, val)
+		}
+		values := make([]any, rv.Len())
+		for i := range values {
+			v, err := encrypt(rv.Index(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+			values[i] = v
+		}
+		return bson.M{schemaKey: bson.M{op: values}}, nil
+	}
+
+	v, err := encrypt(val)
+	if err != nil {
+		return nil, err
+	}
+	return bson.M{schemaKey: bson.M{op: v}}, nil
+}
@@ -0,0 +1,204 @@
+package query
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// FieldMapper 把查询中使用的字段名(Go 字段名、json tag)映射为文档中的 bson 字段名，
+// 支持用 . 分隔的嵌套路径，以及 inline 和嵌入的结构体
+type FieldMapper struct {
+	// api 是 Go 字段名和 json 名，bson 是 bson 名本身
+	api  map[string]*fieldMapping
+	bson map[string]*fieldMapping
+}
+
+type fieldMapping struct {
+	// name 是 bson 路径，嵌入(非 inline)结构体提升上来的字段会带上前缀
+	name   string
+	nested *FieldMapper
+}
+
+var (
+	fieldMappersMu sync.Mutex
+	fieldMappers   = map[reflect.Type]*FieldMapper{}
+)
+
+// NewFieldMapper 根据 Entity 的 json 和 bson tag 生成字段映射，结果按类型缓存
+func NewFieldMapper[Entity any]() *FieldMapper {
+	return NewFieldMapperFromType(reflect.TypeOf((*Entity)(nil)).Elem())
+}
+
+func NewFieldMapperFromType(t reflect.Type) *FieldMapper {
+	fieldMappersMu.Lock()
+	defer fieldMappersMu.Unlock()
+
+	return fieldMapperOf(t)
+}
+
+func fieldMapperOf(t reflect.Type) *FieldMapper {
+	t = elemType(t)
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	if m, ok := fieldMappers[t]; ok {
+		return m
+	}
+
+	m := &FieldMapper{
+		api:  map[string]*fieldMapping{},
+		bson: map[string]*fieldMapping{},
+	}
+	// 先放入缓存，自引用的类型可以复用
+	fieldMappers[t] = m
+
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+
+		bsonName, inline, skip := parseBsonTag(sf)
+		if skip {
+			continue
+		}
+		jsonName, jsonSkip := parseJSONTag(sf)
+
+		nested := fieldMapperOf(sf.Type)
+
+		if inline {
+			if nested != nil {
+				m.merge(nested, "", true)
+			}
+			continue
+		}
+
+		f := &fieldMapping{name: bsonName, nested: nested}
+		m.bson[bsonName] = f
+
+		// 没有 json 名的嵌入结构体，json 会把字段提升到外层
+		if sf.Anonymous && nested != nil && jsonName == "" {
+			m.merge(nested, bsonName+".", false)
+			continue
+		}
+
+		m.api[sf.Name] = f
+		if !jsonSkip && jsonName != "" {
+			m.api[jsonName] = f
+		}
+	}
+
+	return m
+}
+
+// merge 把内嵌结构体的字段合并进来，外层已有的同名字段优先
+func (m *FieldMapper) merge(nested *FieldMapper, prefix string, withBson bool) {
+	for k, f := range nested.api {
+		if _, ok := m.api[k]; !ok {
+			m.api[k] = &fieldMapping{name: prefix + f.name, nested: f.nested}
+		}
+	}
+	if withBson {
+		for k, f := range nested.bson {
+			if _, ok := m.bson[k]; !ok {
+				m.bson[k] = &fieldMapping{name: prefix + f.name, nested: f.nested}
+			}
+		}
+	}
+}
+
+func (m *FieldMapper) lookup(field string) *fieldMapping {
+	if f, ok := m.api[field]; ok {
+		return f
+	}
+	return m.bson[field]
+}
+
+// BsonName 返回字段在文档中的路径，id 总是映射为 _id，未知的字段保持不变
+func (m *FieldMapper) BsonName(field string) string {
+	if field == "id" {
+		return "_id"
+	}
+	if m == nil {
+		return field
+	}
+
+	segments := strings.Split(field, ".")
+	current := m
+	for i, seg := range segments {
+		if current == nil {
+			break
+		}
+
+		f := current.lookup(seg)
+		if f == nil {
+			// 数组下标，继续使用元素类型的映射
+			if isIndexSegment(seg) {
+				continue
+			}
+			current = nil
+			continue
+		}
+
+		segments[i] = f.name
+		current = f.nested
+	}
+
+	return strings.Join(segments, ".")
+}
+
+func elemType(t reflect.Type) reflect.Type {
+	for {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+			t = t.Elem()
+		default:
+			return t
+		}
+	}
+}
+
+// parseBsonTag 和 mongo-driver 默认的规则一致：没有名字时使用小写的 Go 字段名
+func parseBsonTag(sf reflect.StructField) (name string, inline bool, skip bool) {
+	tag, ok := sf.Tag.Lookup("bson")
+	if !ok && !strings.Contains(string(sf.Tag), ":") && sf.Tag != "" {
+		tag = string(sf.Tag)
+	}
+	if tag == "-" {
+		return "", false, true
+	}
+
+	parts := strings.Split(tag, ",")
+	name = parts[0]
+	for _, opt := range parts[1:] {
+		if opt == "inline" {
+			inline = true
+		}
+	}
+	if name == "" {
+		name = strings.ToLower(sf.Name)
+	}
+	return name, inline, false
+}
+
+func parseJSONTag(sf reflect.StructField) (name string, skip bool) {
+	tag := sf.Tag.Get("json")
+	if tag == "-" {
+		return "", true
+	}
+	return strings.Split(tag, ",")[0], false
+}
+
+func isIndexSegment(seg string) bool {
+	if seg == "" {
+		return false
+	}
+	for _, c := range seg {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,49 @@
+package query
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type MapperBase struct {
+	CreatedAt time.Time `json:"createdAt" bson:"created_at"`
+}
+
+type MapperMeta struct {
+	Tags []string `json:"tags" bson:"tag_list"`
+}
+
+type mapperAddress struct {
+	ZipCode string `json:"zipCode" bson:"zip_code"`
+}
+
+type mapperUser struct {
+	MapperBase `bson:",inline"`
+	MapperMeta
+	ID        string           `json:"id" bson:"_id"`
+	FirstName string           `json:"firstName" bson:"first_name"`
+	Nickname  string           `bson:"nick"`
+	Address   *mapperAddress   `json:"address" bson:"addr"`
+	History   []*mapperAddress `json:"history" bson:"history"`
+	Secret    string           `json:"-" bson:"-"`
+	Plain     string
+}
+
+func TestFieldMapper(t *testing.T) {
+	m := NewFieldMapper[mapperUser]()
+
+	assert.Equal(t, "_id", m.BsonName("id"))
+	assert.Equal(t, "first_name", m.BsonName("firstName"))
+	assert.Equal(t, "first_name", m.BsonName("FirstName"))
+	assert.Equal(t, "first_name", m.BsonName("first_name"))
+	assert.Equal(t, "nick", m.BsonName("Nickname"))
+	assert.Equal(t, "plain", m.BsonName("Plain"))
+	assert.Equal(t, "created_at", m.BsonName("createdAt"))
+	assert.Equal(t, "mappermeta.tag_list", m.BsonName("tags"))
+	assert.Equal(t, "addr.zip_code", m.BsonName("address.zipCode"))
+	assert.Equal(t, "history.0.zip_code", m.BsonName("history.0.zipCode"))
+	assert.Equal(t, "addr.unknown", m.BsonName("address.unknown"))
+	assert.Equal(t, "Secret", m.BsonName("Secret"))
+}
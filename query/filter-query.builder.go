@@ -2,9 +2,11 @@ package query
 
 import(
 	"fmt"
+	"strings"
 	"time"
 	"errors"
 	"github.com/duolacloud/crud-core/types"
+	"github.com/duolacloud/crud-core-mongo/ids"
 	mongo_schema "github.com/duolacloud/crud-core-mongo/schema"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -25,29 +27,115 @@ type MongoCursorQuery struct {
 	Reverse bool
 }
 
+type FilterQueryBuilderOptions struct {
+	// IDStrategy 用于转换筛选条件中的 id，未设置时看起来像 ObjectID 的字符串会被转换为 ObjectID
+	IDStrategy ids.Strategy
+	Guardrails *Guardrails
+	// FieldMapper 把查询中的字段名映射为 bson 字段名，默认根据 Entity 的 tag 生成
+	FieldMapper *FieldMapper
+	// Location 是没有时区的日期使用的时区，以及 onDate 等日期操作符计算日期边界的时区，默认 UTC
+	Location *time.Location
+	// Encrypter 加密筛选条件中加密字段的值
+	Encrypter FieldEncrypter
+	// FieldAccess 限制调用方可以筛选、排序和投影的字段
+	FieldAccess *FieldAccess
+	// StrictValidation 为 true 时，筛选条件中未知的字段和无法转换的值会返回 ValidationError
+	StrictValidation bool
+}
+
+type FilterQueryBuilderOption func(*FilterQueryBuilderOptions)
+
+func WithIDStrategy(s ids.Strategy) FilterQueryBuilderOption {
+	return func(o *FilterQueryBuilderOptions) {
+		o.IDStrategy = s
+	}
+}
+
+func WithGuardrails(g *Guardrails) FilterQueryBuilderOption {
+	return func(o *FilterQueryBuilderOptions) {
+		o.Guardrails = g
+	}
+}
+
+func WithFieldMapper(m *FieldMapper) FilterQueryBuilderOption {
+	return func(o *FilterQueryBuilderOptions) {
+		o.FieldMapper = m
+	}
+}
+
+func WithLocation(loc *time.Location) FilterQueryBuilderOption {
+	return func(o *FilterQueryBuilderOptions) {
+		o.Location = loc
+	}
+}
+
+func WithEncrypter(e FieldEncrypter) FilterQueryBuilderOption {
+	return func(o *FilterQueryBuilderOptions) {
+		o.Encrypter = e
+	}
+}
+
+func WithFieldAccess(a *FieldAccess) FilterQueryBuilderOption {
+	return func(o *FilterQueryBuilderOptions) {
+		o.FieldAccess = a
+	}
+}
+
+func WithStrictValidation(v bool) FilterQueryBuilderOption {
+	return func(o *FilterQueryBuilderOptions) {
+		o.StrictValidation = v
+	}
+}
+
+func newFilterQueryBuilderOptions(opts []FilterQueryBuilderOption) *FilterQueryBuilderOptions {
+	options := &FilterQueryBuilderOptions{}
+	for _, o := range opts {
+		o(options)
+	}
+	return options
+}
+
 type FilterQueryBuilder[Entity any] struct {
 	whereBuilder *WhereBuilder[Entity]
 	aggregateBuilder *AggregateBuilder
 	schema *mongo_schema.Schema
 	strictValidation bool
+	options *FilterQueryBuilderOptions
 }
 
 func NewFilterQueryBuilder[Entity any](
 	schema *mongo_schema.Schema,
 	strictValidation bool,
+	opts ...FilterQueryBuilderOption,
 ) *FilterQueryBuilder[Entity] {
+	if strictValidation {
+		opts = append(opts[:len(opts):len(opts)], WithStrictValidation(true))
+	}
+	if newFilterQueryBuilderOptions(opts).FieldMapper == nil {
+		opts = append(opts[:len(opts):len(opts)], WithFieldMapper(NewFieldMapper[Entity]()))
+	}
+
 	b := &FilterQueryBuilder[Entity]{
-		strictValidation: strictValidation,
 		schema: schema,
+		options: newFilterQueryBuilderOptions(opts),
 	}
+	b.strictValidation = b.options.StrictValidation
 
-	b.whereBuilder = NewWhereBuilder[Entity](schema)
-	b.aggregateBuilder = NewAggregateBuilder()
+	b.whereBuilder = NewWhereBuilder[Entity](schema, opts...)
+	b.aggregateBuilder = NewAggregateBuilder(opts...)
 
 	return b
 }
 
 func (b *FilterQueryBuilder[Entity]) BuildQuery(query *types.PageQuery) (*MongoQuery, error) {
+	if err := b.checkAccess(query.Filter, query.Sort, query.Fields); err != nil {
+		return nil, err
+	}
+
+	if err := b.checkGuardrails(query.Filter, query.Sort); err != nil {
+		return nil, err
+	}
+
 	filterQuery, err := b.buildFilterQuery(query.Filter)
 	if err != nil {
 		return nil, err
@@ -62,6 +150,18 @@ func (b *FilterQueryBuilder[Entity]) BuildQuery(query *types.PageQuery) (*MongoQ
 		Sort: sort,
 	}
 	b.setPaginationOptions(query.Page, opts)
+	if g := b.options.Guardrails; g != nil {
+		var limit int64
+		if opts.Limit != nil {
+			limit = *opts.Limit
+		}
+		if size := g.pageSize(limit); size != limit {
+			opts.SetLimit(size)
+		}
+		if err := g.checkPagination(opts.Limit, opts.Skip); err != nil {
+			return nil, err
+		}
+	}
 
 	prj, err := b.buildProjections(query.Fields)
 	if err != nil {
@@ -78,6 +178,14 @@ func (b *FilterQueryBuilder[Entity]) BuildQuery(query *types.PageQuery) (*MongoQ
 }
 
 func (b *FilterQueryBuilder[Entity]) BuildAggregateQuery(aggregate *types.AggregateQuery, filter map[string]any) (*MongoAggregateQuery, error) {
+	if err := b.checkAccess(filter, nil, aggregateFields(aggregate)); err != nil {
+		return nil, err
+	}
+
+	if err := b.checkGuardrails(filter, nil); err != nil {
+		return nil, err
+	}
+
 	filterQuery, err := b.buildFilterQuery(filter)
 	if err != nil {
 		return nil, err
@@ -109,16 +217,16 @@ func (b *FilterQueryBuilder[Entity]) BuildAggregateQuery(aggregate *types.Aggreg
 	}, nil
 }
 
-func (b *FilterQueryBuilder[Entity]) buildAggregateSorting(aggregate *types.AggregateQuery) (map[string]int, error) {
+func (b *FilterQueryBuilder[Entity]) buildAggregateSorting(aggregate *types.AggregateQuery) (bson.D, error) {
 	aggregateGroupBy := b.aggregateBuilder.getGroupBySelects(aggregate.GroupBy)
 	if aggregateGroupBy == nil {
 		return nil, nil
 	}
 
-	var sort = make(map[string]int)
+	var sort bson.D
 
 	for _, field := range aggregateGroupBy {
-		sort[field] = 1
+		sort = append(sort, bson.E{Key: field, Value: 1})
 	}
 
 	return sort, nil
@@ -151,6 +259,68 @@ func (b *FilterQueryBuilder[Entity]) setPaginationOptions(pagination map[string]
 	}
 }
 
+func (b *FilterQueryBuilder[Entity]) checkAccess(filter map[string]any, sort []string, fields []string) error {
+	a := b.options.FieldAccess
+	if a == nil {
+		return nil
+	}
+	mapper := b.options.FieldMapper
+
+	filterFields, err := collectFilterFields(filter)
+	if err != nil {
+		return err
+	}
+	for _, field := range filterFields {
+		if err := a.check(mapper, field, "filter", a.Filterable); err != nil {
+			return err
+		}
+	}
+
+	for _, field := range sort {
+		if err := a.check(mapper, strings.TrimLeft(field, "+-"), "sort", a.Sortable); err != nil {
+			return err
+		}
+	}
+
+	for _, field := range fields {
+		// 排除字段总是允许的
+		if strings.HasPrefix(field, "-") {
+			continue
+		}
+		if err := a.check(mapper, strings.TrimLeft(field, "+"), "projection", a.Projectable); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// aggregateFields 返回聚合用到的字段，按投影检查权限
+func aggregateFields(aggregate *types.AggregateQuery) []string {
+	var fields []string
+	for _, f := range [][]string{aggregate.Count, aggregate.Sum, aggregate.Avg, aggregate.Max, aggregate.Min, aggregate.GroupBy} {
+		fields = append(fields, f...)
+	}
+	return fields
+}
+
+func (b *FilterQueryBuilder[Entity]) checkGuardrails(filter map[string]any, sort []string) error {
+	g := b.options.Guardrails
+	if g == nil {
+		return nil
+	}
+
+	if err := g.checkSort(b.options.FieldMapper, sort); err != nil {
+		return err
+	}
+
+	fields, err := g.checkFilter(b.options.FieldMapper, filter)
+	if err != nil {
+		return err
+	}
+
+	return g.checkIndex(b.options.FieldMapper, fields, sort)
+}
+
 func (b *FilterQueryBuilder[Entity]) buildFilterQuery(filter map[string]any) (bson.M, error) {
 	if filter == nil {
 		return bson.M{}, nil
@@ -177,10 +347,7 @@ func (b *FilterQueryBuilder[Entity]) buildProjections(fields []string) (map[stri
 				field = field[1:]
 			}
 
-			// TODO 这里打个补丁先，正常要读取 结构体的 元数据 来得到 字段名
-			if field == "id" {
-				field = "_id"
-			}
+			field = b.options.FieldMapper.BsonName(field)
 
 			// lookup field in the fieldTypes dictionary if strictValidation is true
 			if b.strictValidation {
@@ -198,8 +365,9 @@ func (b *FilterQueryBuilder[Entity]) buildProjections(fields []string) (map[stri
 	return prj, nil
 }
 
-func (b *FilterQueryBuilder[Entity]) buildSorting(fields []string) (map[string]int, error) {
-	sort := map[string]int{}
+// buildSorting 使用 bson.D 保持排序字段的顺序
+func (b *FilterQueryBuilder[Entity]) buildSorting(fields []string) (bson.D, error) {
+	sort := bson.D{}
 	if len(fields) > 0 {
 		for _, field := range fields {
 			val := 1
@@ -213,10 +381,7 @@ func (b *FilterQueryBuilder[Entity]) buildSorting(fields []string) (map[string]i
 				field = field[1:]
 			}
 
-			// TODO 这里打个补丁先，正常要读取 结构体的 元数据 来得到 字段名
-			if field == "id" {
-				field = "_id"
-			}
+			field = b.options.FieldMapper.BsonName(field)
 
 			// lookup field in the fieldTypes dictionary if strictValidation is true
 			if b.strictValidation {
@@ -226,7 +391,7 @@ func (b *FilterQueryBuilder[Entity]) buildSorting(fields []string) (map[string]i
 				}
 			}
 
-			sort[field] = val
+			sort = append(sort, bson.E{Key: field, Value: val})
 		}
 	}
 
@@ -242,6 +407,27 @@ func (b *FilterQueryBuilder[Entity]) buildSorting(fields []string) (map[string]i
 
 
 func (b *FilterQueryBuilder[Entity]) BuildCursorQuery(query *types.CursorQuery) (*MongoCursorQuery, error) {
+	if err := b.checkAccess(query.Filter, query.Sort, query.Fields); err != nil {
+		return nil, err
+	}
+
+	if err := b.checkGuardrails(query.Filter, query.Sort); err != nil {
+		return nil, err
+	}
+
+	// 默认分页大小只作用于本次查询，不修改调用方的 query
+	pageSize := query.Limit
+	if g := b.options.Guardrails; g != nil {
+		pageSize = g.pageSize(pageSize)
+		if err := g.checkPagination(&pageSize, nil); err != nil {
+			return nil, err
+		}
+	}
+	// 游标分页总要多取一条判断是否还有数据，分页大小必须是正数
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("invalid cursor query limit %d, expected a positive number", query.Limit)
+	}
+
 	filterQuery, err := b.buildFilterQuery(query.Filter)
 	if err != nil {
 		return nil, err
@@ -262,7 +448,15 @@ func (b *FilterQueryBuilder[Entity]) BuildCursorQuery(query *types.CursorQuery)
 		return nil, err
 	}
 
-	limit := query.Limit + 1
+	// 向前翻页时反向排序取离游标最近的数据，调用方需要把结果再反转回来
+	reverse := query.Direction == types.CursorDirectionBefore
+	if reverse {
+		for i := range sort {
+			sort[i].Value = -sort[i].Value.(int)
+		}
+	}
+
+	limit := pageSize + 1
 
 	opts := &options.FindOptions{
 		Sort: sort,
@@ -282,6 +476,7 @@ func (b *FilterQueryBuilder[Entity]) BuildCursorQuery(query *types.CursorQuery)
 			FilterQuery: filters,
 			Options: opts,
 		},
+		Reverse: reverse,
 	}, nil
 }
 
@@ -296,26 +491,16 @@ func (b *FilterQueryBuilder[Entity]) ensureOrders(query *types.CursorQuery) {
 			sortField = sortField[1:]
 		}
 
-		if sortField == "id" {
+		if b.options.FieldMapper.BsonName(sortField) == "_id" {
 			hasId = true
 		}
 	}
 
-	// 没有id的排序，直接要追加 ID
+	// 没有id的排序，在最后追加 ID，保证排序唯一
 	if !hasId {
-		index := 0
-		if query.Sort == nil {
-			query.Sort = make([]string, 1)
-			query.Sort[0] = "id"
-		} else {
-			tmp := query.Sort
-			query.Sort = make([]string, len(query.Sort) + 1)
-			query.Sort[0] = "id"
-			for i, f := range tmp {
-				query.Sort[i+1] = f
-				index++
-			}
-		}
+		sort := make([]string, len(query.Sort), len(query.Sort)+1)
+		copy(sort, query.Sort)
+		query.Sort = append(sort, "id")
 	}
 }
 
@@ -339,24 +524,22 @@ func (b *FilterQueryBuilder[Entity]) buildCursorFilter(query *types.CursorQuery)
 
 		fields := make([]string, len(cursor.Value))
 		values := make([]any, len(cursor.Value))
+		cmps := make([]string, len(cursor.Value))
 
 		for i, value := range cursor.Value {
-			// val := 1
 			sortField := query.Sort[i]
 
+			direction := 1
 			if sortField[0:1] == "-" {
 				sortField = sortField[1:]
-				// val = -1
+				direction = -1
 			}
 
 			if sortField[0:1] == "+" {
 				sortField = sortField[1:]
 			}
 
-			// TODO 这里打个补丁先，正常要读取 结构体的 元数据 来得到 字段名
-			if sortField == "id" {
-				sortField = "_id"
-			}
+			sortField = b.options.FieldMapper.BsonName(sortField)
 	
 			sort_field_type, ok := b.schema.FieldTypes[sortField]
 			if !ok {
@@ -365,56 +548,27 @@ func (b *FilterQueryBuilder[Entity]) buildCursorFilter(query *types.CursorQuery)
 			}
 			fields[i] = sortField
 
-			switch sort_field_type {
-			case "date", "timestamp":
-				// 本身就是 time 类型
-				if t, ok := value.(time.Time); ok {
-					values[i] = t
-				}
+			// 游标中的值经过了序列化，按 schema 转换回来，无法转换时原样使用
+			values[i], _ = convertBsonValue(sort_field_type, value, b.options.Location)
 
-				v, err := time.Parse(time.RFC3339, value.(string))
-				if err == nil {
-					values[i] = v
-				}
-			default:
-				values[i] = value
+			// 每个字段按自己的排序方向比较，向前翻页时方向相反
+			if query.Direction == types.CursorDirectionBefore {
+				direction = -direction
 			}
-		}
-
-
-		sort_field_0_direction := 1
-		sort_field_0 := query.Sort[0]
-
-		if sort_field_0[0:1] == "-" {
-			sort_field_0 = sort_field_0[1:]
-			sort_field_0_direction = -1
-		}
-
-		if sort_field_0[0:1] == "+" {
-			sort_field_0 = sort_field_0[1:]
-		}
-
-		var cmp string
-
-		if query.Direction == types.CursorDirectionBefore {
-			// before
-			if sort_field_0_direction == -1 {
-				cmp = "$gt"
-			} else {
-				cmp = "$lt"
-			}
-		} else {
-			// after
-			if sort_field_0_direction == -1 {
-				cmp = "$lt"
+			if direction == -1 {
+				cmps[i] = "$lt"
 			} else {
-				cmp = "$gt"
+				cmps[i] = "$gt"
 			}
 		}
 
-		var ands []bson.M
-		for i, field := range fields {
-			ands = append(ands, bson.M{ field: bson.M{ cmp: values[i] }})
+		// (f0 > v0) or (f0 = v0 and f1 > v1) or ...
+		for i := range fields {
+			var ands []bson.M
+			for j := 0; j < i; j++ {
+				ands = append(ands, bson.M{ fields[j]: bson.M{ "$eq": values[j] }})
+			}
+			ands = append(ands, bson.M{ fields[i]: bson.M{ cmps[i]: values[i] }})
 
 			ors = append(ors, bson.M{"$and": ands})
 		}
@@ -425,7 +579,5 @@ func (b *FilterQueryBuilder[Entity]) buildCursorFilter(query *types.CursorQuery)
 		cursorFilter["$or"] = ors
 	}
 
-	fmt.Printf("cursorFilter: %v\n", cursorFilter)
-
 	return cursorFilter, nil
 }	
\ No newline at end of file
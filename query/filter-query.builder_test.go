@@ -0,0 +1,133 @@
+package query
+
+import (
+	"testing"
+
+	mongo_schema "github.com/duolacloud/crud-core-mongo/schema"
+	"github.com/duolacloud/crud-core/types"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestBuildSorting(t *testing.T) {
+	b := newValidationBuilder(false)
+
+	q, err := b.BuildQuery(&types.PageQuery{Sort: []string{"-age", "+name", "active"}})
+	assert.NoError(t, err)
+	assert.Equal(t, bson.D{{Key: "age", Value: -1}, {Key: "name", Value: 1}, {Key: "active", Value: 1}}, q.Options.Sort)
+
+	aq, err := b.BuildAggregateQuery(&types.AggregateQuery{GroupBy: []string{"name", "age"}, Count: []string{"age"}}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, bson.D{{Key: "_id.group_by_name", Value: 1}, {Key: "_id.group_by_age", Value: 1}}, aq.Options.Sort)
+}
+
+func TestBetweenComparison(t *testing.T) {
+	b := newValidationBuilder(false)
+
+	q, err := b.BuildQuery(&types.PageQuery{Filter: map[string]any{
+		"age": map[string]any{"notbetween": map[string]any{"lower": 20, "upper": 25}},
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, bson.M{"$and": []bson.M{{"age": bson.M{"$not": bson.M{"$gte": int64(20), "$lte": int64(25)}}}}}, q.FilterQuery)
+
+	q, err = b.BuildQuery(&types.PageQuery{Filter: map[string]any{
+		"age": map[string]any{"between": map[string]any{"lower": 20, "upper": 25}},
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, bson.M{"$and": []bson.M{{"age": bson.M{"$gte": int64(20), "$lte": int64(25)}}}}, q.FilterQuery)
+}
+
+func TestCountAggregate(t *testing.T) {
+	b := newValidationBuilder(false)
+
+	aq, err := b.BuildAggregateQuery(&types.AggregateQuery{Count: []string{"name", "age"}, Sum: []string{"age"}}, nil)
+	assert.NoError(t, err)
+	assert.Contains(t, aq.Aggregate, "count_name")
+	assert.Contains(t, aq.Aggregate, "count_age")
+	assert.Contains(t, aq.Aggregate, "sum_age")
+}
+
+type cursorUser struct {
+	ID   string `bson:"_id"`
+	Name string `bson:"name"`
+	Age  int64  `bson:"age"`
+}
+
+func TestBuildCursorQuery(t *testing.T) {
+	schema := mongo_schema.NewSchema(bson.M{
+		"$jsonSchema": bson.M{
+			"properties": bson.M{
+				"_id":  bson.M{"bsonType": "string"},
+				"name": bson.M{"bsonType": "string"},
+				"age":  bson.M{"bsonType": "long"},
+			},
+		},
+	})
+	b := NewFilterQueryBuilder[cursorUser](schema, false)
+
+	cursor, err := EncodeCursor(bson.M{"_id": "4", "name": "bob", "age": int64(30)}, []string{"-age", "name", "id"}, NewFieldMapper[cursorUser]())
+	assert.NoError(t, err)
+
+	keyset := func(ageCmp, nameCmp, idCmp string) bson.M {
+		return bson.M{"$or": []bson.M{
+			{"$and": []bson.M{{"age": bson.M{ageCmp: int64(30)}}}},
+			{"$and": []bson.M{{"age": bson.M{"$eq": int64(30)}}, {"name": bson.M{nameCmp: "bob"}}}},
+			{"$and": []bson.M{{"age": bson.M{"$eq": int64(30)}}, {"name": bson.M{"$eq": "bob"}}, {"_id": bson.M{idCmp: "4"}}}},
+		}}
+	}
+
+	// id 追加在最后，每个字段按自己的方向比较
+	q := &types.CursorQuery{Sort: []string{"-age", "name"}, Cursor: cursor, Limit: 2}
+	mq, err := b.BuildCursorQuery(q)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"-age", "name", "id"}, q.Sort)
+	assert.False(t, mq.Reverse)
+	assert.Equal(t, bson.D{{Key: "age", Value: -1}, {Key: "name", Value: 1}, {Key: "_id", Value: 1}}, mq.Options.Sort)
+	assert.Equal(t, keyset("$lt", "$gt", "$gt"), mq.FilterQuery["$and"].([]bson.M)[0])
+
+	// 向前翻页反向排序，结果由调用方反转
+	q = &types.CursorQuery{Sort: []string{"-age", "name"}, Cursor: cursor, Limit: 2, Direction: types.CursorDirectionBefore}
+	mq, err = b.BuildCursorQuery(q)
+	assert.NoError(t, err)
+	assert.True(t, mq.Reverse)
+	assert.Equal(t, bson.D{{Key: "age", Value: 1}, {Key: "name", Value: -1}, {Key: "_id", Value: -1}}, mq.Options.Sort)
+	assert.Equal(t, keyset("$gt", "$lt", "$lt"), mq.FilterQuery["$and"].([]bson.M)[0])
+}
+
+func TestBuildCursorQueryDefaultLimit(t *testing.T) {
+	b := NewFilterQueryBuilder[cursorUser](mongo_schema.NewSchema(bson.M{}), false, WithGuardrails(&Guardrails{DefaultLimit: 20}))
+
+	q := &types.CursorQuery{}
+	mq, err := b.BuildCursorQuery(q)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(21), *mq.Options.Limit)
+	assert.Equal(t, int64(0), q.Limit)
+}
+
+func TestBuildQueryLimit(t *testing.T) {
+	b := NewFilterQueryBuilder[cursorUser](mongo_schema.NewSchema(bson.M{}), false, WithGuardrails(&Guardrails{MaxLimit: 100}))
+
+	q, err := b.BuildQuery(&types.PageQuery{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(100), *q.Options.Limit)
+
+	q, err = b.BuildQuery(&types.PageQuery{Page: map[string]int{"limit": 0}})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(100), *q.Options.Limit)
+
+	_, err = b.BuildQuery(&types.PageQuery{Page: map[string]int{"limit": 200}})
+	assert.ErrorIs(t, err, ErrGuardrail)
+
+	mq, err := b.BuildCursorQuery(&types.CursorQuery{Limit: -1})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(101), *mq.Options.Limit)
+}
+
+func TestBuildCursorQueryInvalidLimit(t *testing.T) {
+	b := NewFilterQueryBuilder[cursorUser](mongo_schema.NewSchema(bson.M{}), false)
+
+	_, err := b.BuildCursorQuery(&types.CursorQuery{})
+	assert.Error(t, err)
+	_, err = b.BuildCursorQuery(&types.CursorQuery{Limit: -1})
+	assert.Error(t, err)
+}
@@ -0,0 +1,241 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrGuardrail = errors.New("query rejected by guardrail")
+
+type GuardrailError struct {
+	Rule   string
+	Field  string
+	Detail string
+}
+
+func (e *GuardrailError) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("%s: %s (%s): %s", ErrGuardrail, e.Rule, e.Field, e.Detail)
+	}
+	return fmt.Sprintf("%s: %s: %s", ErrGuardrail, e.Rule, e.Detail)
+}
+
+func (e *GuardrailError) Is(target error) bool {
+	return target == ErrGuardrail
+}
+
+// Guardrails 限制客户端可以发起的查询，零值表示不限制
+type Guardrails struct {
+	// DefaultLimit 是没有指定分页大小时使用的大小，为 0 时使用 MaxLimit
+	DefaultLimit int64
+	MaxLimit     int64
+	MaxSkip      int64
+	// MaxDepth 是 and/or 嵌套的最大层数
+	MaxDepth int
+	// MaxClauses 是筛选条件中字段比较的最大数量
+	MaxClauses int
+	// SortableFields/FilterableFields 为空时不限制，字段名可以是 api 名或 bson 名
+	SortableFields   []string
+	FilterableFields []string
+	// Operators 限制字段可以使用的操作符，没有列出的字段不限制，键同样可以是 api 名或 bson 名
+	Operators map[string][]string
+	// Indexes 是已知的索引，每个索引是按顺序排列的字段名
+	Indexes [][]string
+	// RequireIndex 为 true 时，筛选和排序必须能使用 Indexes 中的某个索引，_id 索引总是可用
+	RequireIndex bool
+}
+
+// pageSize 返回实际使用的分页大小，limit 不大于 0 视为没有指定，使用 DefaultLimit，
+// DefaultLimit 为 0 时使用 MaxLimit，都没有设置时返回 0 表示不限制
+func (g *Guardrails) pageSize(limit int64) int64 {
+	if limit > 0 {
+		return limit
+	}
+	if g.DefaultLimit > 0 {
+		return g.DefaultLimit
+	}
+	return g.MaxLimit
+}
+
+func (g *Guardrails) checkPagination(limit *int64, skip *int64) error {
+	if g.MaxLimit > 0 {
+		// mongo 中 0 表示不限制，负数表示只返回一批，都会绕过 MaxLimit
+		if limit == nil || *limit <= 0 {
+			return &GuardrailError{Rule: "max_limit", Detail: fmt.Sprintf("limit is required and must be between 1 and %d", g.MaxLimit)}
+		}
+		if *limit > g.MaxLimit {
+			return &GuardrailError{Rule: "max_limit", Detail: fmt.Sprintf("limit %d exceeds %d", *limit, g.MaxLimit)}
+		}
+	}
+	if skip != nil && g.MaxSkip > 0 && *skip > g.MaxSkip {
+		return &GuardrailError{Rule: "max_skip", Detail: fmt.Sprintf("skip %d exceeds %d", *skip, g.MaxSkip)}
+	}
+	return nil
+}
+
+func (g *Guardrails) checkSort(mapper *FieldMapper, fields []string) error {
+	if len(g.SortableFields) == 0 {
+		return nil
+	}
+	for _, field := range fields {
+		field = strings.TrimLeft(field, "+-")
+		if !containsField(mapper, g.SortableFields, field) {
+			return &GuardrailError{Rule: "sortable_fields", Field: field, Detail: "field is not sortable"}
+		}
+	}
+	return nil
+}
+
+// checkFilter 检查 crud-core 格式的筛选条件，返回用到的字段
+func (g *Guardrails) checkFilter(mapper *FieldMapper, filter map[string]any) ([]string, error) {
+	var fields []string
+	clauses := 0
+
+	var walk func(filter map[string]any, depth int) error
+	walk = func(filter map[string]any, depth int) error {
+		if g.MaxDepth > 0 && depth > g.MaxDepth {
+			return &GuardrailError{Rule: "max_depth", Detail: fmt.Sprintf("filter is nested deeper than %d", g.MaxDepth)}
+		}
+
+		for field, value := range filter {
+			if isLogicalKey(field) {
+				var subFilters []map[string]any
+				var err error
+				if field == "not" {
+					var sub map[string]any
+					sub, err = toFilterMap(value)
+					subFilters = []map[string]any{sub}
+				} else {
+					subFilters, err = toFilterList(value)
+				}
+				if err != nil {
+					return err
+				}
+				for _, sub := range subFilters {
+					if err := walk(sub, depth+1); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+
+			if len(g.FilterableFields) > 0 && !containsField(mapper, g.FilterableFields, field) {
+				return &GuardrailError{Rule: "filterable_fields", Field: field, Detail: "field is not filterable"}
+			}
+
+			allowed, restricted := g.operators(mapper, field)
+			cmp, _ := toFilterMap(value)
+			for op := range cmp {
+				clauses++
+				if restricted && !contains(allowed, strings.ToLower(op)) {
+					return &GuardrailError{Rule: "operators", Field: field, Detail: fmt.Sprintf("operator %s is not allowed", op)}
+				}
+			}
+			if g.MaxClauses > 0 && clauses > g.MaxClauses {
+				return &GuardrailError{Rule: "max_clauses", Detail: fmt.Sprintf("filter has more than %d clauses", g.MaxClauses)}
+			}
+
+			if !contains(fields, field) {
+				fields = append(fields, field)
+			}
+		}
+		return nil
+	}
+
+	if err := walk(filter, 1); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// checkIndex 使用前缀规则判断能否使用索引：索引的第一个字段出现在筛选条件中，
+// 排序字段是索引中紧跟在筛选字段之后的连续字段
+func (g *Guardrails) checkIndex(mapper *FieldMapper, filterFields []string, sortFields []string) error {
+	if !g.RequireIndex || (len(filterFields) == 0 && len(sortFields) == 0) {
+		return nil
+	}
+
+	normalizedSort := make([]string, len(sortFields))
+	for i, field := range sortFields {
+		normalizedSort[i] = mapper.BsonName(strings.TrimLeft(field, "+-"))
+	}
+	normalizedFilter := make([]string, len(filterFields))
+	for i, field := range filterFields {
+		normalizedFilter[i] = mapper.BsonName(field)
+	}
+
+	// _id 上总有隐含的唯一索引
+	indexes := append([][]string{{"_id"}}, g.Indexes...)
+	for _, index := range indexes {
+		if len(index) == 0 {
+			continue
+		}
+		if len(normalizedFilter) > 0 && !contains(normalizedFilter, index[0]) {
+			continue
+		}
+
+		// 跳过索引中属于筛选条件的前缀，剩下的部分要以排序字段开头
+		i := 0
+		for i < len(index) && contains(normalizedFilter, index[i]) && !contains(normalizedSort, index[i]) {
+			i++
+		}
+		if len(normalizedSort) > 0 {
+			if len(index)-i < len(normalizedSort) {
+				continue
+			}
+			if !equalStrings(index[i:i+len(normalizedSort)], normalizedSort) {
+				continue
+			}
+		}
+		return nil
+	}
+
+	return &GuardrailError{Rule: "require_index", Detail: fmt.Sprintf("no index covers filter %v and sort %v", filterFields, sortFields)}
+}
+
+// operators 返回字段允许使用的操作符，Operators 的键按 bson 名比较
+func (g *Guardrails) operators(mapper *FieldMapper, field string) ([]string, bool) {
+	if allowed, ok := g.Operators[field]; ok {
+		return allowed, true
+	}
+	name := mapper.BsonName(field)
+	for key, allowed := range g.Operators {
+		if mapper.BsonName(key) == name {
+			return allowed, true
+		}
+	}
+	return nil, false
+}
+
+// containsField 按 bson 名比较字段，api 名和 bson 名写法都能匹配
+func containsField(mapper *FieldMapper, arr []string, field string) bool {
+	name := mapper.BsonName(field)
+	for _, v := range arr {
+		if v == field || mapper.BsonName(v) == name {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(arr []string, s string) bool {
+	for _, v := range arr {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,103 @@
+package query
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGuardrailsCheckFilter(t *testing.T) {
+	g := &Guardrails{
+		MaxDepth:         2,
+		MaxClauses:       2,
+		FilterableFields: []string{"name", "age"},
+		Operators:        map[string][]string{"name": {"eq", "in"}},
+	}
+
+	fields, err := g.checkFilter(nil, map[string]any{
+		"name": map[string]any{"eq": "a"},
+		"or": []any{
+			map[string]any{"age": map[string]any{"gt": 1}},
+		},
+	})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"name", "age"}, fields)
+
+	_, err = g.checkFilter(nil, map[string]any{"email": map[string]any{"eq": "a"}})
+	assert.True(t, errors.Is(err, ErrGuardrail))
+
+	_, err = g.checkFilter(nil, map[string]any{"name": map[string]any{"like": "a%"}})
+	assert.True(t, errors.Is(err, ErrGuardrail))
+
+	_, err = g.checkFilter(nil, map[string]any{
+		"or": []any{
+			map[string]any{"and": []any{map[string]any{"age": map[string]any{"gt": 1}}}},
+		},
+	})
+	assert.True(t, errors.Is(err, ErrGuardrail))
+}
+
+func TestGuardrailsCheckIndex(t *testing.T) {
+	g := &Guardrails{
+		RequireIndex: true,
+		Indexes:      [][]string{{"tenant", "created_at"}},
+	}
+
+	assert.NoError(t, g.checkIndex(nil, []string{"tenant"}, []string{"-created_at"}))
+	assert.NoError(t, g.checkIndex(nil, []string{"tenant"}, nil))
+	assert.Error(t, g.checkIndex(nil, []string{"created_at"}, nil))
+	assert.Error(t, g.checkIndex(nil, []string{"tenant"}, []string{"name"}))
+
+	// _id 索引是隐含的
+	assert.NoError(t, g.checkIndex(nil, []string{"id"}, nil))
+	assert.NoError(t, g.checkIndex(nil, nil, []string{"-id"}))
+}
+
+type guardedUser struct {
+	ID        string `json:"id" bson:"_id"`
+	CreatedAt int64  `json:"createdAt" bson:"created_at"`
+}
+
+func TestGuardrailsFieldMapping(t *testing.T) {
+	mapper := NewFieldMapper[guardedUser]()
+	g := &Guardrails{
+		SortableFields:   []string{"created_at"},
+		FilterableFields: []string{"createdAt"},
+		Operators:        map[string][]string{"created_at": {"gt"}},
+	}
+
+	// api 名和 bson 名都按 bson 名比较
+	assert.NoError(t, g.checkSort(mapper, []string{"-createdAt", "created_at"}))
+	assert.Error(t, g.checkSort(mapper, []string{"id"}))
+
+	_, err := g.checkFilter(mapper, map[string]any{"created_at": map[string]any{"gt": 1}})
+	assert.NoError(t, err)
+	_, err = g.checkFilter(mapper, map[string]any{"createdAt": map[string]any{"gt": 1}})
+	assert.NoError(t, err)
+	_, err = g.checkFilter(mapper, map[string]any{"createdAt": map[string]any{"lt": 1}})
+	assert.True(t, errors.Is(err, ErrGuardrail))
+}
+
+func TestGuardrailsCheckPagination(t *testing.T) {
+	g := &Guardrails{MaxLimit: 100, MaxSkip: 1000}
+	limit, skip := int64(200), int64(10)
+
+	assert.Error(t, g.checkPagination(&limit, &skip))
+	limit = 50
+	assert.NoError(t, g.checkPagination(&limit, &skip))
+
+	// 0 和负数在 mongo 中不受限制，没有指定时也不能绕过 MaxLimit
+	limit = 0
+	assert.Error(t, g.checkPagination(&limit, &skip))
+	limit = -1
+	assert.Error(t, g.checkPagination(&limit, &skip))
+	assert.Error(t, g.checkPagination(nil, nil))
+
+	// 没有指定时使用 DefaultLimit，DefaultLimit 为 0 时使用 MaxLimit
+	assert.Equal(t, int64(100), g.pageSize(0))
+	assert.Equal(t, int64(100), g.pageSize(-1))
+	assert.Equal(t, int64(50), g.pageSize(50))
+	g.DefaultLimit = 20
+	assert.Equal(t, int64(20), g.pageSize(0))
+}
@@ -1,8 +1,103 @@
 package query
 
-func getSchemaKey(key string) string {
-	if key == "id" {
-		return "_id"
+import (
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// isLogicalKey 判断筛选条件的 key 是不是 and/or/not 这样的逻辑组合
+func isLogicalKey(key string) bool {
+	return key == "and" || key == "or" || key == "not"
+}
+
+// PrefixFields 给筛选条件中所有的字段名加上前缀，操作符($and, $or ...)保持不变，
+// 用于把编译好的筛选条件应用到 change stream 的 fullDocument 等内嵌文档上
+func PrefixFields(filter bson.M, prefix string) bson.M {
+	if filter == nil {
+		return nil
+	}
+
+	r := bson.M{}
+	for k, v := range filter {
+		if strings.HasPrefix(k, "$") {
+			r[k] = prefixValue(v, prefix)
+			continue
+		}
+		r[prefix+k] = v
+	}
+	return r
+}
+
+func prefixValue(v any, prefix string) any {
+	switch t := v.(type) {
+	case bson.M:
+		return PrefixFields(t, prefix)
+	case map[string]any:
+		return PrefixFields(t, prefix)
+	case []bson.M:
+		r := make([]bson.M, len(t))
+		for i, m := range t {
+			r[i] = PrefixFields(m, prefix)
+		}
+		return r
+	case bson.A:
+		r := make(bson.A, len(t))
+		for i, e := range t {
+			r[i] = prefixValue(e, prefix)
+		}
+		return r
+	case []any:
+		r := make([]any, len(t))
+		for i, e := range t {
+			r[i] = prefixValue(e, prefix)
+		}
+		return r
+	}
+	return v
+}
+
+// toFilterList 把 and/or 的值转换为子条件列表，兼容 encoding/json 和 bson 解码出的类型
+func toFilterList(v any) ([]map[string]any, error) {
+	switch t := v.(type) {
+	case []map[string]any:
+		return t, nil
+	case []bson.M:
+		r := make([]map[string]any, len(t))
+		for i, m := range t {
+			r[i] = m
+		}
+		return r, nil
+	case []any:
+		return toFilterListFromSlice(t)
+	case bson.A:
+		return toFilterListFromSlice(t)
+	}
+	return nil, fmt.Errorf("invalid filter list %v, expected an array of filters", v)
+}
+
+func toFilterListFromSlice(arr []any) ([]map[string]any, error) {
+	r := make([]map[string]any, len(arr))
+	for i, e := range arr {
+		m, err := toFilterMap(e)
+		if err != nil {
+			return nil, err
+		}
+		r[i] = m
+	}
+	return r, nil
+}
+
+// toFilterMap 把筛选条件转换为 map[string]any，兼容 bson.M 和 bson.D
+func toFilterMap(v any) (map[string]any, error) {
+	switch t := v.(type) {
+	case map[string]any:
+		return t, nil
+	case bson.M:
+		return t, nil
+	case bson.D:
+		return t.Map(), nil
 	}
-	return key
+	return nil, fmt.Errorf("invalid filter %v, expected an object", v)
 }
@@ -0,0 +1,28 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrValidation = errors.New("invalid filter")
+
+// ValidationError 是严格校验模式下筛选条件的错误，Expected 是 schema 中字段的 bsonType
+type ValidationError struct {
+	Field    string
+	Operator string
+	Expected string
+	Value    any
+	Reason   string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Expected == "" {
+		return fmt.Sprintf("%s: field %s, operator %s: %s", ErrValidation, e.Field, e.Operator, e.Reason)
+	}
+	return fmt.Sprintf("%s: field %s, operator %s: %s, expected %s, got %v (%T)", ErrValidation, e.Field, e.Operator, e.Reason, e.Expected, e.Value, e.Value)
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
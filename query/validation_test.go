@@ -0,0 +1,104 @@
+package query
+
+import (
+	"errors"
+	"testing"
+
+	mongo_schema "github.com/duolacloud/crud-core-mongo/schema"
+	"github.com/duolacloud/crud-core/types"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type validationUser struct {
+	Name   string `bson:"name"`
+	Age    int64  `bson:"age"`
+	Active bool   `bson:"active"`
+}
+
+func newValidationBuilder(strict bool) *FilterQueryBuilder[validationUser] {
+	schema := mongo_schema.NewSchema(bson.M{
+		"$jsonSchema": bson.M{
+			"properties": bson.M{
+				"name":   bson.M{"bsonType": "string"},
+				"age":    bson.M{"bsonType": "long"},
+				"active": bson.M{"bsonType": "bool"},
+			},
+		},
+	})
+	return NewFilterQueryBuilder[validationUser](schema, strict)
+}
+
+func TestStrictValidation(t *testing.T) {
+	b := newValidationBuilder(true)
+
+	q, err := b.BuildQuery(&types.PageQuery{Filter: map[string]any{
+		"age":    map[string]any{"in": []any{"1", 2}},
+		"active": map[string]any{"eq": "true"},
+	}})
+	assert.NoError(t, err)
+	assert.Contains(t, q.FilterQuery["$and"].([]bson.M)[0]["$and"], bson.M{"age": bson.M{"$in": []any{int64(1), int64(2)}}})
+
+	_, err = b.BuildQuery(&types.PageQuery{Filter: map[string]any{
+		"email": map[string]any{"eq": "a"},
+	}})
+	var verr *ValidationError
+	assert.True(t, errors.As(err, &verr))
+	assert.Equal(t, "email", verr.Field)
+
+	_, err = b.BuildQuery(&types.PageQuery{Filter: map[string]any{
+		"age": map[string]any{"gt": "abc"},
+	}})
+	assert.True(t, errors.As(err, &verr))
+	assert.True(t, errors.Is(err, ErrValidation))
+	assert.Equal(t, "age", verr.Field)
+	assert.Equal(t, "gt", verr.Operator)
+	assert.Equal(t, "long", verr.Expected)
+	assert.Equal(t, "abc", verr.Value)
+}
+
+func TestLenientValidation(t *testing.T) {
+	b := newValidationBuilder(false)
+
+	_, err := b.BuildQuery(&types.PageQuery{Filter: map[string]any{
+		"email":  map[string]any{"eq": "a"},
+		"active": map[string]any{"eq": 1},
+	}})
+	assert.NoError(t, err)
+}
+
+type testEncrypter struct{}
+
+func (testEncrypter) Encrypted(field string) (bool, bool) {
+	switch field {
+	case "id_number":
+		return true, true
+	case "phone":
+		return true, false
+	}
+	return false, false
+}
+
+func (testEncrypter) EncryptValue(field string, val any) (any, error) {
+	return "enc:" + val.(string), nil
+}
+
+func TestEncryptedFieldFilter(t *testing.T) {
+	b := NewFilterQueryBuilder[validationUser](mongo_schema.NewSchema(nil), false, WithEncrypter(testEncrypter{}))
+
+	q, err := b.BuildQuery(&types.PageQuery{Filter: map[string]any{
+		"id_number": map[string]any{"in": []any{"a", "b"}},
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, bson.M{"$and": []bson.M{{"id_number": bson.M{"$in": []any{"enc:a", "enc:b"}}}}}, q.FilterQuery)
+
+	_, err = b.BuildQuery(&types.PageQuery{Filter: map[string]any{
+		"id_number": map[string]any{"gt": "a"},
+	}})
+	assert.ErrorIs(t, err, ErrValidation)
+
+	_, err = b.BuildQuery(&types.PageQuery{Filter: map[string]any{
+		"phone": map[string]any{"eq": "a"},
+	}})
+	assert.ErrorIs(t, err, ErrValidation)
+}
@@ -0,0 +1,290 @@
+package query
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/duolacloud/crud-core-mongo/ids"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// convertBsonValue 把筛选条件或游标中的值转换为 schema 中字段的 bsonType，
+// 无法转换时返回错误和原来的值，没有时区的日期按 loc 解析
+func convertBsonValue(bsonType string, val any, loc *time.Location) (any, error) {
+	// encoding/json 的 UseNumber 解码出的数字
+	if n, ok := val.(json.Number); ok {
+		val = n.String()
+	}
+
+	switch bsonType {
+	case "string":
+		if _, ok := val.(string); !ok {
+			return val, errors.New("value is not a string")
+		}
+		return val, nil
+	case "bool":
+		// 先看是不是默认就是 bool
+		if bv, ok := val.(bool); ok {
+			return bv, nil
+		}
+		sv, ok := val.(string)
+		if !ok {
+			return val, errors.New("value is not a bool")
+		}
+		bv, err := strconv.ParseBool(sv)
+		if err != nil {
+			return val, errors.New("value is not a bool")
+		}
+		return bv, nil
+	case "date", "timestamp":
+		// 已经是 bson 的时间类型，直接返回
+		switch dv := val.(type) {
+		case primitive.DateTime, primitive.Timestamp:
+			return dv, nil
+		}
+		dv, err := parseDate(val, loc)
+		if err != nil {
+			return val, err
+		}
+		return dv, nil
+	case "int", "long":
+		return convertInteger(bsonType, val)
+	case "double":
+		return convertDouble(val)
+	case "decimal":
+		return convertDecimal(val)
+	case "objectId":
+		return convertObjectID(val)
+	case "uuid":
+		v, err := ids.UUIDv4(ids.FormatBinary).Parse(val)
+		if err != nil {
+			return val, errors.New("value is not a UUID")
+		}
+		return v, nil
+	case "binData":
+		return convertBinary(val)
+	case "regex":
+		switch t := val.(type) {
+		case primitive.Regex:
+			return t, nil
+		case string:
+			return primitive.Regex{Pattern: t}, nil
+		}
+		return val, errors.New("value is not a regular expression")
+	}
+
+	return val, nil
+}
+
+// dateLayouts 是支持的日期格式，没有时区的格式按配置的时区解析
+var dateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// parseDate 解析日期，支持 dateLayouts 中的格式和 Unix 时间戳(秒或毫秒)
+func parseDate(val any, loc *time.Location) (time.Time, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	if n, ok := val.(json.Number); ok {
+		val = n.String()
+	}
+
+	switch t := val.(type) {
+	case time.Time:
+		return t, nil
+	case primitive.DateTime:
+		return t.Time(), nil
+	case string:
+		for _, layout := range dateLayouts {
+			if d, err := time.ParseInLocation(layout, t, loc); err == nil {
+				return d, nil
+			}
+		}
+		if n, err := strconv.ParseFloat(t, 64); err == nil {
+			return fromEpoch(n), nil
+		}
+		return time.Time{}, fmt.Errorf("value %q is not a date", t)
+	}
+
+	rv := reflect.ValueOf(val)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return fromEpoch(float64(rv.Int())), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return fromEpoch(float64(rv.Uint())), nil
+	case reflect.Float32, reflect.Float64:
+		return fromEpoch(rv.Float()), nil
+	}
+	return time.Time{}, errors.New("value is not a date")
+}
+
+// fromEpoch 绝对值大于 1e11 的时间戳按毫秒处理(1e11 秒在 5138 年)
+func fromEpoch(n float64) time.Time {
+	if math.Abs(n) >= 1e11 {
+		return time.UnixMilli(int64(n)).UTC()
+	}
+	sec, frac := math.Modf(n)
+	return time.Unix(int64(sec), int64(frac*1e9)).UTC()
+}
+
+func convertInteger(bsonType string, val any) (any, error) {
+	bitSize := 64
+	if bsonType == "int" {
+		bitSize = 32
+	}
+
+	var v int64
+	rv := reflect.ValueOf(val)
+	switch rv.Kind() {
+	case reflect.String:
+		var err error
+		v, err = strconv.ParseInt(rv.String(), 0, bitSize)
+		if err != nil {
+			return val, fmt.Errorf("value is not an %d bit integer", bitSize)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v = rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if rv.Uint() > math.MaxInt64 {
+			return val, fmt.Errorf("value is not an %d bit integer", bitSize)
+		}
+		v = int64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		// encoding/json 把数字解码为 float64，只接受整数值
+		f := rv.Float()
+		if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+			return val, fmt.Errorf("value is not an %d bit integer", bitSize)
+		}
+		v = int64(f)
+	default:
+		return val, errors.New("value is not a number")
+	}
+
+	if bitSize == 32 {
+		if v < math.MinInt32 || v > math.MaxInt32 {
+			return val, errors.New("value is not a 32 bit integer")
+		}
+		// retype 32 bit
+		return int32(v), nil
+	}
+	return v, nil
+}
+
+func convertDouble(val any) (any, error) {
+	rv := reflect.ValueOf(val)
+	switch rv.Kind() {
+	case reflect.String:
+		v, err := strconv.ParseFloat(rv.String(), 64)
+		if err != nil {
+			return val, errors.New("value is not a number")
+		}
+		return v, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	}
+	return val, errors.New("value is not a number")
+}
+
+// convertDecimal 转换为 Decimal128，字符串可以保留完整的精度
+func convertDecimal(val any) (any, error) {
+	var s string
+	rv := reflect.ValueOf(val)
+	switch rv.Kind() {
+	case reflect.String:
+		s = rv.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		s = strconv.FormatInt(rv.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		s = strconv.FormatUint(rv.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		s = strconv.FormatFloat(rv.Float(), 'f', -1, 64)
+	default:
+		if d, ok := val.(primitive.Decimal128); ok {
+			return d, nil
+		}
+		return val, errors.New("value is not a decimal")
+	}
+
+	d, err := primitive.ParseDecimal128(s)
+	if err != nil {
+		return val, errors.New("value is not a decimal")
+	}
+	return d, nil
+}
+
+func convertObjectID(val any) (any, error) {
+	switch t := val.(type) {
+	case primitive.ObjectID:
+		return t, nil
+	case string:
+		id, err := primitive.ObjectIDFromHex(t)
+		if err != nil {
+			return val, errors.New("value is not an ObjectID")
+		}
+		return id, nil
+	case []byte:
+		if len(t) == 12 {
+			var id primitive.ObjectID
+			copy(id[:], t)
+			return id, nil
+		}
+	}
+	return val, errors.New("value is not an ObjectID")
+}
+
+// convertBinary 字符串按 base64 解码
+func convertBinary(val any) (any, error) {
+	switch t := val.(type) {
+	case primitive.Binary:
+		return t, nil
+	case []byte:
+		return primitive.Binary{Data: t}, nil
+	case string:
+		data, err := base64.StdEncoding.DecodeString(t)
+		if err != nil {
+			return val, errors.New("value is not base64 encoded binary")
+		}
+		return primitive.Binary{Data: data}, nil
+	}
+	return val, errors.New("value is not binary")
+}
+
+// NormalizeCursorValue 把文档中的值转换为可以写入游标的形式，
+// BuildCursorQuery 会根据 schema 把它们转换回来
+func NormalizeCursorValue(v any) any {
+	switch t := v.(type) {
+	case primitive.ObjectID:
+		return t.Hex()
+	case primitive.Decimal128:
+		return t.String()
+	case primitive.DateTime:
+		return t.Time()
+	case primitive.Binary:
+		if t.Subtype == 0x04 && len(t.Data) == 16 {
+			var u ids.UUID
+			copy(u[:], t.Data)
+			return u.String()
+		}
+		return base64.StdEncoding.EncodeToString(t.Data)
+	case primitive.Regex:
+		return t.Pattern
+	}
+	return v
+}
@@ -0,0 +1,63 @@
+package query
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestConvertBsonValue(t *testing.T) {
+	d, err := convertBsonValue("decimal", "12345678901234567890.12", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "12345678901234567890.12", d.(primitive.Decimal128).String())
+
+	d, err = convertBsonValue("decimal", 1.5, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "1.5", d.(primitive.Decimal128).String())
+
+	id := primitive.NewObjectID()
+	v, err := convertBsonValue("objectId", id.Hex(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, id, v)
+
+	v, err = convertBsonValue("uuid", "0190b9a4-3c5e-7d3a-9a1b-2c3d4e5f6a7b", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, byte(0x04), v.(primitive.Binary).Subtype)
+
+	v, err = convertBsonValue("binData", "aGVsbG8=", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, primitive.Binary{Data: []byte("hello")}, v)
+
+	v, err = convertBsonValue("long", float64(42), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(42), v)
+
+	_, err = convertBsonValue("long", "99999999999999999999", nil)
+	assert.Error(t, err)
+
+	_, err = convertBsonValue("int", int64(1)<<40, nil)
+	assert.Error(t, err)
+
+	v, err = convertBsonValue("bool", "maybe", nil)
+	assert.Error(t, err)
+	assert.Equal(t, "maybe", v)
+}
+
+func TestNormalizeCursorValue(t *testing.T) {
+	for _, bsonType := range []string{"objectId", "decimal", "uuid"} {
+		var v any
+		switch bsonType {
+		case "objectId":
+			v = primitive.NewObjectID()
+		case "decimal":
+			v, _ = primitive.ParseDecimal128("10.25")
+		case "uuid":
+			v, _ = convertBsonValue("uuid", "0190b9a4-3c5e-7d3a-9a1b-2c3d4e5f6a7b", nil)
+		}
+
+		back, err := convertBsonValue(bsonType, NormalizeCursorValue(v), nil)
+		assert.NoError(t, err, bsonType)
+		assert.Equal(t, v, back, bsonType)
+	}
+}
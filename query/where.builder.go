@@ -1,6 +1,7 @@
 package query
 
 import(
+	"fmt"
 	"go.mongodb.org/mongo-driver/bson"
 	"github.com/duolacloud/crud-core/types"
 	mongo_schema "github.com/duolacloud/crud-core-mongo/schema"
@@ -10,40 +11,57 @@ type WhereBuilder [Entity any] struct {
 	comparisonBuilder *ComparisonBuilder[Entity]
 }
 
-func NewWhereBuilder[Entity any](schema *mongo_schema.Schema) *WhereBuilder[Entity] {
+func NewWhereBuilder[Entity any](schema *mongo_schema.Schema, opts ...FilterQueryBuilderOption) *WhereBuilder[Entity] {
 	return &WhereBuilder[Entity]{
-		comparisonBuilder: NewComparisonBuilder[Entity](DEFAULT_COMPARISON_MAP, schema),
+		comparisonBuilder: NewComparisonBuilder[Entity](DEFAULT_COMPARISON_MAP, schema, opts...),
 	}
 }
 
 func(b *WhereBuilder[Entity]) build(filter map[string]any) (bson.M, error) {
 	var ands []bson.M
 	var ors []bson.M
+	var nors []bson.M
 	filterQuery := bson.M{}
 
-	
 	if and, ok := filter["and"]; ok {
-		if andArr, ok := and.([]map[string]any); ok {
-			for _, f := range andArr {
-				o, err := b.build(f)
-				if err != nil {
-					return nil, err
-				}
-				ands = append(ands, o)
+		andArr, err := toFilterList(and)
+		if err != nil {
+			return nil, fmt.Errorf("and: %w", err)
+		}
+		for _, f := range andArr {
+			o, err := b.build(f)
+			if err != nil {
+				return nil, err
 			}
+			ands = append(ands, o)
 		}
 	}
 
 	if or, ok := filter["or"]; ok {
-		if orMap, ok := or.([]map[string]any); ok {
-			for _, f := range orMap {
-				o, err := b.build(f)
-				if err != nil {
-					return nil, err
-				}
-				ors = append(ors, o)
+		orArr, err := toFilterList(or)
+		if err != nil {
+			return nil, fmt.Errorf("or: %w", err)
+		}
+		for _, f := range orArr {
+			o, err := b.build(f)
+			if err != nil {
+				return nil, err
 			}
-		}	
+			ors = append(ors, o)
+		}
+	}
+
+	// not 的值是一个子条件，编译为 $nor
+	if not, ok := filter["not"]; ok {
+		notMap, err := toFilterMap(not)
+		if err != nil {
+			return nil, fmt.Errorf("not: %w", err)
+		}
+		o, err := b.build(notMap)
+		if err != nil {
+			return nil, err
+		}
+		nors = append(nors, o)
 	}
 
 	filterAnds, err := b.filterFields(filter)
@@ -63,17 +81,26 @@ func(b *WhereBuilder[Entity]) build(filter map[string]any) (bson.M, error) {
 		filterQuery["$or"] = ors
 	}
 
+	if len(nors) > 0 {
+		filterQuery["$nor"] = nors
+	}
+
 	return filterQuery, nil
 }
 
 func(b *WhereBuilder[Entity]) filterFields(filter map[string]any) (bson.M, error) {
 	var ands []bson.M
 	for field, cmp := range filter {
-		if field == "and" || field == "or" {
+		if isLogicalKey(field) {
 			continue
 		}
 
-		and, err := b.withFilterComparison(field, cmp.(map[string]any))
+		_cmp, err := toFilterMap(cmp)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", field, err)
+		}
+
+		and, err := b.withFilterComparison(field, _cmp)
 		if err != nil {
 			return nil, err
 		}
@@ -97,7 +124,7 @@ func(b *WhereBuilder[Entity]) withFilterComparison(
 	cmp map[string]any,
 ) (bson.M, error) {
 	var opts []types.FilterComparisonOperators
-	for key, _ := range cmp {
+	for key := range cmp {
 		opts = append(opts, types.FilterComparisonOperators(key))
 	}
 
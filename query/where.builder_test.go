@@ -0,0 +1,41 @@
+package query
+
+import (
+	"encoding/json"
+	"testing"
+
+	mongo_schema "github.com/duolacloud/crud-core-mongo/schema"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestWhereBuilderJSONFilter(t *testing.T) {
+	b := NewWhereBuilder[validationUser](mongo_schema.NewSchema(nil))
+
+	id := primitive.NewObjectID()
+	var filter map[string]any
+	err := json.Unmarshal([]byte(`{
+		"or": [{"name": {"eq": "a"}}, {"name": {"eq": "b"}}],
+		"not": {"id": {"in": ["`+id.Hex()+`"]}}
+	}`), &filter)
+	assert.NoError(t, err)
+
+	q, err := b.build(filter)
+	assert.NoError(t, err)
+	assert.Len(t, q["$or"], 2)
+	assert.Equal(t, []bson.M{{"$and": []bson.M{{"_id": bson.M{"$in": []any{id}}}}}}, q["$nor"])
+}
+
+func TestWhereBuilderInvalidFilter(t *testing.T) {
+	b := NewWhereBuilder[validationUser](mongo_schema.NewSchema(nil))
+
+	_, err := b.build(map[string]any{"name": "a"})
+	assert.Error(t, err)
+
+	_, err = b.build(map[string]any{"or": map[string]any{"name": map[string]any{"eq": "a"}}})
+	assert.Error(t, err)
+
+	_, err = b.build(map[string]any{"name": map[string]any{"in": "a"}})
+	assert.Error(t, err)
+}
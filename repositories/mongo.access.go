@@ -0,0 +1,99 @@
+package repositories
+
+import (
+	"context"
+	"strings"
+
+	"github.com/duolacloud/crud-core-mongo/query"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// FieldPolicy 根据调用方(如 context 中的用户或 API 客户端)返回字段访问规则，返回 nil 表示不限制
+type FieldPolicy func(c context.Context) *query.FieldAccess
+
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) fieldAccess(c context.Context) *query.FieldAccess {
+	if r.Options.FieldPolicy == nil {
+		return nil
+	}
+	return r.Options.FieldPolicy(c)
+}
+
+// redact 按字段访问规则删除或替换返回结果中的字段
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) redact(c context.Context, dtos ...*DTO) error {
+	access := r.fieldAccess(c)
+	if !access.Redacts() {
+		return nil
+	}
+
+	mapper := query.NewFieldMapper[DTO]()
+	for _, dto := range dtos {
+		if dto == nil {
+			continue
+		}
+
+		doc, err := marshalDocument(dto)
+		if err != nil {
+			return err
+		}
+
+		redactDocument(access, mapper, doc)
+
+		data, err := bson.Marshal(doc)
+		if err != nil {
+			return err
+		}
+
+		var redacted DTO
+		if err := bson.Unmarshal(data, &redacted); err != nil {
+			return err
+		}
+		*dto = redacted
+	}
+	return nil
+}
+
+// redactDocument 在 bson 文档上删除隐藏的字段并替换需要遮盖的字段
+func redactDocument(access *query.FieldAccess, mapper *query.FieldMapper, doc bson.M) {
+	for _, field := range access.Hidden {
+		removePath(doc, strings.Split(mapper.BsonName(field), "."))
+	}
+	for field, mask := range access.Masks {
+		mapPath(doc, strings.Split(mapper.BsonName(field), "."), mask)
+	}
+}
+
+// removePath 删除路径上的字段，经过数组时作用在每个元素上
+func removePath(v any, path []string) {
+	switch t := v.(type) {
+	case bson.M:
+		if len(path) == 1 {
+			delete(t, path[0])
+			return
+		}
+		removePath(t[path[0]], path[1:])
+	case bson.A:
+		for _, e := range t {
+			removePath(e, path)
+		}
+	}
+}
+
+// mapPath 替换路径上字段的值，经过数组时作用在每个元素上
+func mapPath(v any, path []string, fn func(v any) any) {
+	switch t := v.(type) {
+	case bson.M:
+		child, ok := t[path[0]]
+		if !ok {
+			return
+		}
+		if len(path) == 1 {
+			t[path[0]] = fn(child)
+			return
+		}
+		mapPath(child, path[1:], fn)
+	case bson.A:
+		for _, e := range t {
+			mapPath(e, path, fn)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package repositories
+
+import (
+	"context"
+	"testing"
+
+	"github.com/duolacloud/crud-core-mongo/audit"
+	"github.com/duolacloud/crud-core-mongo/query"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type accessContact struct {
+	Phone string `json:"phone" bson:"phone"`
+}
+
+type accessUser struct {
+	Name     string          `json:"name" bson:"name"`
+	Salary   int64           `json:"salary" bson:"salary"`
+	Contacts []accessContact `json:"contacts" bson:"contacts"`
+}
+
+func TestRedact(t *testing.T) {
+	r := NewMongoCrudRepository[accessUser, accessUser, accessUser](nil, nil, nil,
+		WithFieldPolicy(func(c context.Context) *query.FieldAccess {
+			return &query.FieldAccess{
+				Hidden: []string{"salary"},
+				Masks:  map[string]query.Mask{"contacts.phone": query.MaskString(3, 4)},
+			}
+		}),
+	)
+
+	dto := &accessUser{
+		Name:     "a",
+		Salary:   100,
+		Contacts: []accessContact{{Phone: "13800000000"}},
+	}
+	assert.NoError(t, r.redact(context.Background(), dto))
+	assert.Equal(t, &accessUser{
+		Name:     "a",
+		Contacts: []accessContact{{Phone: "138****0000"}},
+	}, dto)
+}
+
+func TestRedactChanges(t *testing.T) {
+	access := &query.FieldAccess{
+		Hidden: []string{"salary"},
+		Masks:  map[string]query.Mask{"contacts.phone": query.MaskString(3, 4)},
+	}
+
+	changes := redactChanges(access, query.NewFieldMapper[accessUser](), []audit.Change{
+		{Field: "contacts", Before: bson.A{bson.M{"phone": "13800000000"}}, After: bson.A{}},
+		{Field: "name", Before: "a", After: "b"},
+		{Field: "salary", Before: int64(100), After: int64(200)},
+	})
+	assert.Equal(t, []audit.Change{
+		{Field: "contacts", Before: bson.A{bson.M{"phone": "138****0000"}}, After: bson.A{}},
+		{Field: "name", Before: "a", After: "b"},
+	}, changes)
+}
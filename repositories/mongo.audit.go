@@ -0,0 +1,213 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/duolacloud/crud-core-mongo/audit"
+	"github.com/duolacloud/crud-core-mongo/query"
+	"github.com/duolacloud/crud-core/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// History 按时间顺序返回实体的变更记录，需要配置 WithAudit。
+// 只返回当前租户的记录；有行级限制时实体必须当前可见，已删除的实体返回 types.ErrNotFound。
+// 记录中的文档和变化按字段访问规则处理
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) History(c context.Context, id types.ID, opts ...audit.HistoryOption) ([]*audit.Record, error) {
+	if r.Options.Audit == nil {
+		return nil, errors.New("audit trail is not configured")
+	}
+
+	id, opts, err := r.auditQuery(c, id, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := r.Options.Audit.History(c, id, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	access := r.fieldAccess(c)
+	if !access.Redacts() {
+		return records, nil
+	}
+
+	mapper := query.NewFieldMapper[DTO]()
+	for _, record := range records {
+		if record.Before != nil {
+			redactDocument(access, mapper, record.Before)
+		}
+		if record.After != nil {
+			redactDocument(access, mapper, record.After)
+		}
+		record.Changes = redactChanges(access, mapper, record.Changes)
+	}
+	return records, nil
+}
+
+// StateAt 根据变更记录还原实体在 at 时刻的状态，访问限制和 History 相同
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) StateAt(c context.Context, id types.ID, at time.Time) (*DTO, error) {
+	if r.Options.Audit == nil {
+		return nil, errors.New("audit trail is not configured")
+	}
+
+	id, opts, err := r.auditQuery(c, id, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := r.Options.Audit.StateAt(c, id, at, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := bson.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+
+	var dto DTO
+	if err := bson.Unmarshal(data, &dto); err != nil {
+		return nil, err
+	}
+	if err := r.redact(c, &dto); err != nil {
+		return nil, err
+	}
+	return &dto, nil
+}
+
+// auditQuery 转换 id，检查行级限制，并按当前租户过滤审计记录
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) auditQuery(c context.Context, id types.ID, opts []audit.HistoryOption) (types.ID, []audit.HistoryOption, error) {
+	id, err := r.parseID(id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	filter, restricted, err := r.restricted(c, OperationGet, bson.M{"_id": id})
+	if err != nil {
+		return nil, nil, err
+	}
+	if restricted {
+		coll, err := r.collection(c)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = coll.FindOne(c, filter, options.FindOne().SetProjection(bson.M{"_id": 1})).Err()
+		if err != nil {
+			return nil, nil, wrapMongoError(err)
+		}
+	}
+
+	if r.Options.Tenancy != nil {
+		tenant, err := r.Options.Tenancy.Tenant(c)
+		if err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, audit.WithTenant(tenant))
+	}
+	return id, opts, nil
+}
+
+// redactChanges 按字段访问规则处理变化，隐藏的字段被删除
+func redactChanges(access *query.FieldAccess, mapper *query.FieldMapper, changes []audit.Change) []audit.Change {
+	var r []audit.Change
+	for _, change := range changes {
+		path := strings.Split(change.Field, ".")
+
+		before, hidden := redactValue(access, mapper, path, change.Before)
+		if hidden {
+			continue
+		}
+		after, _ := redactValue(access, mapper, path, change.After)
+
+		change.Before, change.After = before, after
+		r = append(r, change)
+	}
+	return r
+}
+
+// redactValue 把 v 放到 path 上组成文档再处理，字段被删除时返回 hidden
+func redactValue(access *query.FieldAccess, mapper *query.FieldMapper, path []string, v any) (_ any, hidden bool) {
+	doc := bson.M{}
+	parent := doc
+	for _, key := range path[:len(path)-1] {
+		child := bson.M{}
+		parent[key] = child
+		parent = child
+	}
+	parent[path[len(path)-1]] = v
+
+	redactDocument(access, mapper, doc)
+
+	var cur any = doc
+	for _, key := range path {
+		m, ok := cur.(bson.M)
+		if !ok {
+			return nil, true
+		}
+		if cur, ok = m[key]; !ok {
+			return nil, true
+		}
+	}
+	return cur, false
+}
+
+// auditSnapshot 在写操作的事务中读取文档的原始内容，作为审计记录修改前后的状态，
+// 前后状态来自同一个来源，DTO 中没有的字段(租户、服务端生成的字段等)不会被当成变化。
+// 未配置审计或文档不存在时返回 nil
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) auditSnapshot(c context.Context, id types.ID) (bson.M, error) {
+	if r.Options.Audit == nil {
+		return nil, nil
+	}
+
+	coll, err := r.collection(c)
+	if err != nil {
+		return nil, err
+	}
+
+	filter, err := r.scoped(c, bson.M{"_id": id})
+	if err != nil {
+		return nil, err
+	}
+
+	var doc bson.M
+	err = coll.FindOne(c, filter).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, wrapMongoError(err)
+	}
+	return doc, nil
+}
+
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) recordAudit(c context.Context, op audit.Operation, id any, before bson.M, after bson.M) error {
+	if r.Options.Audit == nil {
+		return nil
+	}
+
+	// 审计记录中不保存加密字段的明文和密文
+	if r.encryptor != nil {
+		if before != nil {
+			r.encryptor.RemoveFields(before)
+		}
+		if after != nil {
+			r.encryptor.RemoveFields(after)
+		}
+	}
+
+	var opts []audit.RecordOption
+	if r.Options.Tenancy != nil {
+		tenant, err := r.Options.Tenancy.Tenant(c)
+		if err != nil {
+			return err
+		}
+		opts = append(opts, audit.WithRecordTenant(tenant))
+	}
+	return r.Options.Audit.Record(c, op, id, before, after, opts...)
+}
@@ -0,0 +1,61 @@
+package repositories
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/duolacloud/crud-core-mongo/audit"
+	"github.com/duolacloud/crud-core-mongo/conformance"
+	"github.com/duolacloud/crud-core/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// 需要设置 MONGODB_URI，审计在事务中写入，服务端需要是副本集
+func TestAuditHistory(t *testing.T) {
+	db := conformance.MongoDatabase(t)
+	c := context.Background()
+
+	r := NewMongoCrudRepository[conformance.User, conformance.User, conformance.User](
+		db,
+		func(c context.Context) string {
+			return "users"
+		},
+		conformance.UserSchema,
+		WithAudit(audit.NewTrail(db.Collection("audit"), audit.WithMode(audit.ModeDiff))),
+	)
+
+	_, err := r.Create(c, &conformance.User{ID: "1", Name: "a", Country: "cn", Age: 1})
+	assert.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	created := time.Now()
+	time.Sleep(10 * time.Millisecond)
+
+	_, err = r.Update(c, "1", &conformance.User{Name: "b", Country: "cn", Age: 1})
+	assert.NoError(t, err)
+	updated := time.Now()
+
+	records, err := r.History(c, "1")
+	assert.NoError(t, err)
+	assert.Len(t, records, 2)
+	// 修改前后都来自数据库中的文档，只有真正变化的字段
+	var fields []string
+	for _, change := range records[1].Changes {
+		fields = append(fields, change.Field)
+	}
+	assert.Equal(t, []string{"name"}, fields)
+
+	state, err := r.StateAt(c, "1", created)
+	assert.NoError(t, err)
+	assert.Equal(t, "a", state.Name)
+
+	state, err = r.StateAt(c, "1", updated)
+	assert.NoError(t, err)
+	assert.Equal(t, "b", state.Name)
+
+	_, err = r.StateAt(c, "1", created.Add(-time.Hour))
+	assert.ErrorIs(t, err, types.ErrNotFound)
+
+	_, err = r.History(c, "2")
+	assert.NoError(t, err)
+}
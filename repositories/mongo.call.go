@@ -0,0 +1,163 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+)
+
+// CallOptions 是读写操作的一致性和超时设置，未设置的项使用数据库的默认值
+type CallOptions struct {
+	ReadPreference *readpref.ReadPref
+	ReadConcern    *readconcern.ReadConcern
+	WriteConcern   *writeconcern.WriteConcern
+	// MaxTime 是服务端执行的最长时间(maxTimeMS)
+	MaxTime *time.Duration
+	// Collation 用于查询、计数、聚合和更新的字符串比较规则，仓储级别的设置也会用于 EnsureIndexes
+	Collation *options.Collation
+	// Location 是筛选条件中没有时区的日期，以及 onDate 等日期操作符使用的时区
+	Location *time.Location
+}
+
+type CallOption func(*CallOptions)
+
+// WithReadPreference 指定读偏好，如 readpref.Secondary(readpref.WithMaxStaleness(d), readpref.WithTags(...))
+func WithReadPreference(rp *readpref.ReadPref) CallOption {
+	return func(o *CallOptions) {
+		o.ReadPreference = rp
+	}
+}
+
+func WithReadConcern(rc *readconcern.ReadConcern) CallOption {
+	return func(o *CallOptions) {
+		o.ReadConcern = rc
+	}
+}
+
+// WithReadConcernLevel 按级别(local, majority, linearizable, snapshot ...)指定读关注
+func WithReadConcernLevel(level string) CallOption {
+	return WithReadConcern(readconcern.New(readconcern.Level(level)))
+}
+
+func WithWriteConcern(wc *writeconcern.WriteConcern) CallOption {
+	return func(o *CallOptions) {
+		o.WriteConcern = wc
+	}
+}
+
+func WithMaxTime(d time.Duration) CallOption {
+	return func(o *CallOptions) {
+		o.MaxTime = &d
+	}
+}
+
+// WithCollation 指定字符串比较规则，如 &options.Collation{Locale: "en", Strength: 2} 忽略大小写
+func WithCollation(collation *options.Collation) CallOption {
+	return func(o *CallOptions) {
+		o.Collation = collation
+	}
+}
+
+// WithTimeZone 指定筛选条件中日期使用的时区，如 time.LoadLocation("Asia/Shanghai")
+func WithTimeZone(loc *time.Location) CallOption {
+	return func(o *CallOptions) {
+		o.Location = loc
+	}
+}
+
+// merge 用 o 中设置过的项覆盖 base
+func (base CallOptions) merge(o *CallOptions) CallOptions {
+	if o == nil {
+		return base
+	}
+	if o.ReadPreference != nil {
+		base.ReadPreference = o.ReadPreference
+	}
+	if o.ReadConcern != nil {
+		base.ReadConcern = o.ReadConcern
+	}
+	if o.WriteConcern != nil {
+		base.WriteConcern = o.WriteConcern
+	}
+	if o.MaxTime != nil {
+		base.MaxTime = o.MaxTime
+	}
+	if o.Collation != nil {
+		base.Collation = o.Collation
+	}
+	if o.Location != nil {
+		base.Location = o.Location
+	}
+	return base
+}
+
+type callOptionsKey struct{}
+
+// WithCallOptions 返回携带单次调用设置的 context，优先于仓储的默认设置
+func WithCallOptions(c context.Context, opts ...CallOption) context.Context {
+	callOptions := CallOptions{}
+	if parent, ok := c.Value(callOptionsKey{}).(*CallOptions); ok {
+		callOptions = *parent
+	}
+	for _, o := range opts {
+		o(&callOptions)
+	}
+	return context.WithValue(c, callOptionsKey{}, &callOptions)
+}
+
+// primary 返回读主节点的 context，写后的回读和事务中的读不能读从节点
+func primary(c context.Context) context.Context {
+	return WithCallOptions(c, WithReadPreference(readpref.Primary()))
+}
+
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) callOptions(c context.Context) CallOptions {
+	callOptions, _ := c.Value(callOptionsKey{}).(*CallOptions)
+	return r.Options.CallOptions.merge(callOptions)
+}
+
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) collectionOptions(c context.Context) *options.CollectionOptions {
+	callOptions := r.callOptions(c)
+
+	opts := options.Collection()
+	if callOptions.ReadPreference != nil {
+		opts.SetReadPreference(callOptions.ReadPreference)
+	}
+	if callOptions.ReadConcern != nil {
+		opts.SetReadConcern(callOptions.ReadConcern)
+	}
+	if callOptions.WriteConcern != nil {
+		opts.SetWriteConcern(callOptions.WriteConcern)
+	}
+	return opts
+}
+
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) maxTime(c context.Context) *time.Duration {
+	return r.callOptions(c).MaxTime
+}
+
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) collation(c context.Context) *options.Collation {
+	return r.callOptions(c).Collation
+}
+
+// WithSession 在开启因果一致性的会话中执行 fn，fn 内使用传入的 context 调用仓储方法，
+// 后面的读(包括读从节点)一定能读到前面的写
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) WithSession(c context.Context, fn func(c context.Context) error) error {
+	if mongo.SessionFromContext(c) != nil {
+		return fn(c)
+	}
+
+	session, err := r.DB.Client().StartSession(options.Session().SetCausalConsistency(true))
+	if err != nil {
+		return wrapMongoError(err)
+	}
+	defer session.EndSession(c)
+
+	return mongo.WithSession(c, session, func(sc mongo.SessionContext) error {
+		return fn(sc)
+	})
+}
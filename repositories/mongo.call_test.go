@@ -0,0 +1,20 @@
+package repositories
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+func TestPrimaryReadPreference(t *testing.T) {
+	r := NewMongoCrudRepository[accessUser, accessUser, accessUser](nil, nil, nil,
+		WithDefaultCallOptions(WithReadPreference(readpref.Secondary())),
+	)
+
+	c := WithCallOptions(context.Background(), WithReadPreference(readpref.SecondaryPreferred()))
+	assert.Equal(t, readpref.SecondaryPreferredMode, r.collectionOptions(c).ReadPreference.Mode())
+	assert.Equal(t, readpref.PrimaryMode, r.collectionOptions(primary(c)).ReadPreference.Mode())
+	assert.Equal(t, readpref.PrimaryMode, r.collectionOptions(primary(context.Background())).ReadPreference.Mode())
+}
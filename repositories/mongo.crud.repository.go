@@ -1,21 +1,48 @@
 package repositories
 
 import (
-	"bytes"
 	"context"
 	"errors"
-	"fmt"
-
+	"strings"
+	"time"
+
+	"github.com/duolacloud/crud-core-mongo/audit"
+	"github.com/duolacloud/crud-core-mongo/encryption"
+	"github.com/duolacloud/crud-core-mongo/ids"
+	"github.com/duolacloud/crud-core-mongo/logging"
+	"github.com/duolacloud/crud-core-mongo/outbox"
 	"github.com/duolacloud/crud-core-mongo/query"
 	mongo_schema "github.com/duolacloud/crud-core-mongo/schema"
+	"github.com/duolacloud/crud-core-mongo/sequence"
+	"github.com/duolacloud/crud-core-mongo/telemetry"
+	"github.com/duolacloud/crud-core-mongo/tenancy"
 	"github.com/duolacloud/crud-core/types"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type MongoCrudRepositoryOptions struct {
 	StrictValidation bool
+	Outbox           *outbox.Outbox
+	OutboxEvents     outbox.EventFactory
+	Audit            *audit.Trail
+	Timestamps       *TimestampFields
+	IDStrategy       ids.Strategy
+	Sequences        []*sequence.Field
+	Tenancy          *tenancy.Policy
+	CallOptions      CallOptions
+	Logger           logging.Logger
+	RedactedFields   []string
+	Tracer           telemetry.Tracer
+	Metrics          telemetry.Metrics
+	SlowQuery        *SlowQueryOptions
+	Guardrails       *query.Guardrails
+	Indexes          []mongo.IndexModel
+	EncryptionKeys   encryption.KeyProvider
+	FieldPolicy      FieldPolicy
+	RowPolicy        RowPolicy
 }
 
 type MongoCrudRepositoryOption func(*MongoCrudRepositoryOptions)
@@ -26,11 +53,139 @@ func WithStrictValidation(v bool) MongoCrudRepositoryOption {
 	}
 }
 
+// WithOutbox 写操作和 events 生成的事件在同一个事务内写入 outbox，需要副本集或分片集群
+func WithOutbox(ob *outbox.Outbox, events outbox.EventFactory) MongoCrudRepositoryOption {
+	return func(o *MongoCrudRepositoryOptions) {
+		o.Outbox = ob
+		o.OutboxEvents = events
+	}
+}
+
+// WithAudit 在 Create/Update/Delete 时把变更记录写入 trail，变更和记录在同一个事务中提交，需要副本集或分片集群
+func WithAudit(trail *audit.Trail) MongoCrudRepositoryOption {
+	return func(o *MongoCrudRepositoryOptions) {
+		o.Audit = trail
+	}
+}
+
+// WithTimestamps 由仓储维护创建/修改时间和操作人字段，DTO 不需要再实现 hook
+func WithTimestamps(fields TimestampFields) MongoCrudRepositoryOption {
+	return func(o *MongoCrudRepositoryOptions) {
+		o.Timestamps = &fields
+	}
+}
+
+// WithIDStrategy 指定 _id 的生成方式，同时用于转换 Get/Update/Delete 和筛选条件中的 id
+func WithIDStrategy(s ids.Strategy) MongoCrudRepositoryOption {
+	return func(o *MongoCrudRepositoryOptions) {
+		o.IDStrategy = s
+	}
+}
+
+// WithSequence 在 Create/CreateMany 时为字段分配序号，字段已有值时不会覆盖
+func WithSequence(field *sequence.Field) MongoCrudRepositoryOption {
+	return func(o *MongoCrudRepositoryOptions) {
+		o.Sequences = append(o.Sequences, field)
+	}
+}
+
+// WithTenancy 按租户隔离数据，见 tenancy.Policy
+func WithTenancy(p *tenancy.Policy) MongoCrudRepositoryOption {
+	return func(o *MongoCrudRepositoryOptions) {
+		o.Tenancy = p
+	}
+}
+
+// WithDefaultCallOptions 设置仓储默认的读偏好、读写关注和超时，单次调用可以通过 WithCallOptions 覆盖
+func WithDefaultCallOptions(opts ...CallOption) MongoCrudRepositoryOption {
+	return func(o *MongoCrudRepositoryOptions) {
+		for _, opt := range opts {
+			opt(&o.CallOptions)
+		}
+	}
+}
+
+// WithLogger 在 debug 级别记录每次操作编译后的查询、耗时和结果数量，可以传入 *slog.Logger
+func WithLogger(logger logging.Logger) MongoCrudRepositoryOption {
+	return func(o *MongoCrudRepositoryOptions) {
+		o.Logger = logger
+	}
+}
+
+// WithRedactedFields 指定日志中需要脱敏的字段
+func WithRedactedFields(fields ...string) MongoCrudRepositoryOption {
+	return func(o *MongoCrudRepositoryOptions) {
+		o.RedactedFields = append(o.RedactedFields, fields...)
+	}
+}
+
+// WithTracer 为每次操作创建 Span
+func WithTracer(tracer telemetry.Tracer) MongoCrudRepositoryOption {
+	return func(o *MongoCrudRepositoryOptions) {
+		o.Tracer = tracer
+	}
+}
+
+// WithMetrics 记录每次操作的次数、耗时和结果数量
+func WithMetrics(metrics telemetry.Metrics) MongoCrudRepositoryOption {
+	return func(o *MongoCrudRepositoryOptions) {
+		o.Metrics = metrics
+	}
+}
+
+// WithSlowQuery 查询超过 threshold 时执行 explain，把执行计划记录到日志并交给 report
+func WithSlowQuery(threshold time.Duration, report func(c context.Context, q *SlowQuery)) MongoCrudRepositoryOption {
+	return func(o *MongoCrudRepositoryOptions) {
+		o.SlowQuery = &SlowQueryOptions{
+			Threshold: threshold,
+			Report:    report,
+		}
+	}
+}
+
+// WithGuardrails 限制分页大小、筛选条件的复杂度和可用字段，见 query.Guardrails
+func WithGuardrails(g *query.Guardrails) MongoCrudRepositoryOption {
+	return func(o *MongoCrudRepositoryOptions) {
+		o.Guardrails = g
+	}
+}
+
+// WithIndexes 声明集合的索引，EnsureIndexes 会创建它们，Guardrails 没有指定 Indexes 时也用于索引检查
+func WithIndexes(indexes ...mongo.IndexModel) MongoCrudRepositoryOption {
+	return func(o *MongoCrudRepositoryOptions) {
+		o.Indexes = append(o.Indexes, indexes...)
+	}
+}
+
+// WithEncryption 在客户端加密 schema 中用 encrypt 标记的字段，读取时自动解密，
+// 审计记录中不保存加密的字段
+func WithEncryption(keys encryption.KeyProvider) MongoCrudRepositoryOption {
+	return func(o *MongoCrudRepositoryOptions) {
+		o.EncryptionKeys = keys
+	}
+}
+
+// WithFieldPolicy 按调用方限制可以筛选、排序和投影的字段，并删除或替换返回结果中的字段，
+// Pipeline 返回的原始结果和 change stream 事件中的 UpdatedFields 不做处理
+func WithFieldPolicy(p FieldPolicy) MongoCrudRepositoryOption {
+	return func(o *MongoCrudRepositoryOptions) {
+		o.FieldPolicy = p
+	}
+}
+
+// WithRowPolicy 按调用方限制可以读写的行，见 RowPolicy
+func WithRowPolicy(p RowPolicy) MongoCrudRepositoryOption {
+	return func(o *MongoCrudRepositoryOptions) {
+		o.RowPolicy = p
+	}
+}
+
 type MongoCrudRepository[DTO any, CreateDTO any, UpdateDTO any] struct {
 	DB           *mongo.Database
 	Collectioner mongo_schema.Collectioner
 	Schema       *mongo_schema.Schema
 	Options      *MongoCrudRepositoryOptions
+	encryptor    *encryption.Encryptor
 }
 
 func NewMongoCrudRepository[DTO any, CreateDTO any, UpdateDTO any](
@@ -50,63 +205,226 @@ func NewMongoCrudRepository[DTO any, CreateDTO any, UpdateDTO any](
 		o(r.Options)
 	}
 
+	if g := r.Options.Guardrails; g != nil && g.RequireIndex && len(g.Indexes) == 0 {
+		guardrails := *g
+		guardrails.Indexes = indexFields(r.Options.Indexes)
+		r.Options.Guardrails = &guardrails
+	}
+
+	if r.Options.EncryptionKeys != nil {
+		r.encryptor = newEncryptor(r.Options.EncryptionKeys, r.Schema.EncryptedFields)
+	}
+
 	return r
 }
 
-func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) Create(c context.Context, createDTO *CreateDTO, opts ...types.CreateOption) (*DTO, error) {
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) Create(c context.Context, createDTO *CreateDTO, opts ...types.CreateOption) (dto *DTO, err error) {
+	c, obs := r.observe(c, OperationCreate)
+	defer func() { obs.end(countOne(dto), err) }()
+
 	if hook, ok := any(createDTO).(BeforeCreateHook); ok {
 		hook.BeforeCreate()
 	}
-	res, err := r.DB.Collection(r.Collectioner(c)).InsertOne(c, createDTO)
+
+	doc, err := r.createDocument(c, createDTO)
 	if err != nil {
-		return nil, wrapMongoError(err)
+		return nil, err
+	}
+
+	err = r.write(c, func(c context.Context) error {
+		coll, err := r.collection(c)
+		if err != nil {
+			return err
+		}
+
+		res, err := coll.InsertOne(c, doc)
+		if err != nil {
+			return wrapMongoError(err)
+		}
+
+		dto, err = r.created(c, obs, res.InsertedID)
+		if err != nil {
+			return err
+		}
+
+		after, err := r.auditSnapshot(c, res.InsertedID)
+		if err != nil {
+			return err
+		}
+		if err := r.recordAudit(c, audit.OperationCreate, res.InsertedID, nil, after); err != nil {
+			return err
+		}
+		return r.appendOutbox(c, outbox.OperationCreate, res.InsertedID, dto)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := r.redact(c, dto); err != nil {
+		return nil, err
 	}
-	return r.Get(c, res.InsertedID)
+	return dto, nil
 }
 
-func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) CreateMany(c context.Context, items []*CreateDTO, opts ...types.CreateManyOption) ([]*DTO, error) {
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) CreateMany(c context.Context, items []*CreateDTO, opts ...types.CreateManyOption) (dtos []*DTO, err error) {
+	c, obs := r.observe(c, OperationCreateMany)
+	defer func() { obs.end(len(dtos), err) }()
+
 	_items := make([]interface{}, len(items))
 	for i, item := range items {
 		if hook, ok := any(item).(BeforeCreateHook); ok {
 			hook.BeforeCreate()
 		}
-		_items[i] = item
-	}
 
-	res, err := r.DB.Collection(r.Collectioner(c)).InsertMany(c, _items)
-	if err != nil {
-		return nil, wrapMongoError(err)
+		doc, err := r.createDocument(c, item)
+		if err != nil {
+			return nil, err
+		}
+		_items[i] = doc
 	}
 
-	filter := bson.M{
-		"_id": bson.M{
-			"$in": res.InsertedIDs,
-		},
-	}
+	err = r.write(c, func(c context.Context) error {
+		coll, err := r.collection(c)
+		if err != nil {
+			return err
+		}
 
-	var dtos []*DTO
-	cursor, err := r.DB.Collection(r.Collectioner(c)).Find(c, filter)
-	if err != nil {
-		return nil, wrapMongoError(err)
-	}
+		res, err := coll.InsertMany(c, _items)
+		if err != nil {
+			return wrapMongoError(err)
+		}
 
-	err = cursor.All(c, &dtos)
+		filter, err := r.scoped(c, bson.M{
+			"_id": bson.M{
+				"$in": res.InsertedIDs,
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		cursor, err := coll.Find(c, filter)
+		if err != nil {
+			return wrapMongoError(err)
+		}
+
+		var docs []*bson.Raw
+		err = decodeAll(c, r.encryptor, cursor, &docs)
+		if err != nil {
+			return wrapMongoError(err)
+		}
+
+		// $in 不保证返回顺序，按 _id 对应回插入的顺序，审计和事件才能拿到对应的数据
+		byID := make(map[string]bson.Raw, len(docs))
+		for _, doc := range docs {
+			id := doc.Lookup("_id")
+			byID[idKey(id.Type, id.Value)] = *doc
+		}
+
+		dtos = make([]*DTO, 0, len(res.InsertedIDs))
+		for _, id := range res.InsertedIDs {
+			t, data, err := bson.MarshalValue(id)
+			if err != nil {
+				return err
+			}
+			doc, ok := byID[idKey(t, data)]
+			if !ok {
+				continue
+			}
+
+			var dto DTO
+			if err := bson.Unmarshal(doc, &dto); err != nil {
+				return err
+			}
+			dtos = append(dtos, &dto)
+
+			var after bson.M
+			if r.Options.Audit != nil {
+				if err := bson.Unmarshal(doc, &after); err != nil {
+					return err
+				}
+			}
+			if err := r.recordAudit(c, audit.OperationCreate, id, nil, after); err != nil {
+				return err
+			}
+			if err := r.appendOutbox(c, outbox.OperationCreate, id, &dto); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, wrapMongoError(err)
+		return nil, err
+	}
+	if err := r.redact(c, dtos...); err != nil {
+		return nil, err
 	}
 	return dtos, nil
 }
 
-func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) Delete(c context.Context, id types.ID) error {
-	_, err := r.DB.Collection(r.Collectioner(c)).DeleteOne(c, bson.M{"_id": id})
-	return wrapMongoError(err)
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) Delete(c context.Context, id types.ID) (err error) {
+	var deleted int
+	c, obs := r.observe(c, OperationDelete)
+	defer func() { obs.end(deleted, err) }()
+
+	id, err = r.parseID(id)
+	if err != nil {
+		return err
+	}
+
+	return r.write(c, func(c context.Context) error {
+		before, err := r.auditSnapshot(c, id)
+		if err != nil {
+			return err
+		}
+
+		coll, err := r.collection(c)
+		if err != nil {
+			return err
+		}
+
+		filter, restricted, err := r.restricted(c, OperationDelete, bson.M{"_id": id})
+		if err != nil {
+			return err
+		}
+
+		obs.set("filter", filter)
+
+		res, err := coll.DeleteOne(c, filter)
+		if err != nil {
+			return wrapMongoError(err)
+		}
+		deleted = int(res.DeletedCount)
+
+		// 有行级限制时不区分不存在和无权访问
+		if restricted && res.DeletedCount == 0 {
+			return types.ErrNotFound
+		}
+
+		// 没有删除数据时不记录审计，也不发布删除事件
+		if res.DeletedCount == 0 {
+			return nil
+		}
+
+		if err := r.recordAudit(c, audit.OperationDelete, id, before, nil); err != nil {
+			return err
+		}
+		return r.appendOutbox(c, outbox.OperationDelete, id, nil)
+	})
 }
 
-func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) Update(c context.Context, id types.ID, updateDTO *UpdateDTO, opts ...types.UpdateOption) (*DTO, error) {
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) Update(c context.Context, id types.ID, updateDTO *UpdateDTO, opts ...types.UpdateOption) (dto *DTO, err error) {
+	c, obs := r.observe(c, OperationUpdate)
+	defer func() { obs.end(countOne(dto), err) }()
+
 	if hook, ok := any(updateDTO).(BeforeUpdateHook); ok {
 		hook.BeforeUpdate()
 	}
 
+	id, err = r.parseID(id)
+	if err != nil {
+		return nil, err
+	}
+
 	var _opts types.UpdateOptions
 	for _, o := range opts {
 		o(&_opts)
@@ -115,87 +433,251 @@ func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) Update(c context.Contex
 	mongo_opts := options.FindOneAndUpdateOptions{}
 	mongo_opts.SetUpsert(_opts.Upsert)
 	mongo_opts.SetReturnDocument(options.After)
+	if maxTime := r.maxTime(c); maxTime != nil {
+		mongo_opts.SetMaxTime(*maxTime)
+	}
+	if collation := r.collation(c); collation != nil {
+		mongo_opts.SetCollation(collation)
+	}
 
-	data, err := bson.Marshal(updateDTO)
+	mmap, err := marshalDocument(updateDTO)
 	if err != nil {
 		return nil, err
 	}
-	mmap := bson.M{}
-	err = bson.Unmarshal(data, mmap)
+	delete(mmap, "_id")
+
+	filter, restricted, err := r.restricted(c, OperationUpdate, bson.M{"_id": id})
 	if err != nil {
 		return nil, err
 	}
-	delete(mmap, "_id")
 
-	filter := bson.D{{"_id", id}}
-	update := bson.D{{"$set", mmap}}
+	update, err := r.updateDocument(c, mmap, _opts.Upsert)
+	if err != nil {
+		return nil, err
+	}
+	obs.set("filter", filter)
+	obs.set("update", update)
+
+	err = r.write(c, func(c context.Context) error {
+		before, err := r.auditSnapshot(c, id)
+		if err != nil {
+			return err
+		}
+
+		coll, err := r.collection(c)
+		if err != nil {
+			return err
+		}
+
+		err = r.decodeOne(c, coll.FindOneAndUpdate(c, filter, update, &mongo_opts), &dto)
+		// upsert 时其他租户或行级限制之外的行会导致插入重复的 _id，不能暴露这些行的存在
+		if (restricted || r.Options.Tenancy != nil) && _opts.Upsert && isDuplicateID(err) {
+			return types.ErrNotFound
+		}
+		if err != nil {
+			return wrapMongoError(err)
+		}
 
-	var dto *DTO
-	err = r.DB.Collection(r.Collectioner(c)).FindOneAndUpdate(c, filter, update, &mongo_opts).Decode(&dto)
+		after, err := r.auditSnapshot(c, id)
+		if err != nil {
+			return err
+		}
+		if err := r.recordAudit(c, audit.OperationUpdate, id, before, after); err != nil {
+			return err
+		}
+		return r.appendOutbox(c, outbox.OperationUpdate, id, dto)
+	})
 	if err != nil {
-		return nil, wrapMongoError(err)
+		return nil, err
+	}
+	if err := r.redact(c, dto); err != nil {
+		return nil, err
 	}
 	return dto, nil
 }
 
-func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) Get(c context.Context, id types.ID) (*DTO, error) {
-	var dto *DTO
-	filter := bson.D{{"_id", id}}
-	err := r.DB.Collection(r.Collectioner(c)).FindOne(c, filter).Decode(&dto)
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) Get(c context.Context, id types.ID) (dto *DTO, err error) {
+	c, obs := r.observe(c, OperationGet)
+	defer func() { obs.end(countOne(dto), err) }()
+
+	dto, err = r.get(c, obs, OperationGet, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.redact(c, dto); err != nil {
+		return nil, err
+	}
+	return dto, nil
+}
+
+// get 按行级限制读取未经字段访问规则处理的实体，查询条件记录在调用方的 obs 中
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) get(c context.Context, obs *observation, op Operation, id types.ID) (*DTO, error) {
+	id, err := r.parseID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	filter, _, err := r.restricted(c, op, bson.M{"_id": id})
+	if err != nil {
+		return nil, err
+	}
+	return r.findOne(c, obs, filter)
+}
+
+// created 读取刚插入的实体，只按租户限制，不经过行级限制：
+// 插入已经提交时，行级限制之外的数据也要返回给调用方，而不是报告 ErrNotFound
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) created(c context.Context, obs *observation, id any) (*DTO, error) {
+	filter, err := r.scoped(c, bson.M{"_id": id})
+	if err != nil {
+		return nil, err
+	}
+	return r.findOne(c, obs, filter)
+}
+
+// findOne 读取一个未经字段访问规则处理的实体，不产生单独的 Get 观测
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) findOne(c context.Context, obs *observation, filter bson.M) (dto *DTO, err error) {
+	coll, err := r.collection(c)
+	if err != nil {
+		return nil, err
+	}
+
+	findOneOptions := options.FindOne()
+	if maxTime := r.maxTime(c); maxTime != nil {
+		findOneOptions.SetMaxTime(*maxTime)
+	}
+
+	obs.set("filter", filter)
+
+	err = r.decodeOne(c, coll.FindOne(c, filter, findOneOptions), &dto)
 	if err != nil {
 		return nil, wrapMongoError(err)
 	}
 	return dto, nil
 }
 
-func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) Query(c context.Context, q *types.PageQuery) ([]*DTO, error) {
-	filterQueryBuilder := query.NewFilterQueryBuilder[DTO](r.Schema, r.Options.StrictValidation)
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) Query(c context.Context, q *types.PageQuery) (dtos []*DTO, err error) {
+	c, obs := r.observe(c, OperationQuery)
+	defer func() { obs.end(len(dtos), err) }()
+
+	filterQueryBuilder := r.newFilterQueryBuilder(c)
 
 	mq, err := filterQueryBuilder.BuildQuery(q)
 	if err != nil {
 		return nil, err
 	}
 
-	cursor, err := r.DB.Collection(r.Collectioner(c)).Find(c, mq.FilterQuery, mq.Options)
+	coll, err := r.collection(c)
 	if err != nil {
-		return nil, wrapMongoError(err)
+		return nil, err
+	}
+
+	filter, _, err := r.restricted(c, OperationQuery, mq.FilterQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	if maxTime := r.maxTime(c); maxTime != nil {
+		mq.Options.SetMaxTime(*maxTime)
+	}
+	if collation := r.collation(c); collation != nil {
+		mq.Options.SetCollation(collation)
 	}
 
-	var dtos []*DTO
+	obs.set("filter", filter)
+	obs.set("options", findOptionsAttrs(mq.Options))
 
-	err = cursor.All(c, &dtos)
+	cursor, err := coll.Find(c, filter, mq.Options)
 	if err != nil {
 		return nil, wrapMongoError(err)
 	}
 
+	err = decodeAll(c, r.encryptor, cursor, &dtos)
+	if err != nil {
+		return nil, wrapMongoError(err)
+	}
+
+	r.checkSlowQuery(obs, coll, filter, mq.Options)
+	if err := r.redact(c, dtos...); err != nil {
+		return nil, err
+	}
 	return dtos, nil
 }
 
-func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) QueryOne(c context.Context, filter map[string]any) (*DTO, error) {
-	filterQueryBuilder := query.NewFilterQueryBuilder[DTO](r.Schema, r.Options.StrictValidation)
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) QueryOne(c context.Context, filter map[string]any) (dto *DTO, err error) {
+	c, obs := r.observe(c, OperationQueryOne)
+	defer func() { obs.end(countOne(dto), err) }()
+
+	filterQueryBuilder := r.newFilterQueryBuilder(c)
 
 	mq, err := filterQueryBuilder.BuildQuery(&types.PageQuery{Filter: filter})
 	if err != nil {
 		return nil, err
 	}
 
-	var dto *DTO
-	err = r.DB.Collection(r.Collectioner(c)).FindOne(c, mq.FilterQuery).Decode(&dto)
+	coll, err := r.collection(c)
+	if err != nil {
+		return nil, err
+	}
+
+	scopedFilter, _, err := r.restricted(c, OperationQueryOne, mq.FilterQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	findOneOptions := options.FindOne()
+	if maxTime := r.maxTime(c); maxTime != nil {
+		findOneOptions.SetMaxTime(*maxTime)
+	}
+	if collation := r.collation(c); collation != nil {
+		findOneOptions.SetCollation(collation)
+	}
+
+	obs.set("filter", scopedFilter)
+
+	err = r.decodeOne(c, coll.FindOne(c, scopedFilter, findOneOptions), &dto)
+	r.checkSlowQuery(obs, coll, scopedFilter, options.Find().SetLimit(1).SetCollation(findOneOptions.Collation))
 	if err != nil {
 		return nil, wrapMongoError(err)
 	}
+	if err := r.redact(c, dto); err != nil {
+		return nil, err
+	}
 	return dto, nil
 }
 
-func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) Count(c context.Context, q *types.PageQuery) (int64, error) {
-	filterQueryBuilder := query.NewFilterQueryBuilder[DTO](r.Schema, r.Options.StrictValidation)
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) Count(c context.Context, q *types.PageQuery) (count int64, err error) {
+	c, obs := r.observe(c, OperationCount)
+	defer func() { obs.end(int(count), err) }()
+
+	filterQueryBuilder := r.newFilterQueryBuilder(c)
 
 	mq, err := filterQueryBuilder.BuildQuery(q)
 	if err != nil {
 		return 0, err
 	}
 
-	count, err := r.DB.Collection(r.Collectioner(c)).CountDocuments(c, mq.FilterQuery)
+	coll, err := r.collection(c)
+	if err != nil {
+		return 0, err
+	}
+
+	filter, _, err := r.restricted(c, OperationCount, mq.FilterQuery)
+	if err != nil {
+		return 0, err
+	}
+
+	countOptions := options.Count()
+	if maxTime := r.maxTime(c); maxTime != nil {
+		countOptions.SetMaxTime(*maxTime)
+	}
+	if collation := r.collation(c); collation != nil {
+		countOptions.SetCollation(collation)
+	}
+
+	obs.set("filter", filter)
+
+	count, err = coll.CountDocuments(c, filter, countOptions)
+	r.checkSlowQuery(obs, coll, filter, options.Find().SetCollation(countOptions.Collation))
 	return count, wrapMongoError(err)
 }
 
@@ -203,26 +685,47 @@ func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) Aggregate(
 	c context.Context,
 	filter map[string]any,
 	aggregateQuery *types.AggregateQuery,
-) ([]*types.AggregateResponse, error) {
-	filterQueryBuilder := query.NewFilterQueryBuilder[DTO](r.Schema, r.Options.StrictValidation)
+) (aggs []*types.AggregateResponse, err error) {
+	c, obs := r.observe(c, OperationAggregate)
+	defer func() { obs.end(len(aggs), err) }()
+
+	filterQueryBuilder := r.newFilterQueryBuilder(c)
 
 	mq, err := filterQueryBuilder.BuildAggregateQuery(aggregateQuery, filter)
 	if err != nil {
 		return nil, err
 	}
 
+	coll, err := r.collection(c)
+	if err != nil {
+		return nil, err
+	}
+
+	match, _, err := r.restricted(c, OperationAggregate, mq.FilterQuery)
+	if err != nil {
+		return nil, err
+	}
+
 	pipeline := mongo.Pipeline{
-		{{Key: "$match", Value: mq.FilterQuery}},
+		{{Key: "$match", Value: match}},
 		{{Key: "$group", Value: mq.Aggregate}},
 	}
 
-	fmt.Printf("xaggregate: %v\n", mq.Aggregate)
-
 	if mq.MongoQuery.Options.Sort != nil {
 		pipeline = append(pipeline, bson.D{{Key: "$sort", Value: mq.MongoQuery.Options.Sort}})
 	}
 
-	cursor, err := r.DB.Collection(r.Collectioner(c)).Aggregate(c, pipeline)
+	aggregateOptions := options.Aggregate()
+	if maxTime := r.maxTime(c); maxTime != nil {
+		aggregateOptions.SetMaxTime(*maxTime)
+	}
+	if collation := r.collation(c); collation != nil {
+		aggregateOptions.SetCollation(collation)
+	}
+
+	obs.set("pipeline", pipeline)
+
+	cursor, err := coll.Aggregate(c, pipeline, aggregateOptions)
 	if err != nil {
 		return nil, wrapMongoError(err)
 	}
@@ -233,91 +736,81 @@ func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) Aggregate(
 		return nil, wrapMongoError(err)
 	}
 
-	// fmt.Printf("r: %v\n", result)
 	return query.ConvertToAggregateResponse(result)
 }
 
-func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) CursorQuery(c context.Context, q *types.CursorQuery) ([]*DTO, *types.CursorExtra, error) {
-	filterQueryBuilder := query.NewFilterQueryBuilder[DTO](r.Schema, r.Options.StrictValidation)
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) CursorQuery(c context.Context, q *types.CursorQuery) (result []*DTO, extra *types.CursorExtra, err error) {
+	c, obs := r.observe(c, OperationCursorQuery)
+	defer func() { obs.end(len(result), err) }()
+
+	filterQueryBuilder := r.newFilterQueryBuilder(c)
 
 	mq, err := filterQueryBuilder.BuildCursorQuery(q)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	cursor, err := r.DB.Collection(r.Collectioner(c)).Find(c, mq.FilterQuery, mq.Options)
+	coll, err := r.collection(c)
 	if err != nil {
-		return nil, nil, wrapMongoError(err)
+		return nil, nil, err
 	}
 
-	var result []*DTO
+	filter, _, err := r.restricted(c, OperationCursorQuery, mq.FilterQuery)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	err = cursor.All(c, &result)
+	if maxTime := r.maxTime(c); maxTime != nil {
+		mq.Options.SetMaxTime(*maxTime)
+	}
+	if collation := r.collation(c); collation != nil {
+		mq.Options.SetCollation(collation)
+	}
+
+	obs.set("filter", filter)
+	obs.set("options", findOptionsAttrs(mq.Options))
+
+	cursor, err := coll.Find(c, filter, mq.Options)
 	if err != nil {
 		return nil, nil, wrapMongoError(err)
 	}
 
-	extra := &types.CursorExtra{}
+	err = decodeAll(c, r.encryptor, cursor, &result)
+	if err != nil {
+		return nil, nil, wrapMongoError(err)
+	}
+
+	r.checkSlowQuery(obs, coll, filter, mq.Options)
+
+	extra = &types.CursorExtra{}
 
 	if len(result) == 0 {
 		return nil, extra, nil
 	}
 
-	if len(result) == int(q.Limit+1) {
+	if len(result) == int(*mq.Options.Limit) {
 		extra.HasNext = true
 		extra.HasPrevious = true
 
 		result = result[0 : len(result)-1]
-		fmt.Printf("len(result) == q.Limit itemCount: %d, limit: %d\n", len(result), q.Limit)
 	}
-	fmt.Printf("fuck itemCount: %d\n", len(result))
-
-	toCursor := func(item *DTO) (string, error) {
-		/*
-			// 反射DTO类型
-			t := reflect.TypeOf(item)
-			if t.Kind() == reflect.Ptr {
-				t = t.Elem()
-			}*/
-
-		sortFieldValues := make([]any, len(q.Sort))
-		for i, sortField := range q.Sort {
-			if sortField[0:1] == "-" {
-				sortField = sortField[1:]
-			}
-
-			if sortField[0:1] == "+" {
-				sortField = sortField[1:]
-			}
-
-			if sortField == "id" {
-				sortField = "_id"
-			}
-
-			/*
-				_, ok := r.Schema.FieldTypes[sortField]
-				if !ok {
-					return "", errors.New(fmt.Sprintf("field %s not found", sortField))
-				}
-			*/
-			var m bson.M
-			bytes, _ := bson.Marshal(item)
-			_ = bson.Unmarshal(bytes, &m)
-
-			sortFieldValues[i] = m[sortField]
-		}
+	if len(result) == 0 {
+		return nil, extra, nil
+	}
 
-		cursor := &types.Cursor{
-			Value: sortFieldValues,
+	if mq.Reverse {
+		for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+			result[i], result[j] = result[j], result[i]
 		}
+	}
 
-		w := new(bytes.Buffer)
-		err = cursor.Marshal(w)
+	mapper := query.NewFieldMapper[DTO]()
+	toCursor := func(item *DTO) (string, error) {
+		m, err := marshalDocument(item)
 		if err != nil {
 			return "", err
 		}
-
-		return w.String(), nil
+		return query.EncodeCursor(m, q.Sort, mapper)
 	}
 
 	itemCount := len(result)
@@ -331,9 +824,82 @@ func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) CursorQuery(c context.C
 		return nil, nil, err
 	}
 
+	if err := r.redact(c, result...); err != nil {
+		return nil, nil, err
+	}
 	return result, extra, nil
 }
 
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) newFilterQueryBuilder(c context.Context) *query.FilterQueryBuilder[DTO] {
+	opts := r.filterQueryBuilderOptions(c)
+	if r.Options.Guardrails != nil {
+		opts = append(opts, query.WithGuardrails(r.Options.Guardrails))
+	}
+	if access := r.fieldAccess(c); access != nil {
+		opts = append(opts, query.WithFieldAccess(access))
+	}
+	return query.NewFilterQueryBuilder[DTO](r.Schema, r.Options.StrictValidation, opts...)
+}
+
+// filterQueryBuilderOptions 是编译筛选条件的基本设置，不包括针对调用方的限制
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) filterQueryBuilderOptions(c context.Context) []query.FilterQueryBuilderOption {
+	var opts []query.FilterQueryBuilderOption
+	if r.Options.IDStrategy != nil {
+		opts = append(opts, query.WithIDStrategy(r.Options.IDStrategy))
+	}
+	if loc := r.callOptions(c).Location; loc != nil {
+		opts = append(opts, query.WithLocation(loc))
+	}
+	if r.encryptor != nil {
+		opts = append(opts, query.WithEncrypter(&queryEncrypter{c: c, encryptor: r.encryptor}))
+	}
+	return opts
+}
+
+// parseID 使用配置的 ID 策略转换外部传入的 id
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) parseID(id types.ID) (types.ID, error) {
+	if r.Options.IDStrategy == nil {
+		return id, nil
+	}
+	return r.Options.IDStrategy.Parse(id)
+}
+
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) collection(c context.Context) (*mongo.Collection, error) {
+	db, name := r.DB, r.Collectioner(c)
+	if r.Options.Tenancy != nil {
+		var err error
+		db, name, err = r.Options.Tenancy.Locate(c, db, name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return db.Collection(name, r.collectionOptions(c)), nil
+}
+
+// idKey 用 bson 类型和编码后的值作为 _id 的 map key
+func idKey(t bsontype.Type, data []byte) string {
+	return string(append([]byte{byte(t)}, data...))
+}
+
+// marshalDocument 把结构体等编码后再解码为 bson.M
+func marshalDocument(v any) (bson.M, error) {
+	data, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	m := bson.M{}
+	err = bson.Unmarshal(data, &m)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// isDuplicateID 判断是否是 _id 冲突，其他唯一索引的冲突原样返回
+func isDuplicateID(err error) bool {
+	return mongo.IsDuplicateKeyError(err) && strings.Contains(err.Error(), "index: _id_ ")
+}
+
 func wrapMongoError(err error) error {
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
@@ -0,0 +1,165 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/duolacloud/crud-core-mongo/ids"
+	"github.com/duolacloud/crud-core-mongo/tenancy"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// TimestampFields 声明由仓储自动维护的字段，字段名为 bson 字段名，留空表示不维护
+type TimestampFields struct {
+	CreatedAt string
+	UpdatedAt string
+	CreatedBy string
+	UpdatedBy string
+	// Actor 从 context 中取出操作人，写入 CreatedBy/UpdatedBy
+	Actor func(c context.Context) any
+}
+
+func (f *TimestampFields) actor(c context.Context) any {
+	if f.Actor == nil {
+		return nil
+	}
+	return f.Actor(c)
+}
+
+// createDocument 把 CreateDTO 转换成要插入的文档，并补充仓储维护的字段
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) createDocument(c context.Context, createDTO *CreateDTO) (bson.M, error) {
+	doc, err := marshalDocument(createDTO)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.Options.IDStrategy != nil {
+		if ids.IsZero(doc["_id"]) {
+			id, err := r.Options.IDStrategy.Generate()
+			if err != nil {
+				return nil, err
+			}
+			if id != nil {
+				doc["_id"] = id
+			}
+		} else {
+			// 调用方指定的 _id 按 IDStrategy 转换后再插入，无法转换时在插入之前报错，
+			// 否则插入成功后读回时才会失败
+			id, err := r.Options.IDStrategy.Parse(doc["_id"])
+			if err != nil {
+				return nil, err
+			}
+			doc["_id"] = id
+		}
+	}
+
+	if r.Options.Tenancy != nil {
+		if err := r.Options.Tenancy.Stamp(c, doc); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	for _, field := range r.Options.Sequences {
+		if !ids.IsZero(doc[field.Name]) {
+			continue
+		}
+
+		v, err := field.Value(c, now)
+		if err != nil {
+			return nil, err
+		}
+		doc[field.Name] = v
+	}
+
+	if ts := r.Options.Timestamps; ts != nil {
+		actor := ts.actor(c)
+
+		if ts.CreatedAt != "" {
+			doc[ts.CreatedAt] = now
+		}
+		if ts.UpdatedAt != "" {
+			doc[ts.UpdatedAt] = now
+		}
+		if ts.CreatedBy != "" && actor != nil {
+			doc[ts.CreatedBy] = actor
+		}
+		if ts.UpdatedBy != "" && actor != nil {
+			doc[ts.UpdatedBy] = actor
+		}
+	}
+
+	if r.encryptor != nil {
+		if err := r.encryptor.EncryptDocument(c, doc); err != nil {
+			return nil, err
+		}
+	}
+
+	return doc, nil
+}
+
+// updateDocument 根据要修改的字段生成 update 语句，
+// 修改时间由服务端 $currentDate 生成，创建字段只在 upsert 插入时通过 $setOnInsert 写入
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) updateDocument(c context.Context, set bson.M, upsert bool) (bson.D, error) {
+	currentDate := bson.M{}
+	setOnInsert := bson.M{}
+
+	if r.encryptor != nil {
+		if err := r.encryptor.EncryptDocument(c, set); err != nil {
+			return nil, err
+		}
+	}
+
+	if ts := r.Options.Timestamps; ts != nil {
+		actor := ts.actor(c)
+
+		for _, field := range []string{ts.CreatedAt, ts.CreatedBy, ts.UpdatedAt, ts.UpdatedBy} {
+			if field != "" {
+				delete(set, field)
+			}
+		}
+
+		if ts.UpdatedAt != "" {
+			currentDate[ts.UpdatedAt] = true
+		}
+		if ts.UpdatedBy != "" && actor != nil {
+			set[ts.UpdatedBy] = actor
+		}
+
+		if upsert {
+			if ts.CreatedAt != "" {
+				setOnInsert[ts.CreatedAt] = time.Now()
+			}
+			if ts.CreatedBy != "" && actor != nil {
+				setOnInsert[ts.CreatedBy] = actor
+			}
+		}
+	}
+
+	// 租户字段不允许修改，upsert 插入时写入当前租户
+	if p := r.Options.Tenancy; p != nil && p.Mode == tenancy.ModeShared {
+		delete(set, p.Field)
+		if upsert {
+			if err := p.Stamp(c, setOnInsert); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if r.Options.Timestamps == nil && len(setOnInsert) == 0 {
+		return bson.D{{Key: "$set", Value: set}}, nil
+	}
+
+	update := bson.D{}
+	if len(set) > 0 {
+		update = append(update, bson.E{Key: "$set", Value: set})
+	}
+	if len(currentDate) > 0 {
+		update = append(update, bson.E{Key: "$currentDate", Value: currentDate})
+	}
+	if len(setOnInsert) > 0 {
+		update = append(update, bson.E{Key: "$setOnInsert", Value: setOnInsert})
+	}
+	return update, nil
+}
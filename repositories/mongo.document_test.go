@@ -0,0 +1,52 @@
+package repositories
+
+import (
+	"context"
+	"testing"
+
+	"github.com/duolacloud/crud-core-mongo/ids"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestUpdateDocumentTimestamps(t *testing.T) {
+	r := NewMongoCrudRepository[ownedDoc, ownedDoc, ownedDoc](nil, nil, nil, WithTimestamps(TimestampFields{
+		CreatedAt: "created_at",
+		UpdatedAt: "updated_at",
+		CreatedBy: "created_by",
+		UpdatedBy: "updated_by",
+	}))
+
+	// 客户端不能伪造仓储维护的字段，没有操作人时 updated_by 保持不变
+	update, err := r.updateDocument(context.Background(), bson.M{
+		"owner":      "a",
+		"created_at": "x",
+		"created_by": "x",
+		"updated_at": "x",
+		"updated_by": "x",
+	}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, bson.D{
+		{Key: "$set", Value: bson.M{"owner": "a"}},
+		{Key: "$currentDate", Value: bson.M{"updated_at": true}},
+	}, update)
+}
+
+func TestCreateDocumentID(t *testing.T) {
+	r := NewMongoCrudRepository[ownedDoc, ownedDoc, ownedDoc](nil, nil, nil, WithIDStrategy(ids.ObjectID()))
+	c := context.Background()
+
+	id := primitive.NewObjectID()
+	doc, err := r.createDocument(c, &ownedDoc{ID: id.Hex()})
+	assert.NoError(t, err)
+	assert.Equal(t, id, doc["_id"])
+
+	// 不符合 IDStrategy 的 _id 在插入之前被拒绝
+	_, err = r.createDocument(c, &ownedDoc{ID: "not-an-object-id"})
+	assert.Error(t, err)
+
+	doc, err = r.createDocument(c, &ownedDoc{})
+	assert.NoError(t, err)
+	assert.IsType(t, primitive.ObjectID{}, doc["_id"])
+}
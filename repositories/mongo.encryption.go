@@ -0,0 +1,83 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/duolacloud/crud-core-mongo/encryption"
+	"github.com/duolacloud/crud-core-mongo/query"
+	mongo_schema "github.com/duolacloud/crud-core-mongo/schema"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// newEncryptor 使用 schema 中 encrypt 标记的字段创建加密器
+func newEncryptor(keys encryption.KeyProvider, encryptedFields map[string]mongo_schema.EncryptedField) *encryption.Encryptor {
+	fields := make([]encryption.Field, 0, len(encryptedFields))
+	for path, f := range encryptedFields {
+		fields = append(fields, encryption.Field{
+			Path:      path,
+			Algorithm: encryption.ParseAlgorithm(f.Algorithm),
+			KeyID:     f.KeyID,
+		})
+	}
+	return encryption.New(keys, fields...)
+}
+
+// queryEncrypter 把加密器绑定到当前调用的 context 上，供 FilterQueryBuilder 加密筛选值
+type queryEncrypter struct {
+	c         context.Context
+	encryptor *encryption.Encryptor
+}
+
+var _ query.FieldEncrypter = (*queryEncrypter)(nil)
+
+func (e *queryEncrypter) Encrypted(field string) (bool, bool) {
+	f, ok := e.encryptor.Field(field)
+	return ok, f.Algorithm == encryption.Deterministic
+}
+
+func (e *queryEncrypter) EncryptValue(field string, val any) (any, error) {
+	f, _ := e.encryptor.Field(field)
+	return e.encryptor.Encrypt(e.c, f, val)
+}
+
+// decodeOne 解码单个结果，配置了加密时先解密
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) decodeOne(c context.Context, res *mongo.SingleResult, dto **DTO) error {
+	if r.encryptor == nil {
+		return res.Decode(dto)
+	}
+
+	raw, err := res.DecodeBytes()
+	if err != nil {
+		return err
+	}
+	raw, err = r.encryptor.DecryptRaw(c, raw)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(raw, dto)
+}
+
+// decodeAll 解码 cursor 中所有的结果，配置了加密时逐个解密
+func decodeAll[T any](c context.Context, encryptor *encryption.Encryptor, cursor *mongo.Cursor, results *[]*T) error {
+	if encryptor == nil {
+		return cursor.All(c, results)
+	}
+
+	defer cursor.Close(c)
+
+	*results = []*T{}
+	for cursor.Next(c) {
+		raw, err := encryptor.DecryptRaw(c, cursor.Current)
+		if err != nil {
+			return err
+		}
+
+		var v T
+		if err := bson.Unmarshal(raw, &v); err != nil {
+			return err
+		}
+		*results = append(*results, &v)
+	}
+	return cursor.Err()
+}
@@ -0,0 +1,330 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/duolacloud/crud-core/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ExplainResult 是 explain 的摘要，queryPlanner 模式下没有执行统计
+type ExplainResult struct {
+	// Stages 是获胜执行计划从上到下的阶段，如 [LIMIT FETCH IXSCAN]
+	Stages         []string
+	CollectionScan bool
+	IndexesUsed    []string
+	KeysExamined   int64
+	DocsExamined   int64
+	Returned       int64
+	ExecutionTime  time.Duration
+	WinningPlan    bson.M
+	Raw            bson.M
+}
+
+type SlowQuery struct {
+	Operation  Operation
+	Collection string
+	Duration   time.Duration
+	Filter     bson.M
+	Options    bson.M
+	Explain    *ExplainResult
+	// ExplainError 是执行 explain 失败的原因
+	ExplainError error
+}
+
+type SlowQueryOptions struct {
+	Threshold time.Duration
+	// Report 收到慢查询及其执行计划，为 nil 时只记录日志
+	Report func(c context.Context, q *SlowQuery)
+	// ExplainInterval 内同一种查询(忽略具体的值)只 explain 一次，默认 1 分钟
+	ExplainInterval time.Duration
+	// MaxExplains 是同时执行的 explain 数量，超过时只上报不 explain，默认 1
+	MaxExplains int
+
+	once      sync.Once
+	semaphore chan struct{}
+	mutex     sync.Mutex
+	explained map[string]time.Time
+}
+
+// maxExplainShapes 是记录的查询形状数量上限，超过时清空重新记录
+const maxExplainShapes = 1000
+
+// acquire 判断是否需要 explain 这个查询，需要时占用一个并发名额，调用方用 release 释放
+func (o *SlowQueryOptions) acquire(shape string, now time.Time) bool {
+	o.once.Do(func() {
+		n := o.MaxExplains
+		if n <= 0 {
+			n = 1
+		}
+		o.semaphore = make(chan struct{}, n)
+		o.explained = map[string]time.Time{}
+	})
+
+	interval := o.ExplainInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if last, ok := o.explained[shape]; ok && now.Sub(last) < interval {
+		return false
+	}
+
+	select {
+	case o.semaphore <- struct{}{}:
+	default:
+		return false
+	}
+
+	if len(o.explained) >= maxExplainShapes {
+		o.explained = map[string]time.Time{}
+	}
+	o.explained[shape] = now
+	return true
+}
+
+func (o *SlowQueryOptions) release() {
+	<-o.semaphore
+}
+
+// Explain 返回 PageQuery 编译后的查询的执行计划
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) Explain(c context.Context, q *types.PageQuery) (*ExplainResult, error) {
+	mq, err := r.newFilterQueryBuilder(c).BuildQuery(q)
+	if err != nil {
+		return nil, err
+	}
+
+	coll, err := r.collection(c)
+	if err != nil {
+		return nil, err
+	}
+
+	filter, _, err := r.restricted(c, OperationQuery, mq.FilterQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	if collation := r.collation(c); collation != nil {
+		mq.Options.SetCollation(collation)
+	}
+
+	return explainFind(c, coll, filter, mq.Options, "executionStats")
+}
+
+// checkSlowQuery 在操作超过阈值时异步执行 explain 并上报，不影响本次调用的耗时。
+// explain 使用 queryPlanner 模式，不会再执行一次查询；同一种查询在 ExplainInterval 内只 explain 一次，
+// 并发的 explain 数量不超过 MaxExplains，跳过 explain 时只上报耗时
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) checkSlowQuery(obs *observation, coll *mongo.Collection, filter bson.M, opts *options.FindOptions) {
+	slowQuery := r.Options.SlowQuery
+	if slowQuery == nil {
+		return
+	}
+
+	duration := time.Since(obs.start)
+	if duration < slowQuery.Threshold {
+		return
+	}
+
+	if opts == nil {
+		opts = options.Find()
+	}
+
+	q := &SlowQuery{
+		Operation:  obs.operation,
+		Collection: obs.collection,
+		Duration:   duration,
+		Filter:     filter,
+		Options:    findOptionsAttrs(opts),
+	}
+
+	explain := slowQuery.acquire(queryShape(obs.collection, filter, opts), time.Now())
+
+	go func() {
+		if explain {
+			defer slowQuery.release()
+
+			// 原 context 可能已经结束或处于事务中，explain 使用独立的 context
+			c, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			q.Explain, q.ExplainError = explainFind(c, coll, filter, opts, "queryPlanner")
+		}
+
+		args := []any{
+			"operation", q.Operation,
+			"collection", q.Collection,
+			"duration", q.Duration,
+		}
+		if q.Explain != nil {
+			args = append(args,
+				"collection_scan", q.Explain.CollectionScan,
+				"indexes_used", q.Explain.IndexesUsed,
+				"stages", q.Explain.Stages,
+			)
+		}
+		if q.ExplainError != nil {
+			args = append(args, "error", q.ExplainError)
+		}
+		obs.logger.WarnContext(obs.c, "mongo slow query", args...)
+
+		if slowQuery.Report != nil {
+			slowQuery.Report(obs.c, q)
+		}
+	}()
+}
+
+// queryShape 把筛选条件中的值替换为 ?，相同结构的查询得到相同的结果
+func queryShape(collection string, filter bson.M, opts *options.FindOptions) string {
+	return fmt.Sprint(collection, shapeOf(filter), opts.Sort)
+}
+
+func shapeOf(v any) any {
+	switch t := v.(type) {
+	case bson.M:
+		return shapeOf(map[string]any(t))
+	case map[string]any:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		d := make(bson.D, len(keys))
+		for i, k := range keys {
+			d[i] = bson.E{Key: k, Value: shapeOf(t[k])}
+		}
+		return d
+	case bson.D:
+		d := make(bson.D, len(t))
+		for i, e := range t {
+			d[i] = bson.E{Key: e.Key, Value: shapeOf(e.Value)}
+		}
+		return d
+	}
+
+	// $and/$or 中的条件保留结构，$in 等的值作为一个整体
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() != reflect.Uint8 {
+		var r bson.A
+		for i := 0; i < rv.Len(); i++ {
+			e := shapeOf(rv.Index(i).Interface())
+			if e == "?" {
+				return "?"
+			}
+			r = append(r, e)
+		}
+		return r
+	}
+	return "?"
+}
+
+func explainFind(c context.Context, coll *mongo.Collection, filter bson.M, opts *options.FindOptions, verbosity string) (*ExplainResult, error) {
+	find := bson.D{
+		{Key: "find", Value: coll.Name()},
+		{Key: "filter", Value: filter},
+	}
+	if opts.Sort != nil {
+		find = append(find, bson.E{Key: "sort", Value: opts.Sort})
+	}
+	if opts.Projection != nil {
+		find = append(find, bson.E{Key: "projection", Value: opts.Projection})
+	}
+	if opts.Skip != nil {
+		find = append(find, bson.E{Key: "skip", Value: *opts.Skip})
+	}
+	if opts.Limit != nil {
+		find = append(find, bson.E{Key: "limit", Value: *opts.Limit})
+	}
+	if opts.Collation != nil {
+		find = append(find, bson.E{Key: "collation", Value: opts.Collation.ToDocument()})
+	}
+
+	cmd := bson.D{
+		{Key: "explain", Value: find},
+		{Key: "verbosity", Value: verbosity},
+	}
+
+	var raw bson.M
+	err := coll.Database().RunCommand(c, cmd).Decode(&raw)
+	if err != nil {
+		return nil, wrapMongoError(err)
+	}
+	return parseExplain(raw), nil
+}
+
+func parseExplain(raw bson.M) *ExplainResult {
+	result := &ExplainResult{
+		Raw: raw,
+	}
+
+	if planner, ok := raw["queryPlanner"].(bson.M); ok {
+		if plan, ok := planner["winningPlan"].(bson.M); ok {
+			// 6.0 以后使用 SBE 引擎时，计划在 queryPlan 下面
+			if queryPlan, ok := plan["queryPlan"].(bson.M); ok {
+				plan = queryPlan
+			}
+			result.WinningPlan = plan
+			walkPlan(plan, result)
+		}
+	}
+
+	if stats, ok := raw["executionStats"].(bson.M); ok {
+		result.KeysExamined = toInt64(stats["totalKeysExamined"])
+		result.DocsExamined = toInt64(stats["totalDocsExamined"])
+		result.Returned = toInt64(stats["nReturned"])
+		result.ExecutionTime = time.Duration(toInt64(stats["executionTimeMillis"])) * time.Millisecond
+	}
+
+	return result
+}
+
+func walkPlan(plan bson.M, result *ExplainResult) {
+	stage, _ := plan["stage"].(string)
+	if stage != "" {
+		result.Stages = append(result.Stages, stage)
+	}
+
+	switch stage {
+	case "COLLSCAN":
+		result.CollectionScan = true
+	case "IXSCAN", "DISTINCT_SCAN", "COUNT_SCAN":
+		if indexName, ok := plan["indexName"].(string); ok {
+			result.IndexesUsed = append(result.IndexesUsed, indexName)
+		}
+	}
+
+	if input, ok := plan["inputStage"].(bson.M); ok {
+		walkPlan(input, result)
+	}
+	if inputs, ok := plan["inputStages"].(bson.A); ok {
+		for _, input := range inputs {
+			if input, ok := input.(bson.M); ok {
+				walkPlan(input, result)
+			}
+		}
+	}
+}
+
+func toInt64(v any) int64 {
+	switch t := v.(type) {
+	case int32:
+		return int64(t)
+	case int64:
+		return t
+	case float64:
+		return int64(t)
+	case int:
+		return int64(t)
+	}
+	return 0
+}
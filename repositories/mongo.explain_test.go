@@ -0,0 +1,78 @@
+package repositories
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestParseExplain(t *testing.T) {
+	raw := bson.M{
+		"queryPlanner": bson.M{
+			"winningPlan": bson.M{
+				"stage": "LIMIT",
+				"inputStage": bson.M{
+					"stage": "FETCH",
+					"inputStage": bson.M{
+						"stage":     "IXSCAN",
+						"indexName": "age_1",
+					},
+				},
+			},
+		},
+		"executionStats": bson.M{
+			"nReturned":           int32(1),
+			"totalKeysExamined":   int32(3),
+			"totalDocsExamined":   int32(2),
+			"executionTimeMillis": int32(5),
+		},
+	}
+
+	result := parseExplain(raw)
+	assert.Equal(t, []string{"LIMIT", "FETCH", "IXSCAN"}, result.Stages)
+	assert.False(t, result.CollectionScan)
+	assert.Equal(t, []string{"age_1"}, result.IndexesUsed)
+	assert.Equal(t, int64(3), result.KeysExamined)
+	assert.Equal(t, int64(2), result.DocsExamined)
+	assert.Equal(t, int64(1), result.Returned)
+	assert.Equal(t, 5*time.Millisecond, result.ExecutionTime)
+
+	result = parseExplain(bson.M{
+		"queryPlanner": bson.M{
+			"winningPlan": bson.M{
+				"queryPlan": bson.M{"stage": "COLLSCAN"},
+			},
+		},
+	})
+	assert.True(t, result.CollectionScan)
+}
+
+func TestQueryShape(t *testing.T) {
+	opts := options.Find().SetSort(bson.D{{Key: "age", Value: -1}})
+
+	a := queryShape("users", bson.M{"$and": []bson.M{{"age": bson.M{"$gt": 18}}, {"name": bson.M{"$in": []any{"a", "b"}}}}}, opts)
+	b := queryShape("users", bson.M{"$and": []bson.M{{"age": bson.M{"$gt": 30}}, {"name": bson.M{"$in": []any{"c"}}}}}, opts)
+	assert.Equal(t, a, b)
+	assert.NotEqual(t, a, queryShape("users", bson.M{"age": bson.M{"$lt": 18}}, opts))
+	assert.NotEqual(t, a, queryShape("users", bson.M{"$and": []bson.M{{"age": bson.M{"$gt": 18}}, {"name": bson.M{"$in": []any{"a"}}}}}, options.Find()))
+}
+
+func TestSlowQueryAcquire(t *testing.T) {
+	o := &SlowQueryOptions{ExplainInterval: time.Minute, MaxExplains: 1}
+	now := time.Now()
+
+	assert.True(t, o.acquire("a", now))
+	// 并发名额用完
+	assert.False(t, o.acquire("b", now))
+	o.release()
+
+	// 同一种查询在间隔内只 explain 一次
+	assert.False(t, o.acquire("a", now.Add(time.Second)))
+	assert.True(t, o.acquire("b", now))
+	o.release()
+	assert.True(t, o.acquire("a", now.Add(2*time.Minute)))
+	o.release()
+}
@@ -0,0 +1,68 @@
+package repositories
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EnsureIndexes 创建 WithIndexes 声明的索引，已存在的同名同定义索引会被忽略，
+// 没有指定 collation 的索引使用仓储默认的 collation，使带 collation 的查询可以使用索引
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) EnsureIndexes(c context.Context) error {
+	if len(r.Options.Indexes) == 0 {
+		return nil
+	}
+
+	coll, err := r.collection(c)
+	if err != nil {
+		return err
+	}
+
+	models := r.Options.Indexes
+	if collation := r.Options.CallOptions.Collation; collation != nil {
+		models = make([]mongo.IndexModel, len(r.Options.Indexes))
+		for i, model := range r.Options.Indexes {
+			if model.Options == nil || model.Options.Collation == nil {
+				opts := options.Index()
+				if model.Options != nil {
+					o := *model.Options
+					opts = &o
+				}
+				model.Options = opts.SetCollation(collation)
+			}
+			models[i] = model
+		}
+	}
+
+	_, err = coll.Indexes().CreateMany(c, models)
+	return wrapMongoError(err)
+}
+
+// indexFields 返回声明的索引的字段列表，用于查询的索引检查
+func indexFields(models []mongo.IndexModel) [][]string {
+	var indexes [][]string
+	for _, model := range models {
+		var fields []string
+		switch keys := model.Keys.(type) {
+		case bson.D:
+			for _, e := range keys {
+				fields = append(fields, e.Key)
+			}
+		case bson.M:
+			// bson.M 无序，只有单字段索引有意义
+			for k := range keys {
+				fields = append(fields, k)
+			}
+		case map[string]any:
+			for k := range keys {
+				fields = append(fields, k)
+			}
+		}
+		if len(fields) > 0 {
+			indexes = append(indexes, fields)
+		}
+	}
+	return indexes
+}
@@ -0,0 +1,138 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/duolacloud/crud-core-mongo/logging"
+	"github.com/duolacloud/crud-core-mongo/telemetry"
+	"github.com/duolacloud/crud-core/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type Operation string
+
+const (
+	OperationCreate      Operation = "create"
+	OperationCreateMany  Operation = "create_many"
+	OperationGet         Operation = "get"
+	OperationQuery       Operation = "query"
+	OperationQueryOne    Operation = "query_one"
+	OperationCount       Operation = "count"
+	OperationAggregate   Operation = "aggregate"
+	OperationCursorQuery Operation = "cursor_query"
+	OperationUpdate      Operation = "update"
+	OperationDelete      Operation = "delete"
+	OperationPipeline    Operation = "pipeline"
+)
+
+// observation 记录一次仓储操作的耗时、编译后的查询和结果数量，并上报 Span 和指标
+type observation struct {
+	c          context.Context
+	logger     logging.Logger
+	redact     []string
+	span       telemetry.Span
+	metrics    telemetry.Metrics
+	operation  Operation
+	collection string
+	start      time.Time
+	args       []any
+}
+
+// observe 开始一次操作，返回的 context 带有 Span，内部调用需要使用它
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) observe(c context.Context, op Operation) (context.Context, *observation) {
+	logger := r.Options.Logger
+	if logger == nil {
+		logger = logging.Nop()
+	}
+
+	tracer := r.Options.Tracer
+	if tracer == nil {
+		tracer = telemetry.NopTracer()
+	}
+
+	metrics := r.Options.Metrics
+	if metrics == nil {
+		metrics = telemetry.NopMetrics()
+	}
+
+	collection := r.Collectioner(c)
+	c, span := tracer.Start(c, "mongo."+string(op), telemetry.Attributes{
+		"db.system":             "mongodb",
+		"db.operation":          string(op),
+		"db.mongodb.collection": collection,
+	})
+
+	return c, &observation{
+		c:          c,
+		logger:     logger,
+		redact:     r.Options.RedactedFields,
+		span:       span,
+		metrics:    metrics,
+		operation:  op,
+		collection: collection,
+		start:      time.Now(),
+	}
+}
+
+// set 记录查询条件等信息，日志中敏感字段会被脱敏，Span 中只保留去掉值的结构
+func (o *observation) set(key string, value any) {
+	o.args = append(o.args, key, logging.Redact(value, o.redact))
+	o.span.SetAttributes(telemetry.Attributes{
+		"db.mongodb." + key: fmt.Sprint(telemetry.Shape(value)),
+	})
+}
+
+func (o *observation) end(count int, err error) {
+	duration := time.Since(o.start)
+
+	o.span.SetAttributes(telemetry.Attributes{
+		"db.response.count": count,
+	})
+	o.span.End(err)
+	o.metrics.RecordOperation(o.c, string(o.operation), o.collection, duration, count, err)
+
+	args := append([]any{
+		"operation", o.operation,
+		"collection", o.collection,
+		"duration", duration,
+		"count", count,
+	}, o.args...)
+
+	if err != nil && !errors.Is(err, types.ErrNotFound) {
+		o.logger.ErrorContext(o.c, "mongo repository operation failed", append(args, "error", err)...)
+		return
+	}
+	o.logger.DebugContext(o.c, "mongo repository operation", args...)
+}
+
+// findOptionsAttrs 取出 find 的排序、投影和分页参数用于记录
+func findOptionsAttrs(opts *options.FindOptions) bson.M {
+	attrs := bson.M{}
+	if opts.Sort != nil {
+		attrs["sort"] = opts.Sort
+	}
+	if opts.Projection != nil {
+		attrs["projection"] = opts.Projection
+	}
+	if opts.Skip != nil {
+		attrs["skip"] = *opts.Skip
+	}
+	if opts.Limit != nil {
+		attrs["limit"] = *opts.Limit
+	}
+	if opts.Collation != nil {
+		attrs["collation"] = opts.Collation.ToDocument()
+	}
+	return attrs
+}
+
+func countOne[T any](v *T) int {
+	if v == nil {
+		return 0
+	}
+	return 1
+}
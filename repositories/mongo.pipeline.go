@@ -0,0 +1,210 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/duolacloud/crud-core/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrPipelineStage 表示有租户或行级限制时，pipeline 中包含了能绕过限制的阶段
+var ErrPipelineStage = errors.New("pipeline stage is not allowed")
+
+type PipelineOptions struct {
+	// Filter 是 crud-core 格式的筛选条件，编译后作为 $match 放在 pipeline 最前面
+	Filter       map[string]any
+	AllowDiskUse *bool
+	MaxTime      *time.Duration
+	Hint         any
+	Collation    *options.Collation
+	BatchSize    *int32
+}
+
+type PipelineOption func(*PipelineOptions)
+
+func WithPipelineFilter(filter map[string]any) PipelineOption {
+	return func(o *PipelineOptions) {
+		o.Filter = filter
+	}
+}
+
+func WithPipelineAllowDiskUse(v bool) PipelineOption {
+	return func(o *PipelineOptions) {
+		o.AllowDiskUse = &v
+	}
+}
+
+func WithPipelineMaxTime(d time.Duration) PipelineOption {
+	return func(o *PipelineOptions) {
+		o.MaxTime = &d
+	}
+}
+
+func WithPipelineHint(hint any) PipelineOption {
+	return func(o *PipelineOptions) {
+		o.Hint = hint
+	}
+}
+
+func WithPipelineCollation(collation *options.Collation) PipelineOption {
+	return func(o *PipelineOptions) {
+		o.Collation = collation
+	}
+}
+
+func WithPipelineBatchSize(v int32) PipelineOption {
+	return func(o *PipelineOptions) {
+		o.BatchSize = &v
+	}
+}
+
+// Pipeline 在仓储的集合上执行调用方提供的聚合管道，内置的筛选和聚合不够用时使用
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) Pipeline(c context.Context, pipeline mongo.Pipeline, opts ...PipelineOption) (cursor *mongo.Cursor, err error) {
+	c, obs := r.observe(c, OperationPipeline)
+	defer func() { obs.end(0, err) }()
+
+	var _opts PipelineOptions
+	for _, o := range opts {
+		o(&_opts)
+	}
+
+	coll, err := r.collection(c)
+	if err != nil {
+		return nil, err
+	}
+
+	// 租户和行级条件始终放在最前面，只能限制本集合的输入文档，
+	// 读取其他集合或写入的阶段不受限制，所以有限制时拒绝这些阶段
+	scope, _, err := r.restricted(c, OperationPipeline, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(scope) > 0 {
+		if err := checkPipeline(pipeline); err != nil {
+			return nil, err
+		}
+	}
+
+	stages := mongo.Pipeline{}
+	if len(scope) > 0 {
+		stages = append(stages, bson.D{{Key: "$match", Value: scope}})
+	}
+	if _opts.Filter != nil {
+		filterQueryBuilder := r.newFilterQueryBuilder(c)
+
+		mq, err := filterQueryBuilder.BuildQuery(&types.PageQuery{Filter: _opts.Filter})
+		if err != nil {
+			return nil, err
+		}
+
+		stages = append(stages, bson.D{{Key: "$match", Value: mq.FilterQuery}})
+	}
+	stages = append(stages, pipeline...)
+
+	mongo_opts := options.Aggregate()
+	if _opts.AllowDiskUse != nil {
+		mongo_opts.SetAllowDiskUse(*_opts.AllowDiskUse)
+	}
+	if _opts.MaxTime != nil {
+		mongo_opts.SetMaxTime(*_opts.MaxTime)
+	} else if maxTime := r.maxTime(c); maxTime != nil {
+		mongo_opts.SetMaxTime(*maxTime)
+	}
+	if _opts.Hint != nil {
+		mongo_opts.SetHint(_opts.Hint)
+	}
+	if _opts.Collation != nil {
+		mongo_opts.SetCollation(_opts.Collation)
+	} else if collation := r.collation(c); collation != nil {
+		mongo_opts.SetCollation(collation)
+	}
+	if _opts.BatchSize != nil {
+		mongo_opts.SetBatchSize(*_opts.BatchSize)
+	}
+
+	obs.set("pipeline", stages)
+
+	cursor, err = coll.Aggregate(c, stages, mongo_opts)
+	if err != nil {
+		return nil, wrapMongoError(err)
+	}
+	return cursor, nil
+}
+
+// unscopedStages 是读取其他集合或写入集合的阶段，不受放在最前面的 $match 限制
+var unscopedStages = map[string]bool{
+	"$lookup":      true,
+	"$graphLookup": true,
+	"$unionWith":   true,
+	"$out":         true,
+	"$merge":       true,
+}
+
+// checkPipeline 检查 pipeline 中是否有不受限制的阶段，$facet 的子管道也要检查
+func checkPipeline(pipeline mongo.Pipeline) error {
+	for _, stage := range pipeline {
+		for _, e := range stage {
+			if unscopedStages[e.Key] {
+				return fmt.Errorf("%w: %s", ErrPipelineStage, e.Key)
+			}
+			if e.Key != "$facet" {
+				continue
+			}
+
+			facets, err := toPipelines(e.Value)
+			if err != nil {
+				return err
+			}
+			for _, facet := range facets {
+				if err := checkPipeline(facet); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// toPipelines 把 $facet 的值转换为子管道，值可能是 bson.D、bson.M 或其他可编码的类型
+func toPipelines(v any) ([]mongo.Pipeline, error) {
+	data, err := bson.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid $facet", ErrPipelineStage)
+	}
+
+	var facets map[string]mongo.Pipeline
+	if err := bson.Unmarshal(data, &facets); err != nil {
+		return nil, fmt.Errorf("%w: invalid $facet", ErrPipelineStage)
+	}
+
+	r := make([]mongo.Pipeline, 0, len(facets))
+	for _, facet := range facets {
+		r = append(r, facet)
+	}
+	return r, nil
+}
+
+// PipelineAll 执行聚合管道，并把结果解码为调用方指定的类型
+func PipelineAll[Result any, DTO any, CreateDTO any, UpdateDTO any](
+	c context.Context,
+	r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO],
+	pipeline mongo.Pipeline,
+	opts ...PipelineOption,
+) ([]*Result, error) {
+	cursor, err := r.Pipeline(c, pipeline, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*Result
+	err = decodeAll(c, r.encryptor, cursor, &results)
+	if err != nil {
+		return nil, wrapMongoError(err)
+	}
+	return results, nil
+}
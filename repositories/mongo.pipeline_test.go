@@ -0,0 +1,27 @@
+package repositories
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestCheckPipeline(t *testing.T) {
+	assert.NoError(t, checkPipeline(mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"age": bson.M{"$gt": 18}}}},
+		{{Key: "$facet", Value: bson.M{"count": bson.A{bson.M{"$count": "n"}}}}},
+	}))
+
+	for _, pipeline := range []mongo.Pipeline{
+		{{{Key: "$lookup", Value: bson.M{"from": "orders", "localField": "_id", "foreignField": "user_id", "as": "orders"}}}},
+		{{{Key: "$unionWith", Value: "users"}}},
+		{{{Key: "$out", Value: "copy"}}},
+		{{{Key: "$facet", Value: bson.D{{Key: "all", Value: bson.A{bson.D{{Key: "$unionWith", Value: "users"}}}}}}}},
+	} {
+		err := checkPipeline(pipeline)
+		assert.True(t, errors.Is(err, ErrPipelineStage), "%v: %v", pipeline, err)
+	}
+}
@@ -0,0 +1,85 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/duolacloud/crud-core-mongo/query"
+	"github.com/duolacloud/crud-core/types"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// RowPolicy 根据调用方和操作返回额外的 crud-core 格式的筛选条件，如 "销售只能看到自己的客户"，
+// 返回 nil 表示不限制。Get/Update/Delete 访问范围之外的行时返回 ErrNotFound
+type RowPolicy interface {
+	Filter(c context.Context, op Operation) (map[string]any, error)
+}
+
+type RowPolicyFunc func(c context.Context, op Operation) (map[string]any, error)
+
+func (f RowPolicyFunc) Filter(c context.Context, op Operation) (map[string]any, error) {
+	return f(c, op)
+}
+
+// CallerDependent 判断读取的结果是否取决于调用方(租户隔离、行级限制、字段访问规则)，
+// 缓存这样的仓储时必须按调用方分区
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) CallerDependent() bool {
+	return r.Options.Tenancy != nil || r.Options.RowPolicy != nil || r.Options.FieldPolicy != nil
+}
+
+// scope 返回当前调用必须满足的条件(如租户)，没有限制时返回 nil
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) scope(c context.Context) (bson.M, error) {
+	if r.Options.Tenancy == nil {
+		return nil, nil
+	}
+	return r.Options.Tenancy.Filter(c)
+}
+
+// scoped 把 scope 合并到 filter 中，所有读写操作的筛选条件都要经过这里
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) scoped(c context.Context, filter bson.M) (bson.M, error) {
+	scope, err := r.scope(c)
+	if err != nil {
+		return nil, err
+	}
+	if scope == nil {
+		return filter, nil
+	}
+	if len(filter) == 0 {
+		return scope, nil
+	}
+	return bson.M{"$and": bson.A{filter, scope}}, nil
+}
+
+// rowFilter 编译 RowPolicy 返回的条件，策略是可信的，不受 Guardrails 和字段访问规则的限制
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) rowFilter(c context.Context, op Operation) (bson.M, error) {
+	if r.Options.RowPolicy == nil {
+		return nil, nil
+	}
+
+	filter, err := r.Options.RowPolicy.Filter(c, op)
+	if err != nil || filter == nil {
+		return nil, err
+	}
+
+	mq, err := query.NewFilterQueryBuilder[DTO](r.Schema, false, r.filterQueryBuilderOptions(c)...).BuildQuery(&types.PageQuery{Filter: filter})
+	if err != nil {
+		return nil, err
+	}
+	return mq.FilterQuery, nil
+}
+
+// restricted 在 scoped 的基础上合并 RowPolicy 的条件，restricted 为 true 表示有行级限制
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) restricted(c context.Context, op Operation, filter bson.M) (_ bson.M, restricted bool, err error) {
+	filter, err = r.scoped(c, filter)
+	if err != nil {
+		return nil, false, err
+	}
+
+	row, err := r.rowFilter(c, op)
+	if err != nil || row == nil {
+		return filter, false, err
+	}
+	if len(filter) == 0 {
+		return row, true, nil
+	}
+	return bson.M{"$and": bson.A{filter, row}}, true, nil
+}
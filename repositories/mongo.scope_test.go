@@ -0,0 +1,116 @@
+package repositories
+
+import (
+	"context"
+	"testing"
+
+	"github.com/duolacloud/crud-core-mongo/conformance"
+	"github.com/duolacloud/crud-core-mongo/tenancy"
+	"github.com/duolacloud/crud-core/types"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type ownedDoc struct {
+	ID    string `json:"id" bson:"_id"`
+	Owner string `json:"owner" bson:"owner"`
+}
+
+func TestRestricted(t *testing.T) {
+	r := NewMongoCrudRepository[ownedDoc, ownedDoc, ownedDoc](nil, nil, nil)
+
+	filter, restricted, err := r.restricted(context.Background(), OperationGet, bson.M{"_id": "1"})
+	assert.NoError(t, err)
+	assert.False(t, restricted)
+	assert.Equal(t, bson.M{"_id": "1"}, filter)
+
+	var ops []Operation
+	r = NewMongoCrudRepository[ownedDoc, ownedDoc, ownedDoc](nil, nil, nil,
+		WithRowPolicy(RowPolicyFunc(func(c context.Context, op Operation) (map[string]any, error) {
+			ops = append(ops, op)
+			if op == OperationCount {
+				return nil, nil
+			}
+			return map[string]any{"owner": map[string]any{"eq": "u1"}}, nil
+		})),
+	)
+
+	filter, restricted, err = r.restricted(context.Background(), OperationDelete, bson.M{"_id": "1"})
+	assert.NoError(t, err)
+	assert.True(t, restricted)
+	row := bson.M{"$and": []bson.M{{"owner": bson.M{"$eq": "u1"}}}}
+	assert.Equal(t, bson.M{"$and": bson.A{bson.M{"_id": "1"}, row}}, filter)
+
+	filter, restricted, err = r.restricted(context.Background(), OperationPipeline, nil)
+	assert.NoError(t, err)
+	assert.True(t, restricted)
+	assert.Equal(t, row, filter)
+
+	filter, restricted, err = r.restricted(context.Background(), OperationCount, bson.M{})
+	assert.NoError(t, err)
+	assert.False(t, restricted)
+	assert.Equal(t, bson.M{}, filter)
+
+	assert.Equal(t, []Operation{OperationDelete, OperationPipeline, OperationCount}, ops)
+}
+
+// 需要设置 MONGODB_URI
+func TestCreateOutsideRowPolicy(t *testing.T) {
+	db := conformance.MongoDatabase(t)
+	c := context.Background()
+
+	r := NewMongoCrudRepository[conformance.User, conformance.User, conformance.User](
+		db,
+		func(c context.Context) string {
+			return "users"
+		},
+		conformance.UserSchema,
+		WithRowPolicy(RowPolicyFunc(func(c context.Context, op Operation) (map[string]any, error) {
+			return map[string]any{"country": map[string]any{"eq": "cn"}}, nil
+		})),
+	)
+
+	// 插入已经提交，范围之外的行也返回给创建者
+	u, err := r.Create(c, &conformance.User{ID: "1", Name: "a", Country: "us"})
+	assert.NoError(t, err)
+	assert.Equal(t, "us", u.Country)
+
+	_, err = r.Get(c, "1")
+	assert.ErrorIs(t, err, types.ErrNotFound)
+}
+
+func TestIsDuplicateID(t *testing.T) {
+	assert.True(t, isDuplicateID(mongo.CommandError{Code: 11000, Message: `E11000 duplicate key error collection: db.users index: _id_ dup key: { _id: "1" }`}))
+	assert.False(t, isDuplicateID(mongo.CommandError{Code: 11000, Message: `E11000 duplicate key error collection: db.users index: email_1 dup key: { email: "a" }`}))
+	assert.False(t, isDuplicateID(nil))
+}
+
+type tenantKey struct{}
+
+// 需要设置 MONGODB_URI
+func TestUpsertOtherTenant(t *testing.T) {
+	db := conformance.MongoDatabase(t)
+
+	r := NewMongoCrudRepository[conformance.User, conformance.User, conformance.User](
+		db,
+		func(c context.Context) string {
+			return "users"
+		},
+		conformance.UserSchema,
+		WithTenancy(tenancy.SharedCollection(func(c context.Context) (string, error) {
+			tenant, _ := c.Value(tenantKey{}).(string)
+			return tenant, nil
+		}, "tenant")),
+	)
+
+	t1 := context.WithValue(context.Background(), tenantKey{}, "t1")
+	t2 := context.WithValue(context.Background(), tenantKey{}, "t2")
+
+	_, err := r.Create(t1, &conformance.User{ID: "1", Name: "a"})
+	assert.NoError(t, err)
+
+	// 其他租户 upsert 相同的 _id 不能得知这一行存在
+	_, err = r.Update(t2, "1", &conformance.User{Name: "b"}, types.WithUpsert(true))
+	assert.ErrorIs(t, err, types.ErrNotFound)
+}
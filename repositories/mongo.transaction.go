@@ -0,0 +1,53 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/duolacloud/crud-core-mongo/outbox"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// WithTransaction 在事务中执行 fn，fn 内使用传入的 context 调用仓储方法即可加入同一个事务，
+// c 已经处于会话中时直接复用该会话
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) WithTransaction(c context.Context, fn func(c context.Context) error) error {
+	if mongo.SessionFromContext(c) != nil {
+		return fn(primary(c))
+	}
+
+	session, err := r.DB.Client().StartSession()
+	if err != nil {
+		return wrapMongoError(err)
+	}
+	defer session.EndSession(c)
+
+	// 事务中的读只能读主节点
+	_, err = session.WithTransaction(c, func(sc mongo.SessionContext) (any, error) {
+		return nil, fn(primary(sc))
+	})
+	return err
+}
+
+// write 执行写操作，配置了 outbox 或审计时放到事务中，保证业务数据和事件、审计记录同时提交
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) write(c context.Context, fn func(c context.Context) error) error {
+	if r.Options.Outbox == nil && r.Options.Audit == nil {
+		return fn(primary(c))
+	}
+	return r.WithTransaction(c, fn)
+}
+
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) appendOutbox(c context.Context, op outbox.Operation, id any, dto *DTO) error {
+	if r.Options.Outbox == nil || r.Options.OutboxEvents == nil {
+		return nil
+	}
+
+	var entity any
+	if dto != nil {
+		entity = dto
+	}
+
+	events, err := r.Options.OutboxEvents(c, op, id, entity)
+	if err != nil {
+		return err
+	}
+	return r.Options.Outbox.Append(c, events...)
+}
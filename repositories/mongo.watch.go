@@ -0,0 +1,285 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/duolacloud/crud-core-mongo/encryption"
+	"github.com/duolacloud/crud-core-mongo/query"
+	"github.com/duolacloud/crud-core/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type ChangeEventType string
+
+const (
+	ChangeEventInsert  ChangeEventType = "insert"
+	ChangeEventUpdate  ChangeEventType = "update"
+	ChangeEventReplace ChangeEventType = "replace"
+	ChangeEventDelete  ChangeEventType = "delete"
+)
+
+type ChangeEvent[DTO any] struct {
+	Type ChangeEventType
+	// ID 是变更文档的 _id
+	ID any
+	// DTO 是变更后的完整文档，delete 事件没有
+	DTO           *DTO
+	UpdatedFields bson.M
+	RemovedFields []string
+	ClusterTime   primitive.Timestamp
+	// ResumeToken 可以保存下来，重启后通过 WithWatchResumeAfter 继续消费
+	ResumeToken bson.Raw
+}
+
+type WatchOptions struct {
+	// Filter 是 crud-core 格式的筛选条件，作用在 fullDocument 上，delete 事件不受影响
+	Filter               map[string]any
+	ResumeAfter          bson.Raw
+	StartAfter           bson.Raw
+	StartAtOperationTime *primitive.Timestamp
+	BatchSize            *int32
+	MaxAwaitTime         *time.Duration
+}
+
+type WatchOption func(*WatchOptions)
+
+func WithWatchFilter(filter map[string]any) WatchOption {
+	return func(o *WatchOptions) {
+		o.Filter = filter
+	}
+}
+
+func WithWatchResumeAfter(token bson.Raw) WatchOption {
+	return func(o *WatchOptions) {
+		o.ResumeAfter = token
+	}
+}
+
+func WithWatchStartAfter(token bson.Raw) WatchOption {
+	return func(o *WatchOptions) {
+		o.StartAfter = token
+	}
+}
+
+func WithWatchStartAtOperationTime(t primitive.Timestamp) WatchOption {
+	return func(o *WatchOptions) {
+		o.StartAtOperationTime = &t
+	}
+}
+
+func WithWatchBatchSize(v int32) WatchOption {
+	return func(o *WatchOptions) {
+		o.BatchSize = &v
+	}
+}
+
+func WithWatchMaxAwaitTime(d time.Duration) WatchOption {
+	return func(o *WatchOptions) {
+		o.MaxAwaitTime = &d
+	}
+}
+
+// Watch 在仓储的集合上打开 change stream，共享集合的多租户模式下不会推送 delete 事件
+func (r *MongoCrudRepository[DTO, CreateDTO, UpdateDTO]) Watch(c context.Context, opts ...WatchOption) (*ChangeStream[DTO], error) {
+	var _opts WatchOptions
+	for _, o := range opts {
+		o(&_opts)
+	}
+
+	match := bson.M{
+		"operationType": bson.M{
+			"$in": bson.A{ChangeEventInsert, ChangeEventUpdate, ChangeEventReplace, ChangeEventDelete},
+		},
+	}
+
+	coll, err := r.collection(c)
+	if err != nil {
+		return nil, err
+	}
+
+	// 共享集合模式下 delete 事件无法判断所属租户，不推送
+	scope, err := r.scope(c)
+	if err != nil {
+		return nil, err
+	}
+	if scope != nil {
+		match = bson.M{
+			"$and": bson.A{
+				bson.M{"operationType": bson.M{"$ne": ChangeEventDelete}},
+				query.PrefixFields(scope, "fullDocument."),
+			},
+		}
+	}
+
+	if _opts.Filter != nil {
+		filterQueryBuilder := r.newFilterQueryBuilder(c)
+
+		mq, err := filterQueryBuilder.BuildQuery(&types.PageQuery{Filter: _opts.Filter})
+		if err != nil {
+			return nil, err
+		}
+
+		// delete 事件没有 fullDocument，无法按条件筛选，直接放行
+		match = bson.M{
+			"$and": bson.A{
+				match,
+				bson.M{
+					"$or": bson.A{
+						bson.M{"operationType": ChangeEventDelete},
+						query.PrefixFields(mq.FilterQuery, "fullDocument."),
+					},
+				},
+			},
+		}
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+	}
+
+	mongo_opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	if _opts.ResumeAfter != nil {
+		mongo_opts.SetResumeAfter(_opts.ResumeAfter)
+	}
+	if _opts.StartAfter != nil {
+		mongo_opts.SetStartAfter(_opts.StartAfter)
+	}
+	if _opts.StartAtOperationTime != nil {
+		mongo_opts.SetStartAtOperationTime(_opts.StartAtOperationTime)
+	}
+	if _opts.BatchSize != nil {
+		mongo_opts.SetBatchSize(*_opts.BatchSize)
+	}
+	if _opts.MaxAwaitTime != nil {
+		mongo_opts.SetMaxAwaitTime(*_opts.MaxAwaitTime)
+	}
+
+	stream, err := coll.Watch(c, pipeline, mongo_opts)
+	if err != nil {
+		return nil, wrapMongoError(err)
+	}
+
+	return &ChangeStream[DTO]{
+		stream:    stream,
+		encryptor: r.encryptor,
+		redact: func(c context.Context, dto *DTO) error {
+			return r.redact(c, dto)
+		},
+	}, nil
+}
+
+type ChangeStream[DTO any] struct {
+	stream    *mongo.ChangeStream
+	encryptor *encryption.Encryptor
+	redact    func(c context.Context, dto *DTO) error
+	event     *ChangeEvent[DTO]
+	err       error
+}
+
+type rawChangeEvent struct {
+	ID            bson.Raw            `bson:"_id"`
+	OperationType ChangeEventType     `bson:"operationType"`
+	ClusterTime   primitive.Timestamp `bson:"clusterTime"`
+	DocumentKey   struct {
+		ID any `bson:"_id"`
+	} `bson:"documentKey"`
+	FullDocument      bson.Raw `bson:"fullDocument"`
+	UpdateDescription *struct {
+		UpdatedFields bson.M   `bson:"updatedFields"`
+		RemovedFields []string `bson:"removedFields"`
+	} `bson:"updateDescription"`
+}
+
+// Next 阻塞等待下一个事件，返回 false 时通过 Err 查看原因
+func (s *ChangeStream[DTO]) Next(c context.Context) bool {
+	s.event = nil
+	if s.err != nil {
+		return false
+	}
+
+	if !s.stream.Next(c) {
+		return false
+	}
+
+	var raw rawChangeEvent
+	if err := s.stream.Decode(&raw); err != nil {
+		s.err = err
+		return false
+	}
+
+	event := &ChangeEvent[DTO]{
+		Type:        raw.OperationType,
+		ID:          raw.DocumentKey.ID,
+		ClusterTime: raw.ClusterTime,
+		ResumeToken: raw.ID,
+	}
+
+	if s.encryptor != nil {
+		if err := s.decrypt(c, &raw); err != nil {
+			s.err = err
+			return false
+		}
+	}
+
+	if len(raw.FullDocument) > 0 {
+		var dto DTO
+		if err := bson.Unmarshal(raw.FullDocument, &dto); err != nil {
+			s.err = err
+			return false
+		}
+		if err := s.redact(c, &dto); err != nil {
+			s.err = err
+			return false
+		}
+		event.DTO = &dto
+	}
+
+	if raw.UpdateDescription != nil {
+		event.UpdatedFields = raw.UpdateDescription.UpdatedFields
+		event.RemovedFields = raw.UpdateDescription.RemovedFields
+	}
+
+	s.event = event
+	return true
+}
+
+func (s *ChangeStream[DTO]) decrypt(c context.Context, raw *rawChangeEvent) error {
+	if len(raw.FullDocument) > 0 {
+		doc, err := s.encryptor.DecryptRaw(c, raw.FullDocument)
+		if err != nil {
+			return err
+		}
+		raw.FullDocument = doc
+	}
+
+	if raw.UpdateDescription != nil {
+		if _, err := s.encryptor.DecryptAll(c, raw.UpdateDescription.UpdatedFields); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *ChangeStream[DTO]) Event() *ChangeEvent[DTO] {
+	return s.event
+}
+
+// ResumeToken 返回最近一次消费位置，没有事件时也会随 postBatchResumeToken 前进
+func (s *ChangeStream[DTO]) ResumeToken() bson.Raw {
+	return s.stream.ResumeToken()
+}
+
+func (s *ChangeStream[DTO]) Err() error {
+	if s.err != nil {
+		return s.err
+	}
+	return wrapMongoError(s.stream.Err())
+}
+
+func (s *ChangeStream[DTO]) Close(c context.Context) error {
+	return s.stream.Close(c)
+}
@@ -9,6 +9,15 @@ import (
 
 type Schema struct {
 	FieldTypes map[string]string
+	// EncryptedFields 是 schema 中用 encrypt 标记的字段
+	EncryptedFields map[string]EncryptedField
+}
+
+// EncryptedField 对应 CSFLE schema 中的 encrypt: {bsonType, algorithm, keyId}，
+// 只借用 schema 的写法，加密由 encryption 包完成，见 encryption.Algorithm
+type EncryptedField struct {
+	Algorithm string
+	KeyID     string
 }
 
 func NewSchema(s bson.M) *Schema {
@@ -44,6 +53,12 @@ func (s *Schema) iterateProperties(parentPrefix string, properties bson.M) {
 	for field, value := range properties {
 		switch value := value.(type) {
 		case bson.M:
+			// check for encrypt，同时声明了 bsonType 时也按加密字段处理
+			if encrypt, ok := value["encrypt"].(bson.M); ok {
+				s.addEncryptedField(fmt.Sprintf("%s%s", parentPrefix, field), encrypt, value["bsonType"])
+				continue
+			}
+
 			// retrieve the type of the field
 			if bsonType, ok := value["bsonType"]; ok {
 				bsonType := bsonType.(string)
@@ -81,4 +96,31 @@ func (s *Schema) iterateProperties(parentPrefix string, properties bson.M) {
 	}
 }
 
+// addEncryptedField 记录加密字段，明文的类型取 encrypt.bsonType，
+// 没有时取字段上声明的 bsonType(binData 是密文的类型，忽略)，默认为 string
+func (s *Schema) addEncryptedField(field string, encrypt bson.M, fieldType any) {
+	if s.EncryptedFields == nil {
+		s.EncryptedFields = map[string]EncryptedField{}
+	}
+
+	bsonType, _ := encrypt["bsonType"].(string)
+	if bsonType == "" {
+		if t, _ := fieldType.(string); t != "binData" {
+			bsonType = t
+		}
+	}
+	if bsonType == "" {
+		bsonType = "string"
+	}
+	s.FieldTypes[field] = bsonType
+
+	algorithm, _ := encrypt["algorithm"].(string)
+	// keyId 只支持字符串，CSFLE 中 UUID 数组形式的 keyId 使用默认密钥
+	keyID, _ := encrypt["keyId"].(string)
+	s.EncryptedFields[field] = EncryptedField{
+		Algorithm: algorithm,
+		KeyID:     keyID,
+	}
+}
+
 type Collectioner func(c context.Context) string
@@ -0,0 +1,35 @@
+package schema
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestEncryptedFields(t *testing.T) {
+	s := NewSchema(bson.M{
+		"$jsonSchema": bson.M{
+			"properties": bson.M{
+				"id_number": bson.M{
+					"encrypt": bson.M{"bsonType": "string", "algorithm": "AEAD_AES_256_CBC_HMAC_SHA_512-Deterministic"},
+				},
+				// 同时声明了 bsonType 的加密字段
+				"level": bson.M{
+					"bsonType": "int",
+					"encrypt":  bson.M{"algorithm": "AEAD_AES_256_CBC_HMAC_SHA_512-Deterministic", "keyId": "pii"},
+				},
+				"phone": bson.M{
+					"bsonType": "binData",
+					"encrypt":  bson.M{"algorithm": "AEAD_AES_256_CBC_HMAC_SHA_512-Random"},
+				},
+			},
+		},
+	})
+
+	assert.Len(t, s.EncryptedFields, 3)
+	assert.Equal(t, "pii", s.EncryptedFields["level"].KeyID)
+	assert.Equal(t, "string", s.FieldTypes["id_number"])
+	assert.Equal(t, "int", s.FieldTypes["level"])
+	assert.Equal(t, "string", s.FieldTypes["phone"])
+}
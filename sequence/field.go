@@ -0,0 +1,60 @@
+package sequence
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Field 描述创建实体时自动分配序号的字段
+type Field struct {
+	// Name 是 bson 字段名
+	Name      string
+	Sequencer *Sequencer
+	// Sequence 是序列名
+	Sequence string
+	// Scope 返回序列的作用域(如租户)，为 nil 或返回空字符串时所有数据共用一个序列
+	Scope func(c context.Context) string
+	// Period 是按时间分段的格式(如 "2006" 每年重新计数)，为空时不分段
+	Period string
+	// Location 是计算周期使用的时区，默认 UTC
+	Location *time.Location
+	// Format 把序号格式化为字段值，为 nil 时直接保存 int64
+	Format func(period string, value int64) any
+}
+
+// Pattern 返回按 fmt 格式化的 Format，参数依次为周期和序号，如 "INV-%s-%06d"
+func Pattern(format string) func(period string, value int64) any {
+	return func(period string, value int64) any {
+		return fmt.Sprintf(format, period, value)
+	}
+}
+
+// Value 分配下一个序号并格式化
+func (f *Field) Value(c context.Context, now time.Time) (any, error) {
+	// 只拼接配置了的作用域，同一个字段的 key 段数固定
+	var scopes []string
+	if f.Scope != nil {
+		scopes = append(scopes, f.Scope(c))
+	}
+
+	var period string
+	if f.Period != "" {
+		loc := f.Location
+		if loc == nil {
+			loc = time.UTC
+		}
+		period = now.In(loc).Format(f.Period)
+		scopes = append(scopes, period)
+	}
+
+	v, err := f.Sequencer.Next(c, Key(f.Sequence, scopes...))
+	if err != nil {
+		return nil, err
+	}
+
+	if f.Format != nil {
+		return f.Format(period, v), nil
+	}
+	return v, nil
+}
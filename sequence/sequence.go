@@ -0,0 +1,194 @@
+package sequence
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/duolacloud/crud-core-mongo/utils"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type SequencerOptions struct {
+	// BlockSize 每次从数据库预分配的序号数量，大于 1 时进程重启会产生空号
+	BlockSize int64
+	// Timeout 调用方没有截止时间时，分配序号的超时时间
+	Timeout time.Duration
+	// MaxBlocks 是内存中保留的预分配序号段数量上限，超过时丢弃空闲的序号段(未用完的序号成为空号)
+	MaxBlocks int
+}
+
+type SequencerOption func(*SequencerOptions)
+
+func WithBlockSize(v int64) SequencerOption {
+	return func(o *SequencerOptions) {
+		o.BlockSize = v
+	}
+}
+
+func WithTimeout(v time.Duration) SequencerOption {
+	return func(o *SequencerOptions) {
+		o.Timeout = v
+	}
+}
+
+func WithMaxBlocks(v int) SequencerOption {
+	return func(o *SequencerOptions) {
+		o.MaxBlocks = v
+	}
+}
+
+// block 是某个 key 在内存中预分配的序号段，各 key 的锁互不影响。
+// users 是正在使用它的调用数量，由 Sequencer.mutex 保护，为 0 时可以被丢弃
+type block struct {
+	mutex sync.Mutex
+	next  int64
+	end   int64
+	users int
+}
+
+// Sequencer 基于计数器集合生成单调递增的序号，计数器文档为 {_id: key, value: 已分配的最大值}
+type Sequencer struct {
+	collection *mongo.Collection
+	options    *SequencerOptions
+	mutex      sync.Mutex
+	blocks     map[string]*block
+}
+
+func NewSequencer(collection *mongo.Collection, opts ...SequencerOption) *Sequencer {
+	s := &Sequencer{
+		collection: collection,
+		options: &SequencerOptions{
+			BlockSize: 1,
+			Timeout:   10 * time.Second,
+			MaxBlocks: 1024,
+		},
+		blocks: map[string]*block{},
+	}
+	for _, o := range opts {
+		o(s.options)
+	}
+	if s.options.BlockSize < 1 {
+		s.options.BlockSize = 1
+	}
+	return s
+}
+
+var keyEscaper = strings.NewReplacer(`\`, `\\`, ":", `\:`)
+
+// Key 拼接序列名和作用域(租户、周期等)，作用域按位置保留(包括空值)，其中的分隔符会被转义
+func Key(name string, scopes ...string) string {
+	parts := []string{keyEscaper.Replace(name)}
+	for _, scope := range scopes {
+		parts = append(parts, keyEscaper.Replace(scope))
+	}
+	return strings.Join(parts, ":")
+}
+
+func (s *Sequencer) acquire(key string) *block {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	b, ok := s.blocks[key]
+	if !ok {
+		if s.options.MaxBlocks > 0 && len(s.blocks) >= s.options.MaxBlocks {
+			s.evictIdle()
+		}
+		b = &block{next: 1}
+		s.blocks[key] = b
+	}
+	b.users++
+	return b
+}
+
+// release 在调用结束后释放序号段，用完的序号段立即删除，key 中带有周期和租户时不会无限增长
+func (s *Sequencer) release(key string, b *block) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	b.users--
+	if b.users == 0 && b.next > b.end {
+		delete(s.blocks, key)
+	}
+}
+
+// evictIdle 丢弃没有调用在使用的序号段
+func (s *Sequencer) evictIdle() {
+	for key, b := range s.blocks {
+		if b.users == 0 {
+			delete(s.blocks, key)
+		}
+	}
+}
+
+// Next 返回 key 对应序列的下一个值，从 1 开始
+func (s *Sequencer) Next(c context.Context, key string) (int64, error) {
+	b := s.acquire(key)
+	defer s.release(key, b)
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.next > b.end {
+		end, err := s.allocate(c, key, s.options.BlockSize)
+		if err != nil {
+			return 0, err
+		}
+		b.next = end - s.options.BlockSize + 1
+		b.end = end
+	}
+
+	v := b.next
+	b.next++
+	return v, nil
+}
+
+// Current 返回 key 对应序列已分配的最大值，未使用过时返回 0
+func (s *Sequencer) Current(c context.Context, key string) (int64, error) {
+	var counter struct {
+		Value int64 `bson:"value"`
+	}
+	err := s.collection.FindOne(c, bson.M{"_id": key}).Decode(&counter)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return counter.Value, nil
+}
+
+// allocate 在事务之外分配序号，事务回滚只会产生空号，不会产生重复的序号
+func (s *Sequencer) allocate(c context.Context, key string, n int64) (int64, error) {
+	var (
+		ctx    = utils.WithoutCancel(c)
+		cancel context.CancelFunc
+	)
+	if deadline, ok := c.Deadline(); ok {
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+	} else {
+		ctx, cancel = context.WithTimeout(ctx, s.options.Timeout)
+	}
+	defer cancel()
+
+	opts := options.FindOneAndUpdate().
+		SetUpsert(true).
+		SetReturnDocument(options.After)
+
+	var counter struct {
+		Value int64 `bson:"value"`
+	}
+	err := s.collection.FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": key},
+		bson.M{"$inc": bson.M{"value": n}},
+		opts,
+	).Decode(&counter)
+	if err != nil {
+		return 0, err
+	}
+	return counter.Value, nil
+}
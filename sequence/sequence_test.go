@@ -0,0 +1,46 @@
+package sequence
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKey(t *testing.T) {
+	assert.Equal(t, "inv", Key("inv"))
+	assert.Equal(t, "inv:a", Key("inv", "a"))
+	assert.Equal(t, "inv::a", Key("inv", "", "a"))
+	assert.NotEqual(t, Key("inv", "", "a"), Key("inv", "a"))
+	assert.NotEqual(t, Key("inv", "a:b"), Key("inv", "a", "b"))
+	assert.Equal(t, `inv:a\:b`, Key("inv", "a:b"))
+}
+
+func TestReleaseEvictsExhaustedBlocks(t *testing.T) {
+	s := NewSequencer(nil)
+
+	b := s.acquire("inv:2026")
+	b.next, b.end = 1, 2
+	b.next++
+	s.release("inv:2026", b)
+	assert.Len(t, s.blocks, 1, "a block with numbers left is kept")
+
+	b = s.acquire("inv:2026")
+	b.next++
+	s.release("inv:2026", b)
+	assert.Empty(t, s.blocks, "an exhausted block is dropped")
+}
+
+func TestAcquireCapsBlocks(t *testing.T) {
+	s := NewSequencer(nil, WithMaxBlocks(2))
+
+	a := s.acquire("a")
+	a.end = 10
+	s.release("a", a)
+	busy := s.acquire("b")
+	busy.end = 10
+
+	s.acquire("c")
+	assert.Len(t, s.blocks, 2)
+	assert.Contains(t, s.blocks, "b", "a block in use is not evicted")
+	assert.Contains(t, s.blocks, "c")
+}
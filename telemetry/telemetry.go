@@ -0,0 +1,100 @@
+package telemetry
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type Attributes map[string]any
+
+// Span 对应一次仓储操作，可以适配为 OpenTelemetry 的 trace.Span
+type Span interface {
+	SetAttributes(attrs Attributes)
+	End(err error)
+}
+
+// Tracer 为每次仓储操作创建 Span，返回的 context 会传给内部的调用
+type Tracer interface {
+	Start(c context.Context, name string, attrs Attributes) (context.Context, Span)
+}
+
+// Metrics 记录每次仓储操作，适配时可以同时更新计数器和耗时直方图
+type Metrics interface {
+	RecordOperation(c context.Context, operation string, collection string, duration time.Duration, count int, err error)
+}
+
+type nopTracer struct{}
+
+func NopTracer() Tracer {
+	return nopTracer{}
+}
+
+func (nopTracer) Start(c context.Context, name string, attrs Attributes) (context.Context, Span) {
+	return c, nopSpan{}
+}
+
+type nopSpan struct{}
+
+func (nopSpan) SetAttributes(attrs Attributes) {}
+func (nopSpan) End(err error)                  {}
+
+type nopMetrics struct{}
+
+func NopMetrics() Metrics {
+	return nopMetrics{}
+}
+
+func (nopMetrics) RecordOperation(c context.Context, operation string, collection string, duration time.Duration, count int, err error) {
+}
+
+// Shape 返回去掉具体值的查询结构，字段名和操作符保留，值替换为 "?"，用于 Span 属性和指标维度
+func Shape(v any) any {
+	switch t := v.(type) {
+	case bson.M:
+		return shapeMap(t)
+	case map[string]any:
+		return shapeMap(t)
+	case bson.D:
+		r := make(bson.D, len(t))
+		for i, e := range t {
+			r[i] = bson.E{Key: e.Key, Value: Shape(e.Value)}
+		}
+		return r
+	case []bson.M:
+		r := make(bson.A, len(t))
+		for i, m := range t {
+			r[i] = shapeMap(m)
+		}
+		return r
+	case bson.A:
+		return shapeSlice(t)
+	case []any:
+		return shapeSlice(t)
+	}
+	return "?"
+}
+
+func shapeMap(m map[string]any) bson.M {
+	r := make(bson.M, len(m))
+	for k, v := range m {
+		r[k] = Shape(v)
+	}
+	return r
+}
+
+// shapeSlice 中的 $and/$or 等子条件保留结构，$in 等的值列表只保留一个 "?"
+func shapeSlice(a []any) any {
+	r := bson.A{}
+	for _, e := range a {
+		switch e.(type) {
+		case bson.M, map[string]any, bson.D:
+			r = append(r, Shape(e))
+		}
+	}
+	if len(r) == 0 {
+		return "?"
+	}
+	return r
+}
@@ -0,0 +1,24 @@
+package telemetry
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestShape(t *testing.T) {
+	filter := bson.M{
+		"$and": []bson.M{
+			{"age": bson.M{"$gte": 18, "$lte": 24}},
+			{"name": bson.M{"$in": bson.A{"张三", "李四"}}},
+		},
+	}
+
+	assert.Equal(t, bson.M{
+		"$and": bson.A{
+			bson.M{"age": bson.M{"$gte": "?", "$lte": "?"}},
+			bson.M{"name": bson.M{"$in": "?"}},
+		},
+	}, Shape(filter))
+}
@@ -0,0 +1,123 @@
+package tenancy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrNoTenant       = errors.New("tenant is required")
+	ErrTenantMismatch = errors.New("tenant mismatch")
+)
+
+type Mode int
+
+const (
+	// ModeCollection 每个租户一个集合
+	ModeCollection Mode = iota
+	// ModeDatabase 每个租户一个数据库
+	ModeDatabase
+	// ModeShared 所有租户共用集合，通过租户字段隔离
+	ModeShared
+)
+
+// Resolver 从 context 中取出当前租户
+type Resolver func(c context.Context) (string, error)
+
+type Policy struct {
+	Mode     Mode
+	Resolver Resolver
+	// Field 是 ModeShared 下的租户字段
+	Field string
+	// Database 是 ModeDatabase 下租户对应的数据库
+	Database func(client *mongo.Client, tenant string) *mongo.Database
+	// Collection 是 ModeCollection 下租户对应的集合名，collection 是 Collectioner 返回的集合名
+	Collection func(tenant string, collection string) string
+}
+
+func CollectionPerTenant(resolver Resolver, collection func(tenant string, collection string) string) *Policy {
+	return &Policy{
+		Mode:       ModeCollection,
+		Resolver:   resolver,
+		Collection: collection,
+	}
+}
+
+func DatabasePerTenant(resolver Resolver, database func(client *mongo.Client, tenant string) *mongo.Database) *Policy {
+	return &Policy{
+		Mode:     ModeDatabase,
+		Resolver: resolver,
+		Database: database,
+	}
+}
+
+func SharedCollection(resolver Resolver, field string) *Policy {
+	return &Policy{
+		Mode:     ModeShared,
+		Resolver: resolver,
+		Field:    field,
+	}
+}
+
+// Tenant 返回当前租户，取不到时返回 ErrNoTenant，保证不会在没有租户的情况下访问数据
+func (p *Policy) Tenant(c context.Context) (string, error) {
+	tenant, err := p.Resolver(c)
+	if err != nil {
+		return "", err
+	}
+	if tenant == "" {
+		return "", ErrNoTenant
+	}
+	return tenant, nil
+}
+
+// Locate 返回租户对应的数据库和集合名
+func (p *Policy) Locate(c context.Context, db *mongo.Database, collection string) (*mongo.Database, string, error) {
+	tenant, err := p.Tenant(c)
+	if err != nil {
+		return nil, "", err
+	}
+
+	switch p.Mode {
+	case ModeDatabase:
+		return p.Database(db.Client(), tenant), collection, nil
+	case ModeCollection:
+		return db, p.Collection(tenant, collection), nil
+	}
+	return db, collection, nil
+}
+
+// Filter 返回 ModeShared 下需要追加到每个查询的租户条件，其它模式返回 nil
+func (p *Policy) Filter(c context.Context) (bson.M, error) {
+	if p.Mode != ModeShared {
+		return nil, nil
+	}
+
+	tenant, err := p.Tenant(c)
+	if err != nil {
+		return nil, err
+	}
+	return bson.M{p.Field: tenant}, nil
+}
+
+// Stamp 在 ModeShared 下给新文档写入租户字段，文档已经属于其它租户时返回 ErrTenantMismatch
+func (p *Policy) Stamp(c context.Context, doc bson.M) error {
+	if p.Mode != ModeShared {
+		return nil
+	}
+
+	tenant, err := p.Tenant(c)
+	if err != nil {
+		return err
+	}
+
+	if v, ok := doc[p.Field]; ok && v != nil && v != "" && v != tenant {
+		return fmt.Errorf("%w: document belongs to %v", ErrTenantMismatch, v)
+	}
+	doc[p.Field] = tenant
+	return nil
+}
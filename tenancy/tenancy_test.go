@@ -0,0 +1,51 @@
+package tenancy
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type tenantKey struct{}
+
+func resolver(c context.Context) (string, error) {
+	tenant, _ := c.Value(tenantKey{}).(string)
+	return tenant, nil
+}
+
+func TestSharedCollection(t *testing.T) {
+	p := SharedCollection(resolver, "tenant_id")
+
+	_, err := p.Filter(context.TODO())
+	assert.True(t, errors.Is(err, ErrNoTenant))
+
+	c := context.WithValue(context.TODO(), tenantKey{}, "t1")
+	filter, err := p.Filter(c)
+	assert.NoError(t, err)
+	assert.Equal(t, bson.M{"tenant_id": "t1"}, filter)
+
+	doc := bson.M{"name": "a"}
+	assert.NoError(t, p.Stamp(c, doc))
+	assert.Equal(t, "t1", doc["tenant_id"])
+
+	err = p.Stamp(c, bson.M{"tenant_id": "t2"})
+	assert.True(t, errors.Is(err, ErrTenantMismatch))
+}
+
+func TestCollectionPerTenant(t *testing.T) {
+	p := CollectionPerTenant(resolver, func(tenant string, collection string) string {
+		return tenant + "_" + collection
+	})
+
+	c := context.WithValue(context.TODO(), tenantKey{}, "t1")
+	_, name, err := p.Locate(c, nil, "users")
+	assert.NoError(t, err)
+	assert.Equal(t, "t1_users", name)
+
+	filter, err := p.Filter(c)
+	assert.NoError(t, err)
+	assert.Nil(t, filter)
+}
@@ -0,0 +1,30 @@
+package utils
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// WithoutCancel 返回保留 c 中的值(租户、trace 等)但不继承取消信号和截止时间的 context，
+// 也不带上 c 所在的会话和事务，用于需要在调用方之外继续执行的数据库操作
+func WithoutCancel(c context.Context) context.Context {
+	return detached{c}
+}
+
+type detached struct {
+	parent context.Context
+}
+
+func (d detached) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (d detached) Done() <-chan struct{}       { return nil }
+func (d detached) Err() error                  { return nil }
+
+func (d detached) Value(key any) any {
+	v := d.parent.Value(key)
+	if _, ok := v.(mongo.Session); ok {
+		return nil
+	}
+	return v
+}
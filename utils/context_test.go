@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type tenantKey struct{}
+
+func TestWithoutCancel(t *testing.T) {
+	c, cancel := context.WithTimeout(context.WithValue(context.Background(), tenantKey{}, "t1"), time.Minute)
+	cancel()
+
+	d := WithoutCancel(c)
+	assert.NoError(t, d.Err())
+	assert.Nil(t, d.Done())
+	_, ok := d.Deadline()
+	assert.False(t, ok)
+	assert.Equal(t, "t1", d.Value(tenantKey{}))
+
+	sc := mongo.NewSessionContext(context.Background(), nil)
+	assert.Nil(t, mongo.SessionFromContext(WithoutCancel(sc)))
+}